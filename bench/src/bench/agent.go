@@ -0,0 +1,494 @@
+package main
+
+import (
+	"bench/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/rpc"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 複数の bench プロセスで負荷をかける
+//
+// -agent <addr> で起動した bench (エージェント) に, -agents で指定した bench (コーディネータ) が
+// net/rpc で負荷走行を指示する.
+//   - コーディネータは /initialize と preTest を行い, 負荷走行を各エージェントに任せる
+//   - エージェントはコーディネータと同じシナリオと負荷レベルの制御で, エージェント毎に違う乱数の種から
+//     ルームを作って負荷をかける
+//   - コーディネータは agentPollInterval 毎に各エージェントからゲームログと計測値を集め,
+//     自分のゲームログと計測値に足す
+// 負荷走行中と負荷走行後の検証, スコア, 時系列の記録はコーディネータが1つのプロセスのときと同じように行う.
+// エージェントは1つのコーディネータの接続だけを受け付け, 接続が切れたら終了する.
+
+const agentPollInterval = time.Second
+
+// 負荷走行が終わってからエージェントが止まるまで待つ時間
+const agentStopTimeout = 30 * time.Second
+
+// コーディネータとエージェントの時計のずれの許容範囲. 超える場合は警告する
+const agentClockSkew = time.Second
+
+type AgentStartArgs struct {
+	Index        int
+	Seed         int64
+	Remotes      []string
+	Balance      string
+	Weights      []int
+	Duration     time.Duration
+	Scenario     string
+	ScenarioFile []byte // Scenario がファイルの場合はその内容
+	Controller   string
+	LoadControl  loadControlParams
+	NoLevelup    bool
+	ResumeRetry  int
+	ChaosRate    float64
+	ChaosFaults  []string
+	DebugName    bool
+	StrictCache  bool
+}
+
+type AgentPollReply struct {
+	Now         time.Time
+	Done        bool
+	Err         string // 負荷走行の開始に失敗した
+	FormatError string
+	LoadLevel   int
+	Logs        []string      // 前回から増えた loadLogs
+	GameLogs    []gameLogDump // 前回から増えたゲームログ
+	Counters    []agentSample // 累計
+	Gauges      []agentSample
+	Histograms  []agentSample
+	Errors      []BenchError // Done のときだけ
+}
+
+type agentSample struct {
+	Name      string
+	Values    []string
+	Value     int64
+	Histogram metrics.HistogramSnapshot
+}
+
+func (s agentSample) key() string {
+	return s.Name + "|" + strings.Join(s.Values, "|")
+}
+
+// エージェントからコーディネータに送る計測値
+var (
+	agentCounterVecs = map[string]*metrics.CounterVec{
+		"client_open_total":      clientOpenTotal,
+		"client_close_total":     clientCloseTotal,
+		"client_reconnect_total": clientReconnectTotal,
+		"client_resume_total":    clientResumeTotal,
+		"client_response_total":  clientResponseTotal,
+		"bench_error_total":      benchErrorTotal,
+		"json_cache_total":       jsonCacheTotal,
+		"remote_open_total":      remoteOpenTotal,
+		"remote_error_total":     remoteErrorTotal,
+		"remote_http_total":      remoteHTTPTotal,
+		"chaos_injected_total":   chaosInjectedTotal,
+	}
+	agentCounters = map[string]*metrics.Counter{
+		"client_request_total": clientRequestTotal,
+		"client_error_total":   clientErrorTotal,
+		"client_timeout_total": clientTimeoutTotal,
+	}
+	agentGaugeVecs = map[string]*metrics.GaugeVec{
+		"client_active": clientActive,
+		"remote_active": remoteActive,
+	}
+	agentHistogramVecs = map[string]*metrics.HistogramVec{
+		"latency_response_seconds": latencyResponse,
+		"latency_status_seconds":   latencyStatus,
+		"latency_remote_seconds":   latencyRemote,
+	}
+	agentHistograms = map[string]*metrics.Histogram{
+		"status_interval_seconds": statusInterval,
+	}
+)
+
+type Agent struct {
+	mtx     sync.Mutex
+	started bool
+	done    bool
+	err     error
+	cancel  context.CancelFunc
+	logs    int // 送った loadLogs の数
+}
+
+func runAgentMode(addr string) {
+	agent := new(Agent)
+	server := rpc.NewServer()
+	must(server.Register(agent))
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Println("agent listening on", l.Addr())
+
+	conn, err := l.Accept()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	l.Close()
+	log.Println("coordinator connected from", conn.RemoteAddr())
+
+	server.ServeConn(conn)
+
+	agent.mtx.Lock()
+	if agent.cancel != nil {
+		agent.cancel()
+	}
+	agent.mtx.Unlock()
+	log.Println("coordinator disconnected")
+}
+
+func (a *Agent) Start(args *AgentStartArgs, now *time.Time) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if a.started {
+		return errors.New("エージェントは1回の負荷走行毎に起動し直してください")
+	}
+	a.started = true
+
+	remoteAddrs = args.Remotes
+	if err := setRemoteBalancer(args.Balance, args.Weights); err != nil {
+		return err
+	}
+	loadScenarioName = args.Scenario
+	if args.ScenarioFile != nil {
+		dir, err := ioutil.TempDir("", "isu7f-agent")
+		if err != nil {
+			return err
+		}
+		loadScenarioName = filepath.Join(dir, filepath.Base(args.Scenario))
+		err = ioutil.WriteFile(loadScenarioName, args.ScenarioFile, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	loadControllerName = args.Controller
+	loadControl = args.LoadControl
+	noLevelup = args.NoLevelup
+	ClientResumeRetry = args.ResumeRetry
+	chaosRate = args.ChaosRate
+	if 0 < len(args.ChaosFaults) {
+		chaosFaults = args.ChaosFaults
+	}
+	genDebugRoomName = args.DebugName
+	debugRoomNamePrefix = fmt.Sprintf("agent%v-", args.Index)
+	StrictCheckCacheConflict = args.StrictCache
+	benchDuration = args.Duration
+	seedRand(args.Seed)
+	log.Println("agent", args.Index, "seed:", args.Seed, "scenario:", args.Scenario)
+
+	ctx, cancel := context.WithTimeout(context.Background(), args.Duration)
+	a.cancel = cancel
+
+	clearRecentClientError()
+	setPhase("load")
+	go func() {
+		err := benchmarkMain(ctx)
+		cancel()
+		setPhase("done")
+
+		a.mtx.Lock()
+		a.done, a.err = true, err
+		a.mtx.Unlock()
+	}()
+
+	*now = time.Now()
+	return nil
+}
+
+func (a *Agent) Stop(_ *struct{}, _ *struct{}) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	if a.cancel != nil {
+		a.cancel()
+	}
+	return nil
+}
+
+func (a *Agent) Poll(_ *struct{}, r *AgentPollReply) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	done, err := a.done, a.err
+
+	// Done なら全て集め終わっている
+	r.Now = time.Now()
+	r.Done = done
+	if err != nil {
+		r.Err = err.Error()
+	}
+	if err := getFormatError(); err != nil {
+		r.FormatError = err.Error()
+	}
+	r.LoadLevel = int(atomic.LoadInt32(&loadLevel))
+	r.Logs = getLoadLogsFrom(a.logs)
+	a.logs += len(r.Logs)
+
+	for _, room := range getRoomNameByTag("load") {
+		status, request, response := getGameLogger(room).drain()
+		if len(status) == 0 && len(request) == 0 && len(response) == 0 {
+			continue
+		}
+		r.GameLogs = append(r.GameLogs, gameLogDump{
+			Room:        room,
+			StatusLog:   status,
+			RequestLog:  request,
+			ResponseLog: response,
+		})
+	}
+
+	for name, v := range agentCounterVecs {
+		v.Each(func(values []string, c *metrics.Counter) {
+			r.Counters = append(r.Counters, agentSample{Name: name, Values: values, Value: c.Value()})
+		})
+	}
+	for name, c := range agentCounters {
+		r.Counters = append(r.Counters, agentSample{Name: name, Value: c.Value()})
+	}
+	for name, v := range agentGaugeVecs {
+		v.Each(func(values []string, g *metrics.Gauge) {
+			r.Gauges = append(r.Gauges, agentSample{Name: name, Values: values, Value: g.Value()})
+		})
+	}
+	for name, v := range agentHistogramVecs {
+		v.Each(func(values []string, h *metrics.Histogram) {
+			r.Histograms = append(r.Histograms, agentSample{Name: name, Values: values, Histogram: h.Snapshot()})
+		})
+	}
+	for name, h := range agentHistograms {
+		r.Histograms = append(r.Histograms, agentSample{Name: name, Histogram: h.Snapshot()})
+	}
+
+	if done {
+		r.Errors = getBenchErrors()
+	}
+	return nil
+}
+
+// コーディネータから見たエージェント
+type agentConn struct {
+	index  int
+	addr   string
+	client *rpc.Client
+
+	level      int
+	done       bool
+	err        string
+	errors     []BenchError
+	counters   map[string]int64 // 前回までに足した値
+	histograms map[string]metrics.HistogramSnapshot
+}
+
+func (a *agentConn) poll() (*AgentPollReply, error) {
+	r := new(AgentPollReply)
+	err := a.client.Call("Agent.Poll", &struct{}{}, r)
+	if err != nil {
+		return nil, fmt.Errorf("エージェント %v との通信に失敗しました: %v", a.addr, err)
+	}
+	return r, nil
+}
+
+// エージェントのゲームログと計測値を足す. rooms は登録済みのルーム
+func (a *agentConn) apply(r *AgentPollReply, rooms map[string]bool) {
+	for _, d := range r.GameLogs {
+		if !rooms[d.Room] {
+			registerRoomName(d.Room, "load")
+			rooms[d.Room] = true
+		}
+		g := getGameLogger(d.Room)
+		g.mtx.Lock()
+		g.status = append(g.status, d.StatusLog...)
+		g.request = append(g.request, d.RequestLog...)
+		g.response = append(g.response, d.ResponseLog...)
+		g.mtx.Unlock()
+	}
+
+	for _, s := range r.Counters {
+		d := s.Value - a.counters[s.key()]
+		a.counters[s.key()] = s.Value
+		if v, ok := agentCounterVecs[s.Name]; ok {
+			v.With(s.Values...).Add(d)
+		} else if c, ok := agentCounters[s.Name]; ok {
+			c.Add(d)
+		}
+	}
+	for _, s := range r.Gauges {
+		if v, ok := agentGaugeVecs[s.Name]; ok {
+			v.With(s.Values...).Set(s.Value)
+		}
+	}
+	for _, s := range r.Histograms {
+		d := s.Histogram.Sub(a.histograms[s.key()])
+		a.histograms[s.key()] = s.Histogram
+		if v, ok := agentHistogramVecs[s.Name]; ok {
+			v.With(s.Values...).Merge(d)
+		} else if h, ok := agentHistograms[s.Name]; ok {
+			h.Merge(d)
+		}
+	}
+
+	for _, msg := range r.Logs {
+		appendLoadLog(fmt.Sprintf("agent%v %v", a.index, msg))
+	}
+	if r.FormatError != "" && getFormatError() == nil {
+		clientFormatError.Store(fmt.Errorf("agent%v %v", a.index, r.FormatError))
+	}
+	a.level = r.LoadLevel
+	a.done = r.Done
+	a.err = r.Err
+	a.errors = r.Errors
+}
+
+// agents で負荷走行する. ctx が終わったらエージェントを止め, 残りのログを集めて返る
+func runAgents(ctx context.Context, addrs []string) error {
+	var agents []*agentConn
+	defer func() {
+		for _, a := range agents {
+			a.client.Close()
+		}
+	}()
+
+	args := AgentStartArgs{
+		Remotes:     remoteAddrs,
+		Balance:     remoteBalanceName,
+		Weights:     remoteWeights,
+		Duration:    benchDuration,
+		Scenario:    loadScenarioName,
+		Controller:  loadControllerName,
+		LoadControl: loadControl,
+		NoLevelup:   noLevelup,
+		ResumeRetry: ClientResumeRetry,
+		ChaosRate:   chaosRate,
+		ChaosFaults: chaosFaults,
+		DebugName:   genDebugRoomName,
+		StrictCache: StrictCheckCacheConflict,
+	}
+	if isScenarioFile(loadScenarioName) {
+		b, err := ioutil.ReadFile(loadScenarioName)
+		if err != nil {
+			return err
+		}
+		args.ScenarioFile = b
+	}
+
+	for i, addr := range addrs {
+		client, err := rpc.Dial("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("エージェント %v に接続できません: %v", addr, err)
+		}
+		agents = append(agents, &agentConn{
+			index:      i,
+			addr:       addr,
+			client:     client,
+			counters:   map[string]int64{},
+			histograms: map[string]metrics.HistogramSnapshot{},
+		})
+	}
+
+	for i, a := range agents {
+		args.Index = i
+		args.Seed = randSeed + int64(i) + 1
+		var now time.Time
+		t := time.Now()
+		err := a.client.Call("Agent.Start", &args, &now)
+		if err != nil {
+			return fmt.Errorf("エージェント %v で負荷走行を開始できません: %v", a.addr, err)
+		}
+		skew := now.Sub(t.Add(time.Since(t) / 2))
+		if agentClockSkew < skew || skew < -agentClockSkew {
+			log.Println("エージェント", a.addr, "の時計が", skew, "ずれています")
+		}
+		log.Println("agent", i, a.addr, "started")
+	}
+
+	rooms := map[string]bool{}
+	stopped := false
+	var stopTime time.Time
+
+	beat := time.NewTicker(agentPollInterval)
+	defer beat.Stop()
+
+	for {
+		select {
+		case <-beat.C:
+		case <-ctx.Done():
+		}
+		if !stopped && ctx.Err() != nil {
+			stopped, stopTime = true, time.Now()
+			for _, a := range agents {
+				if err := a.client.Call("Agent.Stop", &struct{}{}, &struct{}{}); err != nil {
+					log.Println(a.addr, err)
+				}
+			}
+		}
+
+		var (
+			wg      sync.WaitGroup
+			replies = make([]*AgentPollReply, len(agents))
+			errs    = make([]error, len(agents))
+		)
+		for i, a := range agents {
+			wg.Add(1)
+			go func(i int, a *agentConn) {
+				defer wg.Done()
+				replies[i], errs[i] = a.poll()
+			}(i, a)
+		}
+		wg.Wait()
+
+		done := true
+		level := 0
+		for i, a := range agents {
+			if errs[i] != nil {
+				return errs[i]
+			}
+			a.apply(replies[i], rooms)
+			done = done && a.done
+			level += a.level
+		}
+		atomic.StoreInt32(&loadLevel, int32(level))
+		loadLevelGauge.Set(int64(level))
+		if !stopped {
+			printMetrics()
+		}
+
+		for _, a := range agents {
+			if a.done && a.err != "" {
+				return fmt.Errorf("agent%v %v", a.index, a.err)
+			}
+		}
+		if done {
+			break
+		}
+		if stopped && agentStopTimeout < time.Since(stopTime) {
+			return fmt.Errorf("エージェントが負荷走行を終了しません")
+		}
+	}
+
+	for _, a := range agents {
+		mergeBenchErrors(a.errors)
+	}
+	return nil
+}
+
+func parseAgentAddrs(s string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(s, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bench/metrics"
+)
+
+// 負荷走行中の計測値. pprofPort の /metrics, /metrics.json, /metrics.txt で見られる
+
+var (
+	clientOpenTotal = metrics.NewCounterVec("client_open_total",
+		"websocket の接続数", "room")
+	clientCloseTotal = metrics.NewCounterVec("client_close_total",
+		"websocket の切断数", "room")
+	clientReconnectTotal = metrics.NewCounterVec("client_reconnect_total",
+		"シナリオが意図して切断した数", "room")
+	clientResumeTotal = metrics.NewCounterVec("client_resume_total",
+		"切断された後に接続し直した数. result は ok, ng", "room", "result")
+	clientActive = metrics.NewGaugeVec("client_active",
+		"現在の websocket の接続数", "room")
+
+	clientRequestTotal = metrics.NewCounter("client_request_total",
+		"送ったリクエスト数")
+	clientResponseTotal = metrics.NewCounterVec("client_response_total",
+		"リクエストへの応答数. result は ok, ng", "room", "action", "result")
+	clientErrorTotal = metrics.NewCounter("client_error_total",
+		"クライアントのエラー数")
+	clientTimeoutTotal = metrics.NewCounter("client_timeout_total",
+		"クライアントのエラーのうちタイムアウトの数")
+	benchErrorTotal = metrics.NewCounterVec("bench_error_total",
+		"分類毎のエラー数", "code")
+
+	jsonCacheTotal = metrics.NewCounterVec("json_cache_total",
+		"レスポンスの JSON のキャッシュ. result は hit, conflict", "kind", "result")
+
+	latencyResponse = metrics.NewHistogramVec("latency_response_seconds",
+		"リクエストを送ってから応答を受け取るまでの時間", "action")
+	latencyStatus = metrics.NewHistogramVec("latency_status_seconds",
+		"応答を受け取ってから次のステータスを受け取るまでの時間", "action")
+	statusInterval = metrics.NewHistogram("status_interval_seconds",
+		"ステータスを受け取る間隔")
+
+	validationNoResponseTotal = metrics.NewCounterVec("validation_no_response_total",
+		"応答の無い request の組み合わせを探した status の数. result は solved, failure, gave_up", "result")
+
+	remoteOpenTotal = metrics.NewCounterVec("remote_open_total",
+		"remote 毎の websocket の接続数", "remote")
+	remoteActive = metrics.NewGaugeVec("remote_active",
+		"remote 毎の現在の websocket の接続数", "remote")
+	remoteErrorTotal = metrics.NewCounterVec("remote_error_total",
+		"remote 毎のクライアントのエラー数 (接続の失敗を含む)", "remote")
+	remoteHTTPTotal = metrics.NewCounterVec("remote_http_total",
+		"remote 毎の /room の結果. result は ok, ng", "remote", "result")
+	latencyRemote = metrics.NewHistogramVec("latency_remote_seconds",
+		"remote 毎のリクエストを送ってから応答を受け取るまでの時間", "remote")
+
+	chaosInjectedTotal = metrics.NewCounterVec("chaos_injected_total",
+		"-chaos で注入した故障の数", "fault")
+
+	loadLevelGauge = metrics.NewGauge("load_level", "負荷レベル")
+)
+
+// BenchResult.Latency などでの処理時間の名前と分布
+func latencySnapshots() map[string]metrics.HistogramSnapshot {
+	res := map[string]metrics.HistogramSnapshot{}
+	latencyResponse.Each(func(values []string, h *metrics.Histogram) {
+		res["latency-response|"+values[0]] = h.Snapshot()
+	})
+	latencyStatus.Each(func(values []string, h *metrics.Histogram) {
+		res["latency-status|"+values[0]] = h.Snapshot()
+	})
+	if s := statusInterval.Snapshot(); 0 < s.Count {
+		res["status-interval"] = s
+	}
+	return res
+}
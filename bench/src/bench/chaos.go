@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 行儀の悪いクライアントを真似る故障の注入
+//
+// -chaos の確率で, 負荷走行のルームのクライアントが request を送る度に -chaos-faults のどれかを起こす.
+// 注入した故障は request のログに chaos として残し, 負荷走行中の検証はそれを前提にする.
+// 故障を注入した request とそれに続く切断のエラーは webapp のエラーとして数えず, 切断は意図した切断として負荷レベルの判定から除外する.
+const (
+	// request の送信を遅らせる. time が過去になれば失敗するだけなので検証はそのまま
+	chaosDelay = "delay"
+	// request を送った直後に接続を切る. 応答なしとして検証する
+	chaosDrop = "drop"
+	// 同じ request_id で2回送る. 2つ目も request として記録し, 2つ目の response と対応させる
+	chaosDuplicate = "duplicate"
+	// request の前に JSON として正しくないメッセージを送る. webapp は接続を切っても良い
+	chaosMalformed = "malformed"
+	// 同じルームにもう1本接続し, 最初の status を受け取ったら切る
+	chaosReconnect = "reconnect"
+)
+
+var chaosFaultNames = []string{chaosDelay, chaosDrop, chaosDuplicate, chaosMalformed, chaosReconnect}
+
+// delay で遅らせる最大の時間
+const chaosMaxDelay = 500 * time.Millisecond
+
+// フラグで指定するパラメータ
+var (
+	chaosRate   float64
+	chaosFaults = chaosFaultNames
+)
+
+// delay,drop のような故障の名前
+func parseChaosFaults(s string) ([]string, error) {
+	var faults []string
+	for _, x := range strings.Split(s, ",") {
+		x = strings.TrimSpace(x)
+		if x == "" {
+			continue
+		}
+		ok := false
+		for _, name := range chaosFaultNames {
+			ok = ok || x == name
+		}
+		if !ok {
+			return nil, fmt.Errorf("故障 %v は存在しません %v", x, chaosFaultNames)
+		}
+		faults = append(faults, x)
+	}
+	if len(faults) == 0 {
+		return nil, fmt.Errorf("-chaos-faults が空です")
+	}
+	return faults, nil
+}
+
+// 注入した故障で起きたエラー. onError に渡さない
+type chaosError struct {
+	fault string
+	err   error
+}
+
+func (e *chaosError) Error() string {
+	return fmt.Sprintf("chaos %v: %v", e.fault, e.err)
+}
+
+func isChaosError(err error) bool {
+	_, ok := err.(*chaosError)
+	return ok
+}
+
+// request を送る度に呼ぶ. 故障を起こさないなら "" を返す.
+// -seed で再現できるように, ユーザの rnd だけを使う
+func (c *client) pickFault() string {
+	if !c.chaos || c.rnd.Float64() >= chaosRate {
+		return ""
+	}
+	fault := chaosFaults[c.rnd.Intn(len(chaosFaults))]
+	chaosInjectedTotal.With(fault).Inc()
+	return fault
+}
+
+// 故障を注入した request のエラーと, それに続く切断のエラーは注入した故障によるもの.
+// 故障を注入した request に応答があれば untaint する. 切れた接続は接続し直すか閉じるだけ
+func (c *client) taint() {
+	atomic.StoreInt32(&c.tainted, 1)
+}
+
+// webapp が故障に耐えたので, 以降のエラーは webapp のエラーとして数える
+func (c *client) untaint() {
+	atomic.StoreInt32(&c.tainted, 0)
+}
+
+func (c *client) isTainted() bool {
+	return atomic.LoadInt32(&c.tainted) != 0
+}
+
+// request の一部だけの JSON
+func chaosMalformedMessage(req *GameRequest) []byte {
+	s := fmt.Sprintf(`{"request_id":%v,"action":"%v","time":`, req.RequestID, req.Action)
+	return []byte(s)
+}
+
+func runChaosReconnect(room, wsAddr string) {
+	c := new(client)
+	if err := c.Start(context.Background(), room, wsAddr); err != nil {
+		return
+	}
+	c.taint()
+	c.Close()
+}
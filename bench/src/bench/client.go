@@ -7,12 +7,12 @@ import (
 	"hash/fnv"
 	"log"
 	"math/big"
+	"math/rand"
+	"net"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"bench/counter"
-
 	"github.com/gorilla/websocket"
 )
 
@@ -24,6 +24,12 @@ var (
 
 	// GameRequest 送信完了後 対応する GameResponse を受信するまでの時間
 	ClientRequestTimeout = time.Second
+
+	// 負荷走行のルームで切断されたときに接続し直す回数. 0 なら接続し直さない
+	ClientResumeRetry = 0
+
+	// 接続し直すまでの間隔. 失敗する度に倍にする
+	ClientResumeBackoff = 100 * time.Millisecond
 )
 
 // request and client id generator
@@ -83,57 +89,98 @@ func onError(err error, param interface{}) error {
 
 	cerr := &clientError{time.Now(), err, param}
 	clientLastError.Store(cerr)
+	clientErrorTotal.Inc()
+	if isTimeout(err) {
+		clientTimeoutTotal.Inc()
+		recordError(errCodeTimeout, err, param)
+	} else {
+		recordError(errCodeWebSocket, err, param)
+	}
+	addStatusError(cerr)
 	return cerr
 }
 
+var errRequestTimeout = fmt.Errorf("request timeout")
+
+// 接続し直したので応答を受け取れなかった. 呼び出し側は同じ client で続けて良い
+var errConnectionResumed = fmt.Errorf("接続し直したので応答がありません")
+
+// Close した client は接続し直さない
+var errClientClosed = fmt.Errorf("client は閉じられています")
+
+func isTimeout(err error) bool {
+	if err == errRequestTimeout {
+		return true
+	}
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+type waitStatus struct {
+	action string
+	t      time.Time
+}
+
 // websocket client wrapper
 type client struct {
 	id       int
 	conn     *websocket.Conn
 	roomName string
 	wsAddr   string
+	remote   string // 計測値の remote. wsAddr の host
 	writeMtx sync.Mutex
 
 	mtx      sync.Mutex
 	status   *GameStatus
 	updated  time.Time
-	callback map[int]func(GameResponse)
+	callback map[int]func(GameResponse, time.Time)
+
+	// GameResponse を受信した後 まだ GameStatus を受信していないもの
+	waitStatus []waitStatus
 
 	hasher    hash.Hash64
 	closeOnce sync.Once
+
+	chaos   bool       // -chaos の故障を注入する
+	rnd     *rand.Rand // 故障を選ぶ乱数. 負荷走行のユーザの rnd を Start の前に入れておく
+	tainted int32      // 故障を注入して接続を壊した
+
+	// 接続し直しても id はそのままで, ゲームログには接続の番号 connSeq を残す.
+	// conn, connSeq, gone を変えるときは writeMtx と mtx の両方を取る
+	resume    bool
+	connSeq   int
+	connected bool
+	closed    bool
+	gone      chan struct{} // 今の接続が切れたら close する
+	resumed   chan struct{} // 接続し直している間だけ作り, 終わったら close する
+	newConn   bool          // 接続し直して最初の status は status の間隔に入れない
 }
 
 func (c *client) Start(ctx context.Context, room, wsAddr string) error {
 	c.id = genClientID()
 	c.roomName = room
 	c.wsAddr = wsAddr
-	c.callback = map[int]func(GameResponse){}
+	c.remote = wsRemote(wsAddr)
+	c.callback = map[int]func(GameResponse, time.Time){}
 	c.hasher = fnv.New64a()
 	c.closeOnce = sync.Once{}
+	c.chaos = 0 < chaosRate && c.rnd != nil && getRoomTag(room) == "load"
+	c.resume = 0 < ClientResumeRetry && getRoomTag(room) == "load"
 
-	// TODO リクエストヘッダ, レスポンスは見なくても良いか?
-	conn, _, err := websocket.DefaultDialer.Dial(wsAddr, nil)
+	conn, err := c.dial()
 	if err != nil {
 		return err
 	}
-	counter.IncKey("client-open|" + room)
 	c.conn = conn
+	c.connected = true
+	c.gone = make(chan struct{})
+	recordEvent("open", c, nil)
 
 	// 最初の一回のStatusを待つ
-	c.conn.SetReadDeadline(time.Now().Add(ClientReadTimeout))
-	_, err = c.read()
+	err = c.readFirstStatus()
 	if err != nil {
 		c.Close()
-		return onError(err, "read1")
-	}
-
-	c.mtx.Lock()
-	hasStatus := c.status != nil
-	c.mtx.Unlock()
-
-	if !hasStatus {
-		c.Close()
-		return onError(fmt.Errorf("接続後最初 GameStatus 取得に失敗"), "")
+		return c.onError(err, "read1")
 	}
 
 	go func() {
@@ -145,7 +192,10 @@ func (c *client) Start(ctx context.Context, room, wsAddr string) error {
 			c.conn.SetReadDeadline(time.Now().Add(ClientReadTimeout))
 			_, err := c.read()
 			if err != nil {
-				onError(err, "read2")
+				c.onError(err, "read2")
+				if c.resume && c.resumeConn(ctx) == nil {
+					continue
+				}
 				return
 			}
 		}
@@ -154,11 +204,171 @@ func (c *client) Start(ctx context.Context, room, wsAddr string) error {
 	return nil
 }
 
+func (c *client) dial() (*websocket.Conn, error) {
+	// TODO リクエストヘッダ, レスポンスは見なくても良いか?
+	conn, _, err := websocket.DefaultDialer.Dial(c.wsAddr, nil)
+	if err != nil {
+		remoteErrorTotal.With(c.remote).Inc()
+		return nil, recordError(errCodeConnect, err, c.wsAddr)
+	}
+	clientOpenTotal.With(c.roomName).Inc()
+	clientActive.With(c.roomName).Inc()
+	remoteOpenTotal.With(c.remote).Inc()
+	remoteActive.With(c.remote).Inc()
+	return conn, nil
+}
+
+func (c *client) readFirstStatus() error {
+	c.conn.SetReadDeadline(time.Now().Add(ClientReadTimeout))
+	v, err := c.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := v.(*GameStatus); !ok {
+		return fmt.Errorf("接続後最初 GameStatus 取得に失敗")
+	}
+	return nil
+}
+
+// 今の接続を切る. 接続し直すことがあるので client は閉じない
+func (c *client) disconnect() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.connected {
+		c.connected = false
+		clientCloseTotal.With(c.roomName).Inc()
+		clientActive.With(c.roomName).Dec()
+		remoteActive.With(c.remote).Dec()
+		if c.isTainted() {
+			clientReconnectTotal.With(c.roomName).Inc()
+		}
+		close(c.gone)
+	}
+	return c.conn.Close()
+}
+
+// 切断されたので同じルームに接続し直す. 失敗する度に間隔を倍にして ClientResumeRetry 回まで試す
+func (c *client) resumeConn(ctx context.Context) error {
+	if c.isClosed() {
+		return errClientClosed
+	}
+
+	resumed := make(chan struct{})
+	c.mtx.Lock()
+	c.resumed = resumed
+	c.mtx.Unlock()
+	defer func() {
+		c.mtx.Lock()
+		c.resumed = nil
+		c.mtx.Unlock()
+		close(resumed)
+	}()
+
+	c.disconnect()
+
+	backoff := ClientResumeBackoff
+	for i := 0; i < ClientResumeRetry; i++ {
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+		if c.isClosed() {
+			return errClientClosed
+		}
+
+		conn, err := c.dial()
+		if err != nil {
+			continue
+		}
+
+		c.writeMtx.Lock()
+		c.mtx.Lock()
+		closed := c.closed
+		if !closed {
+			c.conn = conn
+			c.connSeq++
+			c.connected = true
+			c.gone = make(chan struct{})
+			c.newConn = true
+			c.waitStatus = c.waitStatus[:0]
+			c.untaint()
+		}
+		c.mtx.Unlock()
+		c.writeMtx.Unlock()
+		if closed {
+			clientCloseTotal.With(c.roomName).Inc()
+			clientActive.With(c.roomName).Dec()
+			remoteActive.With(c.remote).Dec()
+			conn.Close()
+			break
+		}
+
+		err = c.readFirstStatus()
+		if err != nil {
+			c.onError(err, "read1")
+			c.disconnect()
+			continue
+		}
+		clientResumeTotal.With(c.roomName, "ok").Inc()
+		return nil
+	}
+	clientResumeTotal.With(c.roomName, "ng").Inc()
+	return fmt.Errorf("room %v に接続し直せませんでした", c.roomName)
+}
+
+func (c *client) isClosed() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.closed
+}
+
+// 接続し直すのを待つ. 接続し直せたら true
+func (c *client) waitResumed() bool {
+	c.mtx.Lock()
+	resumed := c.resumed
+	c.mtx.Unlock()
+	if resumed != nil {
+		<-resumed
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.connected && !c.closed
+}
+
+// 応答を待たずに conn を諦めて, 接続し直すのを待つ. 接続し直せたら errConnectionResumed を返す
+func (c *client) abandon(conn *websocket.Conn, gone chan struct{}, err error) error {
+	conn.Close()
+	<-gone
+	if c.waitResumed() {
+		return errConnectionResumed
+	}
+	return err
+}
+
+// remote 毎のエラー数も数える. 故障を注入した request とそれに続く切断のエラーは数えない
+func (c *client) onError(err error, param interface{}) error {
+	if c.isTainted() {
+		if _, ok := err.(*chaosError); ok {
+			return err
+		}
+		return &chaosError{"", err}
+	}
+	remoteErrorTotal.With(c.remote).Inc()
+	return onError(err, param)
+}
+
 func (c *client) Close() error {
 	c.closeOnce.Do(func() {
-		counter.IncKey("client-close|" + c.roomName)
+		c.mtx.Lock()
+		c.closed = true
+		c.mtx.Unlock()
+		recordEvent("close", c, nil)
 	})
-	return c.conn.Close()
+	return c.disconnect()
 }
 
 func (c *client) AddIsu(isu string, t int64) (GameRequest, GameResponse, error) {
@@ -185,19 +395,34 @@ func (c *client) BuyItem(itemID, countBought int, t int64) (GameRequest, GameRes
 }
 
 func (c *client) doRequest(req *GameRequest) (GameResponse, error) {
-	logOnRequest(c.roomName, &GameRequestLog{
-		GameRequest: req,
-		ClientID:    c.id,
-		ClientTime:  time.Now(),
-	})
+	fault := c.pickFault()
+	switch fault {
+	case chaosDelay:
+		time.Sleep(time.Duration(c.rnd.Int63n(int64(chaosMaxDelay))))
+	case chaosReconnect:
+		go runChaosReconnect(c.roomName, c.wsAddr)
+	case chaosMalformed:
+		c.taint()
+	}
+
+	// 接続し直している間は待つ
+	if c.resume {
+		c.waitResumed()
+	}
 
 	done := make(chan struct{})
 	reqID := int(req.RequestID)
 	res := GameResponse{}
+	var recvTime time.Time
 
 	c.mtx.Lock()
-	c.callback[reqID] = func(r GameResponse) {
+	// read() から呼ばれるので 次のメッセージを読む前に waitStatus に入る
+	c.callback[reqID] = func(r GameResponse, t time.Time) {
 		res = r
+		recvTime = t
+		c.mtx.Lock()
+		c.waitStatus = append(c.waitStatus, waitStatus{req.Action, t})
+		c.mtx.Unlock()
 		close(done)
 	}
 	c.mtx.Unlock()
@@ -208,35 +433,85 @@ func (c *client) doRequest(req *GameRequest) (GameResponse, error) {
 		c.mtx.Unlock()
 	}()
 
+	recordEvent("request", c, req)
+	clientRequestTotal.Inc()
+
 	c.writeMtx.Lock()
-	c.conn.SetWriteDeadline(time.Now().Add(ClientWriteTimeout))
-	err := c.conn.WriteJSON(req)
+	conn, connSeq, gone := c.conn, c.connSeq, c.gone
+	// duplicate は2つ目の request にだけ印を付ける
+	chaos := fault
+	if fault == chaosDuplicate {
+		chaos = ""
+	}
+	logOnRequest(c.roomName, &GameRequestLog{
+		GameRequest: req,
+		ClientID:    c.id,
+		ClientTime:  time.Now(),
+		Conn:        connSeq,
+		Chaos:       chaos,
+	})
+	sendTime := time.Now()
+	conn.SetWriteDeadline(sendTime.Add(ClientWriteTimeout))
+	var err error
+	if fault == chaosMalformed {
+		err = conn.WriteMessage(websocket.TextMessage, chaosMalformedMessage(req))
+	}
+	if err == nil {
+		err = conn.WriteJSON(req)
+	}
+	if err == nil && fault == chaosDuplicate {
+		logOnRequest(c.roomName, &GameRequestLog{
+			GameRequest: req,
+			ClientID:    c.id,
+			ClientTime:  time.Now(),
+			Conn:        connSeq,
+			Chaos:       chaosDuplicate,
+		})
+		err = conn.WriteJSON(req)
+	}
 	c.writeMtx.Unlock()
 
 	if err != nil {
-		return GameResponse{}, onError(err, req)
+		err = c.onError(err, req)
+		if c.resume {
+			err = c.abandon(conn, gone, err)
+		}
+		return GameResponse{}, err
+	}
+
+	if fault == chaosDrop {
+		c.taint()
+		c.Close()
+		return GameResponse{}, &chaosError{fault, fmt.Errorf("request_id = %v を送った後に切断", req.RequestID)}
+	}
+
+	// 接続し直す場合は, 応答を待っている間に切れた接続も諦める
+	var lost chan struct{}
+	if c.resume {
+		lost = gone
 	}
 
 	select {
 	case <-time.After(ClientRequestTimeout):
+		if c.resume {
+			return GameResponse{}, c.abandon(conn, gone, c.onError(errRequestTimeout, req))
+		}
 		c.Close()
-		return GameResponse{}, onError(fmt.Errorf("request timeout"), req)
+		return GameResponse{}, c.onError(errRequestTimeout, req)
+	case <-lost:
+		return GameResponse{}, c.abandon(conn, gone, fmt.Errorf("request_id = %v の応答を待っている間に切断されました", req.RequestID))
 	case <-done:
+		if fault == chaosMalformed {
+			c.untaint()
+		}
+		latencyResponse.With(req.Action).Observe(recvTime.Sub(sendTime))
+		latencyRemote.With(c.remote).Observe(recvTime.Sub(sendTime))
+
+		result := "ng"
 		if res.IsSuccess {
-			if req.Action == "addIsu" {
-				counter.IncKey("client-addisu-ok|" + c.roomName)
-				counter.IncKey("addisu-ok")
-			} else if req.Action == "buyItem" {
-				counter.IncKey("client-buyitem-ok|" + c.roomName)
-				counter.IncKey("buyitem-ok")
-			}
-		} else {
-			if req.Action == "addIsu" {
-				counter.IncKey("client-addisu-ng|" + c.roomName)
-			} else if req.Action == "buyItem" {
-				counter.IncKey("client-buyitem-ng|" + c.roomName)
-			}
+			result = "ok"
 		}
+		clientResponseTotal.With(c.roomName, req.Action, result).Inc()
 		return res, nil
 	}
 }
@@ -252,6 +527,12 @@ func (c *client) GetStatus() GameStatus {
 	return s
 }
 
+func (c *client) onFormatError(err error) {
+	err = fmt.Errorf("room %v にて %v", c.roomName, err)
+	clientFormatError.Store(err)
+	recordError(errCodeFormat, err, c.roomName)
+}
+
 func (c *client) read() (interface{}, error) {
 	_, p, err := c.conn.ReadMessage()
 	if err != nil {
@@ -261,7 +542,7 @@ func (c *client) read() (interface{}, error) {
 
 	res, err := decodeReponseJson(p, c.hasher)
 	if err != nil {
-		clientFormatError.Store(fmt.Errorf("room %v にて Jsonデコードに失敗 %v", c.roomName, err))
+		c.onFormatError(fmt.Errorf("Jsonデコードに失敗 %v", err))
 		return nil, err
 	}
 
@@ -269,7 +550,7 @@ func (c *client) read() (interface{}, error) {
 	case *GameResponse:
 		err = validateGameResponseFormat(v)
 		if err != nil {
-			clientFormatError.Store(fmt.Errorf("room %v にて %v", c.roomName, err))
+			c.onFormatError(err)
 			return nil, err
 		}
 
@@ -277,21 +558,24 @@ func (c *client) read() (interface{}, error) {
 			GameResponse: v,
 			ClientID:     c.id,
 			ClientTime:   recvTime,
+			Conn:         c.connSeq,
 		})
 
 		c.mtx.Lock()
 		f, ok := c.callback[v.RequestID]
+		// duplicate で同じ request_id の response が2回届く
+		delete(c.callback, v.RequestID)
 		c.mtx.Unlock()
 		if ok {
-			f(*v)
-		} else {
+			f(*v, recvTime)
+		} else if !c.chaos {
 			log.Println("Unknown response", v)
 		}
 		return v, err
 	case *GameStatus:
 		err = validateGameStatusFormat(v)
 		if err != nil {
-			clientFormatError.Store(fmt.Errorf("room %v にて %v", c.roomName, err))
+			c.onFormatError(err)
 			return nil, err
 		}
 
@@ -299,9 +583,18 @@ func (c *client) read() (interface{}, error) {
 			GameStatus: v,
 			ClientID:   c.id,
 			ClientTime: recvTime,
+			Conn:       c.connSeq,
 		})
 
 		c.mtx.Lock()
+		if !c.updated.IsZero() && !c.newConn {
+			statusInterval.Observe(recvTime.Sub(c.updated))
+		}
+		c.newConn = false
+		for _, w := range c.waitStatus {
+			latencyStatus.With(w.action).Observe(recvTime.Sub(w.t))
+		}
+		c.waitStatus = c.waitStatus[:0]
 		c.status = v
 		c.updated = recvTime
 		c.mtx.Unlock()
@@ -0,0 +1,567 @@
+package main
+
+import (
+	"app/game"
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// 実装間の差分テスト
+//
+// 同じシナリオを基準実装 (Go実装) と比較対象の実装それぞれの新しい部屋で実行し、
+// 以下を比較して最初に見つかった差分を報告する。
+//
+//   - 受信した GameResponse と GameStatus の列。GameResponse は基準実装の is_success と、
+//     GameStatus は基準実装で成功したリクエストから参照実装 (app/game) で計算した値と比べる
+//   - 全てのイベントが過去になった時点の items と total_power
+//   - 受信した GameStatus が全リクエストから計算される値と一致するか (preTest と同じ厳密な検証)
+//
+// 時刻は実装ごとに異なるので、リクエストの時刻はシナリオ開始時刻からの相対値で指定し、
+// 受信したメッセージの時刻も相対値にして比べる。
+
+const (
+	// シナリオ開始から最初のリクエストの基準時刻までの猶予
+	conformanceLead = 500
+
+	// 全てのイベントが過去になるまで待つ時間の上限
+	conformanceSettleTimeout = 5 * time.Second
+)
+
+type conformanceStep struct {
+	Client int
+	Action string
+
+	// 基準時刻からの相対時刻 (ミリ秒)。負の場合はリクエスト送信時の現在時刻からの相対時刻。
+	Offset int64
+
+	Isu         string
+	ItemID      int
+	CountBought int
+}
+
+func (s conformanceStep) String() string {
+	if s.Action == "addIsu" {
+		return fmt.Sprintf("client%v addIsu(isu=%v, time=%+d)", s.Client, s.Isu, s.Offset)
+	}
+	return fmt.Sprintf("client%v buyItem(item_id=%v, count_bought=%v, time=%+d)", s.Client, s.ItemID, s.CountBought, s.Offset)
+}
+
+type conformanceScenario struct {
+	Name    string
+	Clients int
+
+	// mItems を読み込んだ後に生成する
+	Steps func() []conformanceStep
+}
+
+func addIsuStep(client int, offset int64, isu string) conformanceStep {
+	return conformanceStep{Client: client, Action: "addIsu", Offset: offset, Isu: isu}
+}
+
+func buyItemStep(client int, offset int64, itemID, countBought int) conformanceStep {
+	return conformanceStep{Client: client, Action: "buyItem", Offset: offset, ItemID: itemID, CountBought: countBought}
+}
+
+var conformanceScenarios = []conformanceScenario{
+	{
+		Name:    "add-isu",
+		Clients: 2,
+		Steps: func() []conformanceStep {
+			return []conformanceStep{
+				addIsuStep(0, 100, "1"),
+				addIsuStep(0, 300, "11111111111111000000"),
+				addIsuStep(1, 300, "12345678901234500"),
+				addIsuStep(1, 600, "999999999999999999999999999999"),
+				addIsuStep(0, -1, "5"),
+			}
+		},
+	},
+	{
+		Name:    "buy-item",
+		Clients: 1,
+		Steps: func() []conformanceStep {
+			m := mItems[1]
+			return []conformanceStep{
+				addIsuStep(0, 100, fmt.Sprint(m.GetPrice(1))),
+				buyItemStep(0, 50, 1, 0),
+				buyItemStep(0, 200, 1, 0),
+				buyItemStep(0, 250, 1, 0),
+				buyItemStep(0, 300, 1, 1),
+			}
+		},
+	},
+	{
+		Name:    "buy-not-enough",
+		Clients: 1,
+		Steps: func() []conformanceStep {
+			m8 := mItems[8]
+			return []conformanceStep{
+				buyItemStep(0, 100, 8, 0),
+				buyItemStep(0, 100, 1, 1),
+				addIsuStep(0, 200, fmt.Sprint(m8.GetPrice(1))),
+				buyItemStep(0, 199, 8, 0),
+				buyItemStep(0, -1, 8, 0),
+				buyItemStep(0, 200, 8, 0),
+			}
+		},
+	},
+	{
+		Name:    "buy-many",
+		Clients: 3,
+		Steps: func() []conformanceStep {
+			steps := []conformanceStep{
+				addIsuStep(0, 100, "100000000000000000000000000000"),
+			}
+			t := int64(150)
+			for i, id := range itemIDs {
+				steps = append(steps, buyItemStep(i%3, t, id, 0))
+				t += 20
+			}
+			for i, id := range itemIDs {
+				steps = append(steps, buyItemStep((i+1)%3, t, id, 1))
+				t += 20
+			}
+			steps = append(steps, addIsuStep(2, t, "123"))
+			return steps
+		},
+	},
+}
+
+// 受信した GameResponse または GameStatus
+type conformanceMessage struct {
+	Client   int
+	Response *GameResponse
+	Status   *GameStatus // 時刻は基準時刻からの相対値
+
+	Step int // GameResponse ならその step. GameStatus なら受信する前に応答を受け取った step の数
+	Sent int // 受信する前に送った request の数
+}
+
+// 1つの実装で1つのシナリオを実行した結果
+type conformanceRun struct {
+	Room      string
+	IsSuccess []bool
+	Times     []int64 // 各リクエストの基準時刻からの相対時刻
+
+	// 受信した順の GameResponse と GameStatus
+	Stream []conformanceMessage
+
+	// 全てのイベントが過去になった時点の状態
+	Items      []Item
+	TotalPower Exponential
+	Adding     []Adding
+
+	// GameStatus の検証エラー
+	ValidateError error
+}
+
+func runConformanceScenario(ctx context.Context, remote string, sc conformanceScenario) (*conformanceRun, error) {
+	roomName := genRandomRoomName("conformance")
+	wsAddr, err := resolveWsAddrAt(remote, roomName)
+	if err != nil {
+		return nil, err
+	}
+
+	run := &conformanceRun{Room: roomName}
+
+	clients := make([]*client, sc.Clients)
+	for i := range clients {
+		c := new(client)
+		err := c.Start(ctx, roomName, wsAddr)
+		if err != nil {
+			return nil, fmt.Errorf("Room %v の接続に失敗しました. %v", roomName, err)
+		}
+		defer c.Close()
+		clients[i] = c
+	}
+
+	base := clients[0].After(conformanceLead)
+	last := base
+	reqStep := map[int]int{} // RequestID => step
+	for i, step := range sc.Steps() {
+		c := clients[step.Client]
+		t := base + step.Offset
+		if step.Offset < 0 {
+			t = c.After(step.Offset)
+		}
+		if last < t {
+			last = t
+		}
+
+		var (
+			req GameRequest
+			res GameResponse
+		)
+		switch step.Action {
+		case "addIsu":
+			req, res, err = c.AddIsu(step.Isu, t)
+		case "buyItem":
+			req, res, err = c.BuyItem(step.ItemID, step.CountBought, t)
+		default:
+			return nil, fmt.Errorf("step %v: 不明な action %v", i, step.Action)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("step %v %v のリクエストに失敗しました. %v", i, step, err)
+		}
+		reqStep[req.RequestID] = i
+		run.IsSuccess = append(run.IsSuccess, res.IsSuccess)
+		run.Times = append(run.Times, t-base)
+	}
+
+	// 全てのイベントが過去になるまで待つ
+	c := clients[0]
+	c.WaitUntil(ctx, last+1000)
+	deadline := time.Now().Add(conformanceSettleTimeout)
+	for {
+		st := c.GetStatus()
+		if last+1000 < st.Schedule[0].Time {
+			items := append([]Item(nil), st.Items...)
+			sort.Slice(items, func(i, j int) bool { return items[i].ItemID < items[j].ItemID })
+			run.Items = items
+			run.TotalPower = st.Schedule[0].TotalPower
+			run.Adding = st.Adding
+			break
+		}
+		if deadline.Before(time.Now()) {
+			return nil, fmt.Errorf("Room %v にて time = %v を過ぎた GameStatus を受信しませんでした", roomName, last+1000)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	run.ValidateError = ValidateGameLog(ctx, roomName, true)
+	run.Stream = conformanceStream(roomName, clients, reqStep, base)
+	return run, nil
+}
+
+// ゲームログから受信したメッセージを受信した順に取り出す
+func conformanceStream(roomName string, clients []*client, reqStep map[int]int, base int64) []conformanceMessage {
+	g := getGameLogger(roomName)
+	g.mtx.Lock()
+	status, request, response := g.status, g.request, g.response
+	g.mtx.Unlock()
+
+	clientIndex := map[int]int{}
+	for i, c := range clients {
+		clientIndex[c.id] = i
+	}
+
+	type received struct {
+		t time.Time
+		m conformanceMessage
+	}
+	var rs []received
+	for _, x := range response {
+		res := *x.GameResponse
+		rs = append(rs, received{x.ClientTime, conformanceMessage{Client: clientIndex[x.ClientID], Response: &res, Step: reqStep[x.RequestID]}})
+	}
+	for _, x := range status {
+		rs = append(rs, received{x.ClientTime, conformanceMessage{Client: clientIndex[x.ClientID], Status: shiftGameStatus(x.GameStatus, -base)}})
+	}
+	sort.SliceStable(rs, func(i, j int) bool { return rs[i].t.Before(rs[j].t) })
+
+	stream := make([]conformanceMessage, len(rs))
+	done := 0
+	for i, r := range rs {
+		m := r.m
+		for _, req := range request {
+			if req.ClientTime.Before(r.t) {
+				m.Sent++
+			}
+		}
+		if m.Response != nil {
+			done++
+		} else {
+			m.Step = done
+		}
+		stream[i] = m
+	}
+	return stream
+}
+
+// 時刻を d だけずらした GameStatus. on_sale の 0 (今購入できる) はそのまま
+func shiftGameStatus(st *GameStatus, d int64) *GameStatus {
+	r := &GameStatus{
+		Time:     st.Time + d,
+		Adding:   make([]Adding, len(st.Adding)),
+		Schedule: make([]Schedule, len(st.Schedule)),
+		Items:    make([]Item, len(st.Items)),
+		OnSale:   make([]OnSale, len(st.OnSale)),
+	}
+	for i, x := range st.Adding {
+		x.Time += d
+		r.Adding[i] = x
+	}
+	for i, x := range st.Schedule {
+		x.Time += d
+		r.Schedule[i] = x
+	}
+	for i, x := range st.Items {
+		x.Building = make([]Building, len(st.Items[i].Building))
+		for j, y := range st.Items[i].Building {
+			y.Time += d
+			x.Building[j] = y
+		}
+		r.Items[i] = x
+	}
+	for i, x := range st.OnSale {
+		if x.Time != 0 {
+			x.Time += d
+		}
+		r.OnSale[i] = x
+	}
+	return r
+}
+
+// 最初の k step のうち ref で成功したものを, target の時刻で送ったとした GameStatus
+func conformanceExpected(steps []conformanceStep, ref, target *conformanceRun, k int, currentTime int64) *GameStatus {
+	var (
+		isu      = map[int64]*big.Int{}
+		addings  []Adding
+		buyings  []Buying
+		bought   = map[int]int{}
+		buyingAt = map[int]map[int]int64{}
+	)
+	for i := 0; i < k; i++ {
+		if !ref.IsSuccess[i] {
+			continue
+		}
+		s, t := steps[i], target.Times[i]
+		switch s.Action {
+		case "addIsu":
+			if _, ok := isu[t]; !ok {
+				isu[t] = new(big.Int)
+			}
+			isu[t].Add(isu[t], str2big(s.Isu))
+		case "buyItem":
+			if buyingAt[s.ItemID] == nil {
+				buyingAt[s.ItemID] = map[int]int64{}
+			}
+			bought[s.ItemID]++
+			buyingAt[s.ItemID][bought[s.ItemID]] = t
+		}
+	}
+	for t, x := range isu {
+		addings = append(addings, Adding{Time: t, Isu: x.String()})
+	}
+	for _, itemID := range itemIDs {
+		for ord := 1; ord <= bought[itemID]; ord++ {
+			buyings = append(buyings, Buying{ItemID: itemID, Ordinal: ord, Time: buyingAt[itemID][ord]})
+		}
+	}
+	return game.CalcStatus(currentTime, mItems, addings, buyings)
+}
+
+// GameStatus の最初に食い違うフィールド. 一致すれば空文字列
+func diffGameStatus(x, y *GameStatus) string {
+	if len(x.Adding) != len(y.Adding) {
+		return fmt.Sprintf("len(adding) = %v, expected %v", len(x.Adding), len(y.Adding))
+	}
+	for i := range x.Adding {
+		a, b := x.Adding[i], y.Adding[i]
+		if a.Time != b.Time || str2big(a.Isu).Cmp(str2big(b.Isu)) != 0 {
+			return fmt.Sprintf("adding[%v] = %+v, expected %+v", i, a, b)
+		}
+	}
+	if len(x.Schedule) != len(y.Schedule) {
+		return fmt.Sprintf("len(schedule) = %v, expected %v", len(x.Schedule), len(y.Schedule))
+	}
+	for i := range x.Schedule {
+		a, b := x.Schedule[i], y.Schedule[i]
+		if a.Time != b.Time || !a.MilliIsu.Eq(b.MilliIsu) || !a.TotalPower.Eq(b.TotalPower) {
+			return fmt.Sprintf("schedule[%v] = %+v, expected %+v", i, a, b)
+		}
+	}
+	if len(x.Items) != len(y.Items) {
+		return fmt.Sprintf("len(items) = %v, expected %v", len(x.Items), len(y.Items))
+	}
+	items := map[int]Item{}
+	for _, b := range y.Items {
+		items[b.ItemID] = b
+	}
+	for _, a := range x.Items {
+		b, ok := items[a.ItemID]
+		switch {
+		case !ok:
+			return fmt.Sprintf("items に item_id = %v が存在するのは正しくありません", a.ItemID)
+		case a.CountBought != b.CountBought:
+			return fmt.Sprintf("item_id = %v: count_bought = %v, expected %v", a.ItemID, a.CountBought, b.CountBought)
+		case a.CountBuilt != b.CountBuilt:
+			return fmt.Sprintf("item_id = %v: count_built = %v, expected %v", a.ItemID, a.CountBuilt, b.CountBuilt)
+		case !a.NextPrice.Eq(b.NextPrice):
+			return fmt.Sprintf("item_id = %v: next_price = %v, expected %v", a.ItemID, a.NextPrice, b.NextPrice)
+		case !a.Power.Eq(b.Power):
+			return fmt.Sprintf("item_id = %v: power = %v, expected %v", a.ItemID, a.Power, b.Power)
+		case len(a.Building) != len(b.Building):
+			return fmt.Sprintf("item_id = %v: len(building) = %v, expected %v", a.ItemID, len(a.Building), len(b.Building))
+		}
+		for i := range a.Building {
+			if a.Building[i].Time != b.Building[i].Time || a.Building[i].CountBuilt != b.Building[i].CountBuilt || !a.Building[i].Power.Eq(b.Building[i].Power) {
+				return fmt.Sprintf("item_id = %v: building[%v] = %+v, expected %+v", a.ItemID, i, a.Building[i], b.Building[i])
+			}
+		}
+	}
+	onSale := map[int]int64{}
+	for _, b := range y.OnSale {
+		onSale[b.ItemID] = b.Time
+	}
+	if len(x.OnSale) != len(y.OnSale) {
+		return fmt.Sprintf("on_sale = %+v, expected %+v", x.OnSale, y.OnSale)
+	}
+	for _, a := range x.OnSale {
+		if t, ok := onSale[a.ItemID]; !ok || t != a.Time {
+			return fmt.Sprintf("on_sale = %+v, expected %+v", x.OnSale, y.OnSale)
+		}
+	}
+	return ""
+}
+
+// target の受信したメッセージを順に ref と比べ, 最初に食い違うメッセージを返す. 一致すれば空文字列.
+// GameStatus はどの step の応答の前後で計算されたか分からないので, 受信する前に応答を受け取った step の1つ前から
+// 送った step までのどれかの時点と一致すれば良い
+func diffConformanceStream(steps []conformanceStep, ref, target *conformanceRun) string {
+	for _, m := range target.Stream {
+		if m.Response != nil {
+			i := m.Step
+			if m.Response.IsSuccess != ref.IsSuccess[i] {
+				return fmt.Sprintf("step %v %v (%+dms) の GameResponse: is_success = %v, expected %v",
+					i, steps[i], target.Times[i], m.Response.IsSuccess, ref.IsSuccess[i])
+			}
+			continue
+		}
+
+		currentTime := m.Status.Schedule[0].Time
+		lo := m.Step - 1
+		if lo < 0 {
+			lo = 0
+		}
+		hi := m.Sent
+		if len(steps) < hi {
+			hi = len(steps)
+		}
+		// 食い違う場合は応答を受け取った step までの時点と比べた結果を報告する
+		diff := diffGameStatus(m.Status, conformanceExpected(steps, ref, target, m.Step, currentTime))
+		for k := hi; diff != "" && lo <= k; k-- {
+			if k != m.Step && diffGameStatus(m.Status, conformanceExpected(steps, ref, target, k, currentTime)) == "" {
+				diff = ""
+			}
+		}
+		if diff != "" {
+			after := "最初の応答の前"
+			if 0 < m.Step {
+				after = fmt.Sprintf("step %v %v の応答の後", m.Step-1, steps[m.Step-1])
+			}
+			return fmt.Sprintf("%vに client%v が受信した GameStatus (%+dms): %v", after, m.Client, currentTime, diff)
+		}
+	}
+	return ""
+}
+
+// 基準実装との最初の差分を返す。差分が無ければ空文字列。
+func diffConformanceRun(steps []conformanceStep, ref, target *conformanceRun) string {
+	if diff := diffConformanceStream(steps, ref, target); diff != "" {
+		return diff
+	}
+
+	if target.ValidateError != nil {
+		return fmt.Sprintf("GameStatus の検証に失敗しました: %v", target.ValidateError)
+	}
+
+	if len(target.Adding) != 0 {
+		return fmt.Sprintf("全ての addIsu が過去になった後の adding が空ではありません: %+v", target.Adding)
+	}
+	if !target.TotalPower.Eq(ref.TotalPower) {
+		return fmt.Sprintf("total_power = %v, expected %v", target.TotalPower, ref.TotalPower)
+	}
+	if len(target.Items) != len(ref.Items) {
+		return fmt.Sprintf("len(items) = %v, expected %v", len(target.Items), len(ref.Items))
+	}
+	for i, x := range target.Items {
+		y := ref.Items[i]
+		switch {
+		case x.ItemID != y.ItemID:
+			return fmt.Sprintf("items[%v].item_id = %v, expected %v", i, x.ItemID, y.ItemID)
+		case x.CountBought != y.CountBought:
+			return fmt.Sprintf("item_id = %v: count_bought = %v, expected %v", x.ItemID, x.CountBought, y.CountBought)
+		case x.CountBuilt != y.CountBuilt:
+			return fmt.Sprintf("item_id = %v: count_built = %v, expected %v", x.ItemID, x.CountBuilt, y.CountBuilt)
+		case !x.NextPrice.Eq(y.NextPrice):
+			return fmt.Sprintf("item_id = %v: next_price = %v, expected %v", x.ItemID, x.NextPrice, y.NextPrice)
+		case !x.Power.Eq(y.Power):
+			return fmt.Sprintf("item_id = %v: power = %v, expected %v", x.ItemID, x.Power, y.Power)
+		case len(x.Building) != 0:
+			return fmt.Sprintf("item_id = %v: 全ての buyItem が過去になった後の building が空ではありません", x.ItemID)
+		}
+	}
+	return ""
+}
+
+// ref で動いている基準実装と targets の実装で同じシナリオを実行し、差分を報告する
+func runConformance(ref string, targets []string) error {
+	// 差分テスト中だけタイムアウトを伸ばす
+	var b1, b2, b3 = ClientRequestTimeout, ClientReadTimeout, ClientWriteTimeout
+	ClientRequestTimeout, ClientReadTimeout, ClientWriteTimeout = 5*time.Second, 5*time.Second, 5*time.Second
+	defer func() {
+		ClientRequestTimeout, ClientReadTimeout, ClientWriteTimeout = b1, b2, b3
+	}()
+
+	for _, host := range append([]string{ref}, targets...) {
+		err := requestInitialize(host)
+		if err != nil {
+			return fmt.Errorf("%v の /initialize へのリクエストに失敗しました. %v", host, err)
+		}
+	}
+
+	ctx := context.Background()
+	ng := 0
+	for _, sc := range conformanceScenarios {
+		steps := sc.Steps()
+
+		formatErr := getFormatError()
+		refRun, err := runConformanceScenario(ctx, ref, sc)
+		if err == nil && getFormatError() != formatErr {
+			err = getFormatError()
+		}
+		if err == nil {
+			err = refRun.ValidateError
+		}
+		// 基準実装自身の受信したメッセージも参照実装と一致するはず
+		if err == nil {
+			if diff := diffConformanceStream(steps, refRun, refRun); diff != "" {
+				err = fmt.Errorf("%v", diff)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("基準実装 %v でシナリオ %v が失敗しました. %v", ref, sc.Name, err)
+		}
+
+		for _, target := range targets {
+			var diff string
+			formatErr := getFormatError()
+			run, err := runConformanceScenario(ctx, target, sc)
+			if err == nil && getFormatError() != formatErr {
+				err = getFormatError()
+			}
+			if err != nil {
+				diff = err.Error()
+			} else {
+				diff = diffConformanceRun(steps, refRun, run)
+			}
+
+			if diff == "" {
+				log.Printf("[OK] %v %v", target, sc.Name)
+			} else {
+				ng++
+				log.Printf("[NG] %v %v: %v", target, sc.Name, diff)
+			}
+		}
+	}
+
+	if ng > 0 {
+		return fmt.Errorf("基準実装との差分が %v 件あります", ng)
+	}
+	return nil
+}
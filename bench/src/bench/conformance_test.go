@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDiffConformanceStream(t *testing.T) {
+	setTestItems()
+	itemIDs = []int{1}
+	steps := []conformanceStep{
+		addIsuStep(0, 100, "20"),
+		buyItemStep(0, 200, 1, 0),
+		buyItemStep(0, 300, 1, 0),
+	}
+	ref := &conformanceRun{IsSuccess: []bool{true, true, false}, Times: []int64{100, 200, 300}}
+
+	// 各 step の応答の後に, その時点の GameStatus を受信した
+	stream := func(target *conformanceRun) []conformanceMessage {
+		var ms []conformanceMessage
+		for i := range steps {
+			ms = append(ms, conformanceMessage{Response: &GameResponse{IsSuccess: target.IsSuccess[i]}, Step: i, Sent: i + 1})
+			st := conformanceExpected(steps, target, target, i+1, target.Times[i]+50)
+			ms = append(ms, conformanceMessage{Status: st, Step: i + 1, Sent: i + 1})
+		}
+		return ms
+	}
+	ref.Stream = stream(ref)
+	if diff := diffConformanceStream(steps, ref, ref); diff != "" {
+		t.Fatal(diff)
+	}
+
+	// 2つ目の buyItem も成功した
+	target := &conformanceRun{IsSuccess: []bool{true, true, true}, Times: ref.Times}
+	target.Stream = stream(target)
+	diff := diffConformanceStream(steps, ref, target)
+	if !strings.Contains(diff, "step 2") || !strings.Contains(diff, "is_success = true") {
+		t.Errorf("got %q", diff)
+	}
+
+	// 応答より先に食い違う GameStatus を受信した
+	target.Stream = append(target.Stream[:4:4], target.Stream[5], target.Stream[4])
+	target.Stream[4].Step = 2
+	diff = diffConformanceStream(steps, ref, target)
+	if !strings.Contains(diff, "GameStatus (+350ms)") || !strings.Contains(diff, "step 1 ") {
+		t.Errorf("got %q", diff)
+	}
+}
+
+func TestRunConformanceRefFailure(t *testing.T) {
+	setTestItems()
+	itemIDs = []int{1}
+	// /initialize だけ成功し, /room/ は JSON を返さない基準実装
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/initialize" {
+			w.WriteHeader(204)
+			return
+		}
+		http.Error(w, "unavailable", 500)
+	}))
+	defer ts.Close()
+
+	err := runConformance(strings.TrimPrefix(ts.URL, "http://"), nil)
+	if err == nil || !strings.Contains(err.Error(), "基準実装") {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ベンチマーク中に起きたエラーの分類
+//
+// recordError で分類毎に数と最初・最後の発生時刻, 最初のエラーの内容を集計し, BenchResult.Errors に出力する.
+type errorCode string
+
+const (
+	errCodeConnect    errorCode = "connect"
+	errCodeTimeout    errorCode = "timeout"
+	errCodeHTTP       errorCode = "http"
+	errCodeWebSocket  errorCode = "websocket"
+	errCodeFormat     errorCode = "format"
+	errCodeResponse   errorCode = "response"
+	errCodeValidation errorCode = "validation"
+	errCodeStaticFile errorCode = "static_file"
+)
+
+// BenchResult.Errors に出力する順
+var errorCodes = []errorCode{
+	errCodeConnect,
+	errCodeTimeout,
+	errCodeHTTP,
+	errCodeWebSocket,
+	errCodeFormat,
+	errCodeResponse,
+	errCodeValidation,
+	errCodeStaticFile,
+}
+
+var errorCodeDescriptions = map[errorCode]string{
+	errCodeConnect:    "websocket の接続に失敗",
+	errCodeTimeout:    "タイムアウト",
+	errCodeHTTP:       "HTTP リクエストの失敗または不正なレスポンス",
+	errCodeWebSocket:  "websocket の送受信に失敗",
+	errCodeFormat:     "JSON の形式が正しくない",
+	errCodeResponse:   "リクエストの結果が期待と異なる",
+	errCodeValidation: "ゲームログの検証に失敗",
+	errCodeStaticFile: "静的ファイルが正しくない",
+}
+
+type errorStat struct {
+	count   int64
+	first   time.Time
+	last    time.Time
+	sample  string
+	context string
+}
+
+var benchErrors struct {
+	mtx   sync.Mutex
+	stats map[errorCode]*errorStat
+}
+
+// err をそのまま返すので return recordError(...) のように使う
+func recordError(code errorCode, err error, context interface{}) error {
+	if err == nil {
+		return nil
+	}
+	benchErrorTotal.With(string(code)).Inc()
+
+	now := time.Now()
+	benchErrors.mtx.Lock()
+	defer benchErrors.mtx.Unlock()
+
+	if benchErrors.stats == nil {
+		benchErrors.stats = map[errorCode]*errorStat{}
+	}
+	s, ok := benchErrors.stats[code]
+	if !ok {
+		s = &errorStat{first: now, sample: err.Error()}
+		if context != nil {
+			s.context = fmt.Sprintf("%+v", context)
+		}
+		benchErrors.stats[code] = s
+	}
+	s.count++
+	s.last = now
+	return err
+}
+
+func clearBenchErrors() {
+	benchErrors.mtx.Lock()
+	benchErrors.stats = nil
+	benchErrors.mtx.Unlock()
+}
+
+func getBenchErrors() []BenchError {
+	benchErrors.mtx.Lock()
+	defer benchErrors.mtx.Unlock()
+
+	var res []BenchError
+	for _, code := range errorCodes {
+		s, ok := benchErrors.stats[code]
+		if !ok {
+			continue
+		}
+		res = append(res, BenchError{
+			Code:        string(code),
+			Description: errorCodeDescriptions[code],
+			Count:       s.count,
+			First:       s.first,
+			Last:        s.last,
+			Sample:      s.sample,
+			Context:     s.context,
+		})
+	}
+	return res
+}
+
+// 別のプロセスで集計したエラーを合わせる. bench_error_total は数えない
+func mergeBenchErrors(errs []BenchError) {
+	benchErrors.mtx.Lock()
+	defer benchErrors.mtx.Unlock()
+
+	if benchErrors.stats == nil {
+		benchErrors.stats = map[errorCode]*errorStat{}
+	}
+	for _, e := range errs {
+		code := errorCode(e.Code)
+		s, ok := benchErrors.stats[code]
+		if !ok {
+			benchErrors.stats[code] = &errorStat{count: e.Count, first: e.First, last: e.Last, sample: e.Sample, context: e.Context}
+			continue
+		}
+		s.count += e.Count
+		if e.First.Before(s.first) {
+			s.first, s.sample, s.context = e.First, e.Sample, e.Context
+		}
+		if s.last.Before(e.Last) {
+			s.last = e.Last
+		}
+	}
+}
@@ -1,14 +1,13 @@
 package main
 
 import (
+	"app/game"
 	"compress/gzip"
 	"context"
-	"encoding/gob"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"math/big"
-	"os"
 	"sort"
 	"sync"
 	"time"
@@ -76,7 +75,7 @@ func getGameLogger(room string) *gameLogger {
 	gamelogMtx.Lock()
 	g, ok := gamelog[room]
 	if !ok {
-		g = new(gameLogger)
+		g = &gameLogger{room: room}
 		gamelog[room] = g
 	}
 	gamelogMtx.Unlock()
@@ -85,10 +84,18 @@ func getGameLogger(room string) *gameLogger {
 }
 
 type gameLogger struct {
+	room string
+
 	mtx      sync.Mutex
 	status   []*GameStatusLog
 	request  []*GameRequestLog
 	response []*GameResponseLog
+
+	// 負荷走行中の検証. 検証に渡したログは上の3つから取り除く
+	streamMtx sync.Mutex
+	stream    *streamValidator
+	// saveGameLogDump のとき stream に渡したログも残しておく
+	drained gameLogDump
 }
 
 type gameLogDump struct {
@@ -99,6 +106,35 @@ type gameLogDump struct {
 	ResponseLog []*GameResponseLog
 }
 
+// 溜まったログを取り出す
+func (g *gameLogger) drain() ([]*GameStatusLog, []*GameRequestLog, []*GameResponseLog) {
+	g.mtx.Lock()
+	status, request, response := g.status, g.request, g.response
+	g.status, g.request, g.response = nil, nil, nil
+	g.mtx.Unlock()
+
+	if saveGameLogDump != 0 {
+		g.drained.StatusLog = append(g.drained.StatusLog, status...)
+		g.drained.RequestLog = append(g.drained.RequestLog, request...)
+		g.drained.ResponseLog = append(g.drained.ResponseLog, response...)
+	}
+	return status, request, response
+}
+
+func (g *gameLogger) stepValidation(ctx context.Context) error {
+	g.streamMtx.Lock()
+	defer g.streamMtx.Unlock()
+
+	if g.stream == nil {
+		g.stream = newStreamValidator(g.room)
+	}
+	err := g.stream.add(g.drain())
+	if err != nil {
+		return err
+	}
+	return g.stream.step(ctx, time.Now().Add(-streamValidationLag), false)
+}
+
 func logOnStatus(room string, s *GameStatusLog) {
 	g := getGameLogger(room)
 
@@ -192,13 +228,12 @@ func validateStatus(status *GameStatusLog, addIsuDict map[int64]*big.Int, buyIte
 
 		itemPrice = map[int]*itemMasterElement{}
 
-		itemPower    = map[int]*big.Int{}    // ItemID => Power
-		itemOnSale   = map[int]int64{}       // ItemID => OnSale
-		itemBuilt    = map[int]int{}         // ItemID => BuiltCount
-		itemBought   = map[int]int{}         // ItemID => CountBought
-		itemBuilding = map[int][]Building{}  // ItemID => Buildings
-		itemPower0   = map[int]Exponential{} // ItemID => currentTime における Power
-		itemBuilt0   = map[int]int{}         // ItemID => currentTime における BuiltCount
+		itemPower  = map[int]*big.Int{}    // ItemID => Power
+		itemOnSale = map[int]int64{}       // ItemID => OnSale
+		itemBuilt  = map[int]int{}         // ItemID => BuiltCount
+		itemBought = map[int]int{}         // ItemID => CountBought
+		itemPower0 = map[int]Exponential{} // ItemID => currentTime における Power
+		itemBuilt0 = map[int]int{}         // ItemID => currentTime における BuiltCount
 
 		adding   = map[int64]*big.Int{}
 		buyingAt = map[int64][]Buying{}
@@ -208,7 +243,6 @@ func validateStatus(status *GameStatusLog, addIsuDict map[int64]*big.Int, buyIte
 
 	for itemID := range mItems {
 		itemPower[itemID] = big.NewInt(0)
-		itemBuilding[itemID] = []Building{}
 	}
 
 	for t, v := range addIsuDict {
@@ -271,69 +305,26 @@ func validateStatus(status *GameStatusLog, addIsuDict map[int64]*big.Int, buyIte
 		itemPower0[itemID] = big2exp(itemPower[itemID])
 		itemBuilt0[itemID] = itemBuilt[itemID]
 		itemPrice[itemID] = itemMasterObj.getData(itemID, itemBought[itemID]+1)
-		if 0 <= totalMilliIsu.Cmp(itemPrice[itemID].v1K) {
-			itemOnSale[itemID] = 0 // 0 は 時刻 currentTime で購入可能であることを表す
-		}
 	}
 
 	if totalMilliIsu.Sign() < 0 {
 		return fmt.Errorf("時刻 %v で椅子の数が負になります", currentTime)
 	}
 
-	var schedule []Schedule
-	schedule = append(schedule, Schedule{
-		Time:       currentTime,
-		MilliIsu:   big2exp(totalMilliIsu),
-		TotalPower: big2exp(totalPower),
-	})
-
 	// currentTime から 1000 ミリ秒先までシミュレーションする
-	for t := currentTime + 1; t <= currentTime+1000; t++ {
-		totalMilliIsu.Add(totalMilliIsu, totalPower)
-		updated := false
+	tl, itemBuilding := game.Simulate(game.State{
+		Time:      currentTime,
+		MilliIsu:  totalMilliIsu,
+		Power:     totalPower,
+		ItemPower: itemPower,
+		ItemBuilt: itemBuilt,
+	}, adding, buyingAt, itemMasterObj.getPower)
+	schedule := tl.Schedule()
 
-		// 時刻 t で発生する adding を計算する
-		if v, ok := adding[t]; ok {
-			updated = true
-			totalMilliIsu.Add(totalMilliIsu, new(big.Int).Mul(v, big.NewInt(1000)))
-		}
-
-		// 時刻 t で発生する buying を計算する
-		if _, ok := buyingAt[t]; ok {
-			updated = true
-			updatedID := map[int]bool{}
-			for _, b := range buyingAt[t] {
-				updatedID[b.ItemID] = true
-				power := itemMasterObj.getPower(b.ItemID, b.Ordinal)
-				itemPower[b.ItemID].Add(itemPower[b.ItemID], power)
-				itemBuilt[b.ItemID]++
-				totalPower.Add(totalPower, power)
-			}
-			for id := range updatedID {
-				itemBuilding[id] = append(itemBuilding[id], Building{
-					Time:       t,
-					CountBuilt: itemBuilt[id],
-					Power:      big2exp(itemPower[id]),
-				})
-			}
-		}
-
-		if updated {
-			schedule = append(schedule, Schedule{
-				Time:       t,
-				MilliIsu:   big2exp(totalMilliIsu),
-				TotalPower: big2exp(totalPower),
-			})
-		}
-
-		// 時刻 t で購入可能になったアイテムを記録する
-		for itemID := range mItems {
-			if _, ok := itemOnSale[itemID]; ok {
-				continue
-			}
-			if 0 <= totalMilliIsu.Cmp(itemPrice[itemID].v1K) {
-				itemOnSale[itemID] = t
-			}
+	for itemID := range mItems {
+		// 0 は 時刻 currentTime で購入可能であることを表す
+		if t, ok := tl.OnSale(itemPrice[itemID].v1K); ok {
+			itemOnSale[itemID] = t
 		}
 	}
 
@@ -402,54 +393,7 @@ func validateStatus(status *GameStatusLog, addIsuDict map[int64]*big.Int, buyIte
 	return nil
 }
 
-func calcOnSale(pr *big.Int, ts []int64, xs []*big.Int, vs []*big.Int) int64 {
-	if pr.Cmp(xs[len(xs)-1]) > 0 {
-		idx := len(ts) - 1
-		if vs[idx].Sign() > 0 {
-			z := new(big.Int).Add(xs[idx], new(big.Int).Mul(vs[idx], big.NewInt(ts[0]+1000-ts[idx])))
-			if pr.Cmp(z) > 0 {
-				return -1
-			}
-			y := new(big.Int).Sub(pr, xs[idx])
-			y.Add(y, vs[idx])
-			y.Sub(y, big.NewInt(1))
-			y.Quo(y, vs[idx])
-			if y.IsInt64() {
-				t := ts[idx] + y.Int64()
-				if t <= ts[0]+1000 {
-					return t
-				}
-			}
-		}
-		return -1
-	} else {
-		var r int64 = -1
-		for idx := 0; idx < len(ts); idx++ {
-			if pr.Cmp(xs[idx]) <= 0 {
-				return ts[idx]
-			} else {
-				if vs[idx].Sign() <= 0 {
-					continue
-				}
-				y := new(big.Int).Sub(pr, xs[idx])
-				y.Add(y, vs[idx])
-				y.Sub(y, big.NewInt(1))
-				y.Quo(y, vs[idx])
-				if y.IsInt64() {
-					t := ts[idx] + y.Int64()
-					if idx+1 < len(ts) && t >= ts[idx+1] {
-						continue
-					}
-					r = t
-					break
-				}
-			}
-		}
-		return r
-	}
-}
-
-func validateStatusBench(status *GameStatusLog, addIsuDict, addIsuDictNoRes, addIsuSum, addIsuSumNoRes map[int64]*big.Int, addIsuKeys, addIsuNoResKeys []int64, buyItemDict, buyItemDictNoRes1, buyItemDictNoRes2 map[int]map[int]int64, itemMasterObj *itemMaster) error {
+func validateStatusBench(status *GameStatusLog, addIsuDict, addIsuDictNoRes, addIsuSum, addIsuSumNoRes map[int64]*big.Int, addIsuKeys, addIsuNoResKeys []int64, buyItemDict, buyItemDictNoRes1, buyItemDictNoRes2 map[int]map[int]int64, itemMasterObj *itemMaster, exp *statusExpected) error {
 	var (
 		// 1ミリ秒に生産できる椅子の単位をミリ椅子とする
 		totalMilliIsu1 = big.NewInt(0)
@@ -458,14 +402,13 @@ func validateStatusBench(status *GameStatusLog, addIsuDict, addIsuDictNoRes, add
 
 		itemPrice = map[int]*itemMasterElement{}
 
-		itemPower    = map[int]*big.Int{}    // ItemID => Power
-		itemOnSale1  = map[int]int64{}       // ItemID => OnSale
-		itemOnSale2  = map[int]int64{}       // ItemID => OnSale
-		itemBuilt    = map[int]int{}         // ItemID => BuiltCount
-		itemBought   = map[int]int{}         // ItemID => CountBought
-		itemBuilding = map[int][]Building{}  // ItemID => Buildings
-		itemPower0   = map[int]Exponential{} // ItemID => currentTime における Power
-		itemBuilt0   = map[int]int{}         // ItemID => currentTime における BuiltCount
+		itemPower   = map[int]*big.Int{}    // ItemID => Power
+		itemOnSale1 = map[int]int64{}       // ItemID => OnSale
+		itemOnSale2 = map[int]int64{}       // ItemID => OnSale
+		itemBuilt   = map[int]int{}         // ItemID => BuiltCount
+		itemBought  = map[int]int{}         // ItemID => CountBought
+		itemPower0  = map[int]Exponential{} // ItemID => currentTime における Power
+		itemBuilt0  = map[int]int{}         // ItemID => currentTime における BuiltCount
 
 		adding   = map[int64]*big.Int{}
 		buyingAt = map[int64][]Buying{}
@@ -475,7 +418,6 @@ func validateStatusBench(status *GameStatusLog, addIsuDict, addIsuDictNoRes, add
 
 	for itemID := range mItems {
 		itemPower[itemID] = big.NewInt(0)
-		itemBuilding[itemID] = []Building{}
 	}
 
 	for i := len(addIsuKeys) - 1; i >= 0; i-- {
@@ -623,116 +565,48 @@ func validateStatusBench(status *GameStatusLog, addIsuDict, addIsuDictNoRes, add
 		itemPower0[itemID] = big2exp(itemPower[itemID])
 		itemBuilt0[itemID] = itemBuilt[itemID]
 		itemPrice[itemID] = itemMasterObj.getData(itemID, itemBought[itemID]+1)
-		if 0 <= totalMilliIsu1.Cmp(itemPrice[itemID].v1K) {
-			itemOnSale1[itemID] = 0 // 0 は 時刻 currentTime で購入可能であることを表す
-		}
-		if 0 <= totalMilliIsu2.Cmp(itemPrice[itemID].v1K) {
-			itemOnSale2[itemID] = 0 // 0 は 時刻 currentTime で購入可能であることを表す
-		}
 	}
 
 	if totalMilliIsu2.Sign() < 0 {
 		return fmt.Errorf("時刻 %v で椅子の数が負になります", currentTime)
 	}
 
-	var ts []int64
-	var x1s []*big.Int
-	var x2s []*big.Int
-	var vs []*big.Int
-	ts = append(ts, currentTime)
-	x1s = append(x1s, new(big.Int).Set(totalMilliIsu1))
-	x2s = append(x2s, new(big.Int).Set(totalMilliIsu2))
-	vs = append(vs, new(big.Int).Set(totalPower))
-
-	// currentTime から 1000 ミリ秒先までシミュレーションする
-	timeMap := make(map[int]bool)
-	for t := range adding {
-		tt := t - currentTime
-		if 0 < tt && tt <= 1000 {
-			timeMap[int(tt)] = true
-		}
-	}
-	for t := range buyingAt {
-		tt := t - currentTime
-		if 0 < tt && tt <= 1000 {
-			timeMap[int(tt)] = true
-		}
-	}
-	var timeList []int
-	for t := range timeMap {
-		timeList = append(timeList, t)
-	}
-	sort.Ints(timeList)
-	t1 := currentTime
-	for _, ii := range timeList {
-		t := currentTime + int64(ii)
-		y := new(big.Int).Mul(totalPower, big.NewInt(t-t1))
-		totalMilliIsu1.Add(totalMilliIsu1, y)
-		totalMilliIsu2.Add(totalMilliIsu2, y)
-		t1 = t
-
-		// 時刻 t で発生する adding を計算する
-		if v, ok := adding[t]; ok {
-			totalMilliIsu1.Add(totalMilliIsu1, new(big.Int).Mul(v, big.NewInt(1000)))
-			totalMilliIsu2.Add(totalMilliIsu2, new(big.Int).Mul(v, big.NewInt(1000)))
-		}
-
-		// 時刻 t で発生する buying を計算する
-		if _, ok := buyingAt[t]; ok {
-			updatedID := map[int]bool{}
-			for _, b := range buyingAt[t] {
-				updatedID[b.ItemID] = true
-				power := itemMasterObj.getPower(b.ItemID, b.Ordinal)
-				itemPower[b.ItemID].Add(itemPower[b.ItemID], power)
-				itemBuilt[b.ItemID]++
-				totalPower.Add(totalPower, power)
-			}
-			for id := range updatedID {
-				itemBuilding[id] = append(itemBuilding[id], Building{
-					Time:       t,
-					CountBuilt: itemBuilt[id],
-					Power:      big2exp(itemPower[id]),
-				})
-			}
-		}
-
-		ts = append(ts, t)
-		x1s = append(x1s, new(big.Int).Set(totalMilliIsu1))
-		x2s = append(x2s, new(big.Int).Set(totalMilliIsu2))
-		vs = append(vs, new(big.Int).Set(totalPower))
-	}
+	// currentTime から 1000 ミリ秒先までシミュレーションする.
+	// 応答が無かった request の分だけ椅子の数が幅を持つが, 生産力とその後の推移は同じ
+	tl1, itemBuilding := game.Simulate(game.State{
+		Time:      currentTime,
+		MilliIsu:  totalMilliIsu1,
+		Power:     totalPower,
+		ItemPower: itemPower,
+		ItemBuilt: itemBuilt,
+	}, adding, buyingAt, itemMasterObj.getPower)
+	tl2 := tl1.Shift(new(big.Int).Sub(totalMilliIsu2, totalMilliIsu1))
 
 	var schedule1 []Schedule
 	var schedule2 []Exponential
-	for i := 0; i < len(ts); i++ {
-		var milliIsu1 Exponential
-		if x1s[i].Sign() < 0 {
-			milliIsu1 = big2exp(big.NewInt(0))
-		} else {
-			milliIsu1 = big2exp(x1s[i])
+	for i, s := range tl1.Schedule() {
+		if tl1.MilliIsu[i].Sign() < 0 {
+			s.MilliIsu = big2exp(big.NewInt(0))
 		}
-		schedule1 = append(schedule1, Schedule{
-			Time:       ts[i],
-			MilliIsu:   milliIsu1,
-			TotalPower: big2exp(vs[i]),
-		})
-		schedule2 = append(schedule2, big2exp(x2s[i]))
+		schedule1 = append(schedule1, s)
+		schedule2 = append(schedule2, big2exp(tl2.MilliIsu[i]))
 	}
 
 	for itemID := range mItems {
+		// 0 は 時刻 currentTime で購入可能であることを表す
 		pr := itemPrice[itemID].v1K
-		if _, ok := itemOnSale1[itemID]; !ok {
-			if t := calcOnSale(pr, ts, x1s, vs); t >= 0 {
-				itemOnSale1[itemID] = t
-			}
+		if t, ok := tl1.OnSale(pr); ok {
+			itemOnSale1[itemID] = t
 		}
-		if _, ok := itemOnSale2[itemID]; !ok {
-			if t := calcOnSale(pr, ts, x2s, vs); t >= 0 {
-				itemOnSale2[itemID] = t
-			}
+		if t, ok := tl2.OnSale(pr); ok {
+			itemOnSale2[itemID] = t
 		}
 	}
 
+	if exp != nil {
+		exp.set(schedule1, schedule2, itemBought, itemBuilt0, itemPrice, itemPower0, itemBuilding, itemOnSale1, itemOnSale2)
+	}
+
 	if len(schedule1) != len(schedule2) {
 		return fmt.Errorf("something wrong 1")
 	}
@@ -816,29 +690,7 @@ func validateStatusBench(status *GameStatusLog, addIsuDict, addIsuDictNoRes, add
 	return nil
 }
 
-func readGameLogGob(path string) (*gameLogDump, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-
-	g, err := gzip.NewReader(f)
-	if err != nil {
-		return nil, err
-	}
-	defer g.Close()
-
-	dst := new(gameLogDump)
-	err = gob.NewDecoder(g).Decode(dst)
-	if err != nil {
-		return nil, err
-	}
-
-	return dst, nil
-}
-
-func dumpGameLogGob(name string, data gameLogDump) error {
+func dumpGameLog(name string, data gameLogDump) error {
 	f, err := ioutil.TempFile("", fmt.Sprintf("isu7f-gamelog-%v", name))
 	if err != nil {
 		return err
@@ -846,9 +698,10 @@ func dumpGameLogGob(name string, data gameLogDump) error {
 	defer f.Close()
 
 	g := gzip.NewWriter(f)
-	defer g.Close()
-
-	err = gob.NewEncoder(g).Encode(data)
+	err = writeGameLog(g, &data)
+	if err == nil {
+		err = g.Close()
+	}
 	if err != nil {
 		log.Println("Failed to save gamelog dump:", f.Name())
 	} else {
@@ -860,20 +713,38 @@ func dumpGameLogGob(name string, data gameLogDump) error {
 func ValidateGameLog(ctx context.Context, room string, isPreTest bool) error {
 	g := getGameLogger(room)
 
-	g.mtx.Lock()
 	var (
-		status   = g.status
-		request  = g.request
-		response = g.response
+		status   []*GameStatusLog
+		request  []*GameRequestLog
+		response []*GameResponseLog
+		err      error
 	)
-	g.mtx.Unlock()
+	if isPreTest {
+		g.mtx.Lock()
+		status, request, response = g.status, g.request, g.response
+		g.mtx.Unlock()
 
-	log.Println("ValidateGameLog", room, len(status), len(request), len(response))
-	err := validateGameLog(ctx, isPreTest, status, request, response)
+		log.Println("ValidateGameLog", room, len(status), len(request), len(response))
+		err = validateGameLog(ctx, room, isPreTest, status, request, response)
+	} else {
+		// 負荷走行中に検証していない残りを検証する
+		g.streamMtx.Lock()
+		if g.stream == nil {
+			g.stream = newStreamValidator(room)
+		}
+		status, request, response = g.drain()
+		log.Println("ValidateGameLog", room, len(status), len(request), len(response), "validated:", g.stream.validated)
+		err = g.stream.add(status, request, response)
+		if err == nil {
+			err = g.stream.finish(ctx)
+		}
+		status, request, response = g.drained.StatusLog, g.drained.RequestLog, g.drained.ResponseLog
+		g.streamMtx.Unlock()
+	}
 	log.Println("ValidateGameLog end", room)
 
 	if (err != nil && saveGameLogDump == 1) || saveGameLogDump == 2 {
-		dumpGameLogGob(room, gameLogDump{
+		dumpGameLog(room, gameLogDump{
 			Room:        room,
 			IsPreTest:   isPreTest,
 			StatusLog:   status,
@@ -881,15 +752,21 @@ func ValidateGameLog(ctx context.Context, room string, isPreTest bool) error {
 			ResponseLog: response,
 		})
 	}
-	if err != nil {
+	if err == context.Canceled {
 		return err
 	}
+	if err == context.DeadlineExceeded {
+		err = fmt.Errorf("ゲームログの検証がタイムアウトしました")
+	}
+	if err != nil {
+		return recordError(errCodeValidation, err, room)
+	}
 
 	return nil
 }
 
 func ValidateGameLogDump(path string) error {
-	g, err := readGameLogGob(path)
+	g, err := readGameLogFile(path)
 	if err != nil {
 		return err
 	}
@@ -901,8 +778,13 @@ func ValidateGameLogDump(path string) error {
 	)
 
 	log.Println("ValidateGameLogDump", g.Room, len(status), len(request), len(response))
-	err = validateGameLog(context.Background(), g.IsPreTest, status, request, response)
+	err = validateGameLog(context.Background(), g.Room, g.IsPreTest, status, request, response)
 	if err != nil {
+		if r := getValidationReport(); r != nil {
+			if err := writeValidationReport(path, r); err != nil {
+				log.Println(err)
+			}
+		}
 		return err
 	}
 
@@ -910,32 +792,47 @@ func ValidateGameLogDump(path string) error {
 	return nil
 }
 
-func validateGameLog(ctx context.Context, isPreTest bool, status []*GameStatusLog, request []*GameRequestLog, response []*GameResponseLog) error {
-	statusDict := make(map[int][]*GameStatusLog)
-	for _, st := range status {
-		if len(st.Schedule) <= 0 {
-			return fmt.Errorf("scheduleが空です")
+func validateStatusFormat(st *GameStatusLog) error {
+	if len(st.Schedule) <= 0 {
+		return fmt.Errorf("scheduleが空です")
+	}
+	for i := 0; i < len(st.Schedule)-1; i++ {
+		if st.Schedule[i].Time >= st.Schedule[i+1].Time {
+			return fmt.Errorf("schedule の順序が正しくありません : schedule[%v].time = %v, schedule[%v].time = %v",
+				i, st.Schedule[i].Time, i+1, st.Schedule[i+1].Time)
 		}
-		for i := 0; i < len(st.Schedule)-1; i++ {
-			if st.Schedule[i].Time >= st.Schedule[i+1].Time {
-				return fmt.Errorf("schedule の順序が正しくありません : schedule[%v].time = %v, schedule[%v].time = %v",
-					i, st.Schedule[i].Time, i+1, st.Schedule[i+1].Time)
-			}
+	}
+	if len(st.Items) != len(mItems) {
+		return fmt.Errorf("items の要素数が正しくありません : actual %v, expected %v", len(st.Items), len(mItems))
+	}
+	a := make(map[int]bool)
+	for _, x := range st.Items {
+		if _, ok := a[x.ItemID]; ok {
+			return fmt.Errorf("items に同一のitem_idの要素が存在します : item_id = %v", x.ItemID)
 		}
-		if len(st.Items) != len(mItems) {
-			return fmt.Errorf("items の要素数が正しくありません : actual %v, expected %v", len(st.Items), len(mItems))
+		if _, ok := mItems[x.ItemID]; !ok {
+			return fmt.Errorf("items に正しくないitem_idの要素が存在します : item_id = %v", x.ItemID)
 		}
-		a := make(map[int]bool)
-		for _, x := range st.Items {
-			if _, ok := a[x.ItemID]; ok {
-				return fmt.Errorf("items に同一のitem_idの要素が存在します : item_id = %v", x.ItemID)
-			}
-			if _, ok := mItems[x.ItemID]; !ok {
-				return fmt.Errorf("items に正しくないitem_idの要素が存在します : item_id = %v", x.ItemID)
-			}
-			a[x.ItemID] = true
+		a[x.ItemID] = true
+	}
+	return nil
+}
+
+func validateGameLog(ctx context.Context, room string, isPreTest bool, status []*GameStatusLog, request []*GameRequestLog, response []*GameResponseLog) error {
+	if !isPreTest {
+		v := newStreamValidator(room)
+		err := v.add(status, request, response)
+		if err != nil {
+			return err
 		}
+		return v.finish(ctx)
+	}
 
+	statusDict := make(map[int][]*GameStatusLog)
+	for _, st := range status {
+		if err := validateStatusFormat(st); err != nil {
+			return err
+		}
 		statusDict[st.ClientID] = append(statusDict[st.ClientID], st)
 	}
 	for _, a := range statusDict {
@@ -953,139 +850,70 @@ func validateGameLog(ctx context.Context, isPreTest bool, status []*GameStatusLo
 	}
 
 	addIsuDict := make(map[int64]*big.Int)
-	addIsuDictNoRes := make(map[int64]*big.Int)
 	buyItemDict := make(map[int]map[int]int64)
-	buyItemDictNoRes1 := make(map[int]map[int]int64)
-	buyItemDictNoRes2 := make(map[int]map[int]int64)
 	for itemID := range mItems {
 		buyItemDict[itemID] = make(map[int]int64)
-		buyItemDictNoRes1[itemID] = make(map[int]int64)
-		buyItemDictNoRes2[itemID] = make(map[int]int64)
 	}
 	for _, req := range request {
 		res, ok := responseDict[req.RequestID]
 		if !ok {
-			if isPreTest {
-				return fmt.Errorf("request_id = %v に対するresponseが存在しません", req.RequestID)
-			} else {
-				if req.Action == "addIsu" {
-					if _, ok := addIsuDictNoRes[req.Time]; !ok {
-						addIsuDictNoRes[req.Time] = new(big.Int)
-					}
-					addIsuDictNoRes[req.Time].Add(addIsuDictNoRes[req.Time], str2big(req.Isu))
-				} else if req.Action == "buyItem" {
-					if t, ok := buyItemDictNoRes1[req.ItemID][req.CountBought]; !ok || req.Time < t {
-						buyItemDictNoRes1[req.ItemID][req.CountBought] = req.Time
-					}
-					if t, ok := buyItemDictNoRes2[req.ItemID][req.CountBought]; !ok || req.Time > t {
-						buyItemDictNoRes2[req.ItemID][req.CountBought] = req.Time
-					}
-				} else {
-					return fmt.Errorf("something wrong 3")
-				}
-			}
-		} else {
-			if req.ClientID != res.ClientID {
-				return fmt.Errorf("request_id = %v に対するrequestとresponseでclient idが一致していません", req.RequestID)
+			return fmt.Errorf("request_id = %v に対するresponseが存在しません", req.RequestID)
+		}
+		if req.ClientID != res.ClientID {
+			return fmt.Errorf("request_id = %v に対するrequestとresponseでclient idが一致していません", req.RequestID)
+		}
+		if res.IsSuccess {
+			status := getLatestStatus(statusDict[req.ClientID], req.ClientTime, res.ClientTime)
+			if status == nil {
+				return fmt.Errorf("request_id = %v に対するstatusを受信していません", req.RequestID)
 			}
-			if res.IsSuccess {
-				status := getLatestStatus(statusDict[req.ClientID], req.ClientTime, res.ClientTime)
-				if status == nil {
-					return fmt.Errorf("request_id = %v に対するstatusを受信していません", req.RequestID)
-				}
 
-				if req.Action == "addIsu" {
-					if err := validateAddIsu(req.RequestID, req.Time, req.Isu, status); err != nil {
-						return err
-					}
-					if _, ok := addIsuDict[req.Time]; !ok {
-						addIsuDict[req.Time] = new(big.Int)
-					}
-					addIsuDict[req.Time].Add(addIsuDict[req.Time], str2big(req.Isu))
-				} else if req.Action == "buyItem" {
-					if err := validateBuyItem(req.RequestID, req.ItemID, req.CountBought, status); err != nil {
-						return err
-					}
-					if _, ok := buyItemDict[req.ItemID][req.CountBought]; ok {
-						return fmt.Errorf("buyItem(item_id = %v, count_bought = %v) に対して複数回 is_success = true が存在します : request_id = %v",
-							req.ItemID, req.CountBought, req.RequestID)
-					}
-					buyItemDict[req.ItemID][req.CountBought] = req.Time
-				} else {
-					return fmt.Errorf("something wrong 4")
+			if req.Action == "addIsu" {
+				if err := validateAddIsu(req.RequestID, req.Time, req.Isu, status); err != nil {
+					return err
+				}
+				if _, ok := addIsuDict[req.Time]; !ok {
+					addIsuDict[req.Time] = new(big.Int)
+				}
+				addIsuDict[req.Time].Add(addIsuDict[req.Time], str2big(req.Isu))
+			} else if req.Action == "buyItem" {
+				if err := validateBuyItem(req.RequestID, req.ItemID, req.CountBought, status); err != nil {
+					return err
+				}
+				if _, ok := buyItemDict[req.ItemID][req.CountBought]; ok {
+					return fmt.Errorf("buyItem(item_id = %v, count_bought = %v) に対して複数回 is_success = true が存在します : request_id = %v",
+						req.ItemID, req.CountBought, req.RequestID)
 				}
+				buyItemDict[req.ItemID][req.CountBought] = req.Time
+			} else {
+				return fmt.Errorf("something wrong 4")
 			}
 		}
 	}
 
 	itemMasterObj := newItemMaster()
-	if isPreTest {
-		for itemID, a := range buyItemDict {
-			for i := 0; i < len(a); i++ {
-				if _, ok := a[i]; !ok {
-					return fmt.Errorf("buyItem(item_id = %v, count_bought = %v) に対するresponseを受信していません", itemID, i)
-				}
-			}
-			for i := 1; i < len(a); i++ {
-				if a[i-1] > a[i] {
-					return fmt.Errorf("item_id = %v に対するbuyItemの順序が正しくありません : count_bought = %v の時刻 %v, count_bought = %v の時刻 %v",
-						itemID, i-1, a[i-1], i, a[i])
-				}
+	for itemID, a := range buyItemDict {
+		for i := 0; i < len(a); i++ {
+			if _, ok := a[i]; !ok {
+				return fmt.Errorf("buyItem(item_id = %v, count_bought = %v) に対するresponseを受信していません", itemID, i)
 			}
 		}
-
-		for _, a := range statusDict {
-			for _, x := range a {
-				if err := validateStatus(x, addIsuDict, buyItemDict, itemMasterObj); err != nil {
-					return fmt.Errorf("time = %v の status において %v", x.Time, err)
-				}
-				if ctx.Err() != nil {
-					return nil
-				}
+		for i := 1; i < len(a); i++ {
+			if a[i-1] > a[i] {
+				return fmt.Errorf("item_id = %v に対するbuyItemの順序が正しくありません : count_bought = %v の時刻 %v, count_bought = %v の時刻 %v",
+					itemID, i-1, a[i-1], i, a[i])
 			}
 		}
-	} else {
-		var x *big.Int
-		big1000 := big.NewInt(1000)
-
-		var addIsuKeys []int64
-		for t := range addIsuDict {
-			addIsuKeys = append(addIsuKeys, t)
-		}
-		sort.Slice(addIsuKeys, func(i, j int) bool {
-			return addIsuKeys[i] < addIsuKeys[j]
-		})
-		addIsuSum := make(map[int64]*big.Int)
-		x = big.NewInt(0)
-		for _, t := range addIsuKeys {
-			x = new(big.Int).Add(x, new(big.Int).Mul(addIsuDict[t], big1000))
-			addIsuSum[t] = x
-		}
-
-		var addIsuNoResKeys []int64
-		for t := range addIsuDictNoRes {
-			addIsuNoResKeys = append(addIsuNoResKeys, t)
-		}
-		sort.Slice(addIsuNoResKeys, func(i, j int) bool {
-			return addIsuNoResKeys[i] < addIsuNoResKeys[j]
-		})
-		addIsuSumNoRes := make(map[int64]*big.Int)
-		x = big.NewInt(0)
-		for _, t := range addIsuNoResKeys {
-			x = new(big.Int).Add(x, new(big.Int).Mul(addIsuDictNoRes[t], big1000))
-			addIsuSumNoRes[t] = x
-		}
+	}
 
-		for _, a := range statusDict {
-			for _, x := range a {
-				if err := validateStatusBench(
-					x, addIsuDict, addIsuDictNoRes, addIsuSum, addIsuSumNoRes, addIsuKeys, addIsuNoResKeys,
-					buyItemDict, buyItemDictNoRes1, buyItemDictNoRes2, itemMasterObj); err != nil {
-					return fmt.Errorf("time = %v の status において %v", x.Time, err)
-				}
-				if ctx.Err() != nil {
-					return nil
-				}
+	for _, a := range statusDict {
+		for _, x := range a {
+			if err := validateStatus(x, addIsuDict, buyItemDict, itemMasterObj); err != nil {
+				return fmt.Errorf("time = %v の status において %v", x.Time, err)
+			}
+			// 検証し終えていないので通さない
+			if ctx.Err() != nil {
+				return ctx.Err()
 			}
 		}
 	}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bench/metrics"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// bench gamelog <command> ... でゲームログのダンプを扱う
+
+type gameLogCommand struct {
+	usage string
+	run   func(fs *flag.FlagSet, args []string) error
+}
+
+var gameLogCommands = map[string]gameLogCommand{
+	"inspect":  {"<file>", gameLogInspect},
+	"filter":   {"-client <ids> [-o <file>] <file>", gameLogFilter},
+	"timeline": {"[-client <ids>] <file>", gameLogTimeline},
+	"validate": {"[-data <dir>] [-explain] <file>", gameLogValidate},
+	"stats":    {"[-json] <file>", gameLogStats},
+	"convert":  {"<file> <output>", gameLogConvert},
+}
+
+func gameLogUsage(w io.Writer) {
+	var names []string
+	for name := range gameLogCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "usage: bench gamelog <command> [options]")
+	fmt.Fprintln(w)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %v %v\n", name, gameLogCommands[name].usage)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "<file> is a game log dump (json lines or gob, optionally gzipped)")
+}
+
+func runGameLogCommand(args []string) error {
+	if len(args) == 0 {
+		gameLogUsage(os.Stderr)
+		return fmt.Errorf("command is required")
+	}
+	cmd, ok := gameLogCommands[args[0]]
+	if !ok {
+		gameLogUsage(os.Stderr)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+
+	fs := flag.NewFlagSet("gamelog "+args[0], flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: bench %v %v\n", fs.Name(), cmd.usage)
+		fs.PrintDefaults()
+	}
+	return cmd.run(fs, args[1:])
+}
+
+// 残りの引数がちょうど n 個であること
+func gameLogArgs(fs *flag.FlagSet, n int) ([]string, error) {
+	if fs.NArg() != n {
+		fs.Usage()
+		return nil, fmt.Errorf("%v requires %v argument(s)", fs.Name(), n)
+	}
+	return fs.Args(), nil
+}
+
+// "1,2,3" を client id の集合にする. 空なら nil
+func parseClientIDs(s string) (map[int]bool, error) {
+	if s == "" {
+		return nil, nil
+	}
+	ids := map[int]bool{}
+	for _, x := range strings.Split(s, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(x))
+		if err != nil {
+			return nil, fmt.Errorf("client id %q: %v", x, err)
+		}
+		ids[id] = true
+	}
+	return ids, nil
+}
+
+func filterGameLog(d *gameLogDump, clients map[int]bool) *gameLogDump {
+	if clients == nil {
+		return d
+	}
+	res := &gameLogDump{Room: d.Room, IsPreTest: d.IsPreTest}
+	for _, x := range d.StatusLog {
+		if clients[x.ClientID] {
+			res.StatusLog = append(res.StatusLog, x)
+		}
+	}
+	for _, x := range d.RequestLog {
+		if clients[x.ClientID] {
+			res.RequestLog = append(res.RequestLog, x)
+		}
+	}
+	for _, x := range d.ResponseLog {
+		if clients[x.ClientID] {
+			res.ResponseLog = append(res.ResponseLog, x)
+		}
+	}
+	return res
+}
+
+const gameLogTimeFormat = "15:04:05.000"
+
+func gameLogInspect(fs *flag.FlagSet, args []string) error {
+	fs.Parse(args)
+	a, err := gameLogArgs(fs, 1)
+	if err != nil {
+		return err
+	}
+	d, err := readGameLogFile(a[0])
+	if err != nil {
+		return err
+	}
+
+	type clientSummary struct {
+		status, request, response int
+		first, last               time.Time
+	}
+	clients := map[int]*clientSummary{}
+	var first, last time.Time
+	var minTime, maxTime int64
+	add := func(id int, t time.Time) *clientSummary {
+		c, ok := clients[id]
+		if !ok {
+			c = &clientSummary{first: t, last: t}
+			clients[id] = c
+		}
+		if t.Before(c.first) {
+			c.first = t
+		}
+		if c.last.Before(t) {
+			c.last = t
+		}
+		if first.IsZero() || t.Before(first) {
+			first = t
+		}
+		if last.Before(t) {
+			last = t
+		}
+		return c
+	}
+	for i, x := range d.StatusLog {
+		add(x.ClientID, x.ClientTime).status++
+		if i == 0 || x.Time < minTime {
+			minTime = x.Time
+		}
+		if i == 0 || maxTime < x.Time {
+			maxTime = x.Time
+		}
+	}
+	for _, x := range d.RequestLog {
+		add(x.ClientID, x.ClientTime).request++
+	}
+	for _, x := range d.ResponseLog {
+		add(x.ClientID, x.ClientTime).response++
+	}
+
+	fmt.Printf("room:        %v\n", d.Room)
+	fmt.Printf("pretest:     %v\n", d.IsPreTest)
+	fmt.Printf("status:      %v\n", len(d.StatusLog))
+	fmt.Printf("request:     %v\n", len(d.RequestLog))
+	fmt.Printf("response:    %v\n", len(d.ResponseLog))
+	if !first.IsZero() {
+		fmt.Printf("client_time: %v - %v (%v)\n", first.Format(time.RFC3339Nano), last.Format(time.RFC3339Nano), last.Sub(first))
+	}
+	if 0 < len(d.StatusLog) {
+		fmt.Printf("server time: %v - %v\n", minTime, maxTime)
+	}
+
+	var ids []int
+	for id := range clients {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "client\tstatus\trequest\tresponse\tfirst\tlast")
+	for _, id := range ids {
+		c := clients[id]
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", id, c.status, c.request, c.response,
+			c.first.Format(gameLogTimeFormat), c.last.Format(gameLogTimeFormat))
+	}
+	return w.Flush()
+}
+
+func gameLogFilter(fs *flag.FlagSet, args []string) error {
+	client := fs.String("client", "", "comma separated client ids to keep")
+	output := fs.String("o", "-", "output path (.gz to compress, - for stdout)")
+	fs.Parse(args)
+	a, err := gameLogArgs(fs, 1)
+	if err != nil {
+		return err
+	}
+	clients, err := parseClientIDs(*client)
+	if err != nil {
+		return err
+	}
+	if clients == nil {
+		return fmt.Errorf("-client is required")
+	}
+
+	d, err := readGameLogFile(a[0])
+	if err != nil {
+		return err
+	}
+	return writeGameLogFile(*output, filterGameLog(d, clients))
+}
+
+func gameLogTimeline(fs *flag.FlagSet, args []string) error {
+	client := fs.String("client", "", "comma separated client ids to show")
+	fs.Parse(args)
+	a, err := gameLogArgs(fs, 1)
+	if err != nil {
+		return err
+	}
+	clients, err := parseClientIDs(*client)
+	if err != nil {
+		return err
+	}
+
+	d, err := readGameLogFile(a[0])
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, r := range gameLogRecords(filterGameLog(d, clients)) {
+		var desc string
+		switch r.Type {
+		case "status":
+			s := r.Status
+			desc = fmt.Sprintf("time=%v", s.Time)
+			if 0 < len(s.Schedule) {
+				desc += fmt.Sprintf(" milli_isu=%v total_power=%v", s.Schedule[0].MilliIsu, s.Schedule[0].TotalPower)
+			}
+			desc += fmt.Sprintf(" adding=%v on_sale=%v", len(s.Adding), len(s.OnSale))
+		case "request":
+			q := r.Request
+			desc = fmt.Sprintf("request_id=%v %v time=%v", q.RequestID, q.Action, q.Time)
+			if q.Action == "addIsu" {
+				desc += fmt.Sprintf(" isu=%v", q.Isu)
+			} else {
+				desc += fmt.Sprintf(" item_id=%v count_bought=%v", q.ItemID, q.CountBought)
+			}
+		case "response":
+			desc = fmt.Sprintf("request_id=%v is_success=%v", r.Response.RequestID, r.Response.IsSuccess)
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", r.ClientTime.Format(gameLogTimeFormat), r.ClientID, r.Type, desc)
+	}
+	return w.Flush()
+}
+
+func gameLogValidate(fs *flag.FlagSet, args []string) error {
+	dataPath := fs.String("data", "./data", "path to data directory")
+	explain := fs.Bool("explain", false, "応答の無い request のどれが反映されたとしたかを status 毎に出力する")
+	fs.Parse(args)
+	a, err := gameLogArgs(fs, 1)
+	if err != nil {
+		return err
+	}
+
+	if *explain {
+		onNoResAssumption = func(room string, st *GameStatusLog, a *noResAssumption) {
+			fmt.Printf("client %v time %v applied %v not_applied %v\n", st.ClientID, a.Time, a.Applied, a.NotApplied)
+		}
+	}
+	loadMasterData(*dataPath)
+	err = ValidateGameLogDump(a[0])
+	if err != nil {
+		return err
+	}
+	fmt.Println("ok")
+	return nil
+}
+
+type gameLogActionStats struct {
+	Request    int64          `json:"request"`
+	Success    int64          `json:"success"`
+	Failure    int64          `json:"failure"`
+	NoResponse int64          `json:"no_response"`
+	Latency    LatencySummary `json:"latency"`
+}
+
+type gameLogStatsResult struct {
+	Room           string                         `json:"room"`
+	Clients        int                            `json:"clients"`
+	Status         int                            `json:"status"`
+	Actions        map[string]*gameLogActionStats `json:"actions"`
+	StatusInterval LatencySummary                 `json:"status_interval"`
+}
+
+func gameLogStats(fs *flag.FlagSet, args []string) error {
+	asJSON := fs.Bool("json", false, "output json")
+	fs.Parse(args)
+	a, err := gameLogArgs(fs, 1)
+	if err != nil {
+		return err
+	}
+	d, err := readGameLogFile(a[0])
+	if err != nil {
+		return err
+	}
+
+	res := gameLogStatsResult{
+		Room:    d.Room,
+		Status:  len(d.StatusLog),
+		Actions: map[string]*gameLogActionStats{},
+	}
+
+	responses := map[int]*GameResponseLog{}
+	for _, x := range d.ResponseLog {
+		responses[x.RequestID] = x
+	}
+	latency := map[string]*metrics.Histogram{}
+	for _, req := range d.RequestLog {
+		s, ok := res.Actions[req.Action]
+		if !ok {
+			s = new(gameLogActionStats)
+			res.Actions[req.Action] = s
+			latency[req.Action] = new(metrics.Histogram)
+		}
+		s.Request++
+		x, ok := responses[req.RequestID]
+		switch {
+		case !ok:
+			s.NoResponse++
+			continue
+		case x.IsSuccess:
+			s.Success++
+		default:
+			s.Failure++
+		}
+		latency[req.Action].Observe(x.ClientTime.Sub(req.ClientTime))
+	}
+	for action, h := range latency {
+		res.Actions[action].Latency = summarizeLatency(h.Snapshot())
+	}
+
+	// client 毎の status の受信間隔
+	statusTime := map[int][]time.Time{}
+	for _, x := range d.StatusLog {
+		statusTime[x.ClientID] = append(statusTime[x.ClientID], x.ClientTime)
+	}
+	var interval metrics.Histogram
+	for _, ts := range statusTime {
+		sort.Slice(ts, func(i, j int) bool {
+			return ts[i].Before(ts[j])
+		})
+		for i := 1; i < len(ts); i++ {
+			interval.Observe(ts[i].Sub(ts[i-1]))
+		}
+	}
+	res.StatusInterval = summarizeLatency(interval.Snapshot())
+	res.Clients = len(statusTime)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res)
+	}
+
+	fmt.Printf("room:    %v\n", res.Room)
+	fmt.Printf("clients: %v\n", res.Clients)
+	fmt.Printf("status:  %v\n", res.Status)
+	fmt.Println()
+
+	var actions []string
+	for action := range res.Actions {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "\trequest\tsuccess\tfailure\tno_response\tp50\tp90\tp99\tmax")
+	for _, action := range actions {
+		s := res.Actions[action]
+		l := s.Latency
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%.1fms\t%.1fms\t%.1fms\t%.1fms\n",
+			action, s.Request, s.Success, s.Failure, s.NoResponse, l.P50, l.P90, l.P99, l.Max)
+	}
+	l := res.StatusInterval
+	fmt.Fprintf(w, "status interval\t%v\t\t\t\t%.1fms\t%.1fms\t%.1fms\t%.1fms\n", l.Count, l.P50, l.P90, l.P99, l.Max)
+	return w.Flush()
+}
+
+func gameLogConvert(fs *flag.FlagSet, args []string) error {
+	fs.Parse(args)
+	a, err := gameLogArgs(fs, 2)
+	if err != nil {
+		return err
+	}
+	d, err := readGameLogFile(a[0])
+	if err != nil {
+		return err
+	}
+	return writeGameLogFile(a[1], d)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ゲームログのダンプ形式
+//
+// 1行目がヘッダ, 2行目以降が1行1件の status, request, response を client_time 順に並べた JSON lines.
+// ファイル名が .gz で終わる場合と -dumpgamelog のダンプは gzip で圧縮する.
+// 読むときは gzip と, 以前の gob のダンプも判別する.
+//
+//	{"format":"isu7f-gamelog","version":1,"room":"...","is_pre_test":false}
+//	{"type":"status","client_id":1,"client_time":"...","status":{...}}
+//	{"type":"request","client_id":1,"client_time":"...","request":{...}}
+//	{"type":"request","client_id":1,"client_time":"...","request":{...},"chaos":"duplicate"}
+//	{"type":"status","client_id":1,"client_time":"...","status":{...},"conn":1}
+//	{"type":"response","client_id":1,"client_time":"...","response":{...}}
+//
+// 互換性の無い変更をする場合は gameLogVersion を上げる.
+const (
+	gameLogFormat  = "isu7f-gamelog"
+	gameLogVersion = 1
+)
+
+type gameLogHeader struct {
+	Format    string `json:"format"`
+	Version   int    `json:"version"`
+	Room      string `json:"room"`
+	IsPreTest bool   `json:"is_pre_test"`
+}
+
+type gameLogRecord struct {
+	Type       string        `json:"type"` // status, request, response
+	ClientID   int           `json:"client_id"`
+	ClientTime time.Time     `json:"client_time"`
+	Status     *GameStatus   `json:"status,omitempty"`
+	Request    *GameRequest  `json:"request,omitempty"`
+	Response   *GameResponse `json:"response,omitempty"`
+	Conn       int           `json:"conn,omitempty"`  // 同じ client が接続し直した回数
+	Chaos      string        `json:"chaos,omitempty"` // request に注入した故障
+}
+
+// status, request, response を client_time 順に並べる
+func gameLogRecords(d *gameLogDump) []gameLogRecord {
+	var records []gameLogRecord
+	for _, x := range d.StatusLog {
+		records = append(records, gameLogRecord{Type: "status", ClientID: x.ClientID, ClientTime: x.ClientTime, Status: x.GameStatus, Conn: x.Conn})
+	}
+	for _, x := range d.RequestLog {
+		records = append(records, gameLogRecord{Type: "request", ClientID: x.ClientID, ClientTime: x.ClientTime, Request: x.GameRequest, Conn: x.Conn, Chaos: x.Chaos})
+	}
+	for _, x := range d.ResponseLog {
+		records = append(records, gameLogRecord{Type: "response", ClientID: x.ClientID, ClientTime: x.ClientTime, Response: x.GameResponse, Conn: x.Conn})
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].ClientTime.Before(records[j].ClientTime)
+	})
+	return records
+}
+
+func (d *gameLogDump) addRecord(r gameLogRecord) error {
+	switch r.Type {
+	case "status":
+		if r.Status == nil {
+			return fmt.Errorf("status がありません")
+		}
+		d.StatusLog = append(d.StatusLog, &GameStatusLog{GameStatus: r.Status, ClientID: r.ClientID, ClientTime: r.ClientTime, Conn: r.Conn})
+	case "request":
+		if r.Request == nil {
+			return fmt.Errorf("request がありません")
+		}
+		d.RequestLog = append(d.RequestLog, &GameRequestLog{GameRequest: r.Request, ClientID: r.ClientID, ClientTime: r.ClientTime, Conn: r.Conn, Chaos: r.Chaos})
+	case "response":
+		if r.Response == nil {
+			return fmt.Errorf("response がありません")
+		}
+		d.ResponseLog = append(d.ResponseLog, &GameResponseLog{GameResponse: r.Response, ClientID: r.ClientID, ClientTime: r.ClientTime, Conn: r.Conn})
+	default:
+		return fmt.Errorf("type %q は不明です", r.Type)
+	}
+	return nil
+}
+
+func writeGameLog(w io.Writer, d *gameLogDump) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	err := enc.Encode(gameLogHeader{
+		Format:    gameLogFormat,
+		Version:   gameLogVersion,
+		Room:      d.Room,
+		IsPreTest: d.IsPreTest,
+	})
+	if err != nil {
+		return err
+	}
+	for _, r := range gameLogRecords(d) {
+		err = enc.Encode(&r)
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func readGameLog(r io.Reader) (*gameLogDump, error) {
+	dec := json.NewDecoder(r)
+
+	var h gameLogHeader
+	err := dec.Decode(&h)
+	if err != nil {
+		return nil, fmt.Errorf("ヘッダが読めません: %v", err)
+	}
+	if h.Format != gameLogFormat {
+		return nil, fmt.Errorf("ゲームログではありません: format = %q", h.Format)
+	}
+	if h.Version < 1 || gameLogVersion < h.Version {
+		return nil, fmt.Errorf("ゲームログのバージョン %v には対応していません (%v まで)", h.Version, gameLogVersion)
+	}
+
+	d := &gameLogDump{Room: h.Room, IsPreTest: h.IsPreTest}
+	for line := 2; dec.More(); line++ {
+		var x gameLogRecord
+		err := dec.Decode(&x)
+		if err == nil {
+			err = d.addRecord(x)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%v 行目: %v", line, err)
+		}
+	}
+	return d, nil
+}
+
+// JSON lines と gob のどちらも読む. どちらも gzip されていても良い
+func readGameLogFile(path string) (*gameLogDump, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		g, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer g.Close()
+		br = bufio.NewReader(g)
+	}
+
+	if b, _ := br.Peek(1); len(b) == 1 && b[0] == '{' {
+		d, err := readGameLog(br)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", path, err)
+		}
+		return d, nil
+	}
+
+	d := new(gameLogDump)
+	err = gob.NewDecoder(br).Decode(d)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return d, nil
+}
+
+// path が - なら標準出力に書く
+func writeGameLogFile(path string, d *gameLogDump) error {
+	if path == "-" {
+		return writeGameLog(os.Stdout, d)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if strings.HasSuffix(path, ".gz") {
+		g := gzip.NewWriter(f)
+		err = writeGameLog(g, d)
+		if err != nil {
+			return err
+		}
+		err = g.Close()
+	} else {
+		err = writeGameLog(f, d)
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
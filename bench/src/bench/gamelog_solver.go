@@ -0,0 +1,408 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"app/game"
+)
+
+// 応答の無い request の組み合わせの探索
+//
+// 負荷走行では応答の無い addIsu, buyItem が反映されたかどうか分からない.
+// validateStatusBench は全て反映された場合とされなかった場合の椅子の数の上限と下限で検証するが,
+// それを通った status について, 応答の無い request のどれが反映されたとすれば status を説明できるかを探し,
+// 見つからなければ失敗とする.
+//
+// status の currentTime = T 以前の adding と建設済みの buying は T における椅子の数 x0 にしか影響せず,
+// x0 が決まれば schedule と on_sale は一意に決まる. そこで
+//   - T より先の adding は status.adding の isu になる組み合わせを探す
+//   - 建設中の buying は status の building の時刻の buyItem があるか調べる
+//   - x0 は schedule の milli_isu から決まる範囲に入る組み合わせを枝刈りしながら探し, 入ったら on_sale まで比べる
+// status 毎に独立に探すので, 同じ request が status によって反映されたりされなかったりしても良い.
+// 探索が solverNodeLimit を超えたら validateStatusBench の検証だけで良しとする.
+//
+// 検証済みの status より前の応答の無い request は捨て, 最後に説明できた status での仮定で反映されたかどうかを決める.
+// 丸められた milli_isu では仮定が一意に決まらないことがあるので, 捨てた request があるときに説明できなければ打ち切りとして扱う.
+
+// 1つの status あたりの探索の上限
+const solverNodeLimit = 20000
+
+var errSolverGaveUp = errors.New("探索が上限に達しました")
+
+// 応答の無い request
+type noResRequest struct {
+	RequestID int
+	Time      int64
+	Isu       *big.Int // addIsu のみ
+}
+
+// status を説明できた応答の無い request の組み合わせ
+type noResAssumption struct {
+	Time       int64 `json:"time"`
+	Applied    []int `json:"applied"`     // 反映されたとした request_id
+	NotApplied []int `json:"not_applied"` // 反映されなかったとした request_id
+}
+
+// status の検証で組み合わせを考える, 応答の無い request
+func (v *streamValidator) noResCandidates(st *GameStatusLog) []int {
+	currentTime := st.Schedule[0].Time
+	inAdding := map[int64]bool{}
+	for _, a := range st.Adding {
+		inAdding[a.Time] = true
+	}
+
+	var ids []int
+	for t, a := range v.addIsuNoResReq {
+		if t <= currentTime || inAdding[t] {
+			for _, r := range a {
+				ids = append(ids, r.RequestID)
+			}
+		}
+	}
+	for _, b := range st.Items {
+		for k, a := range v.buyItemNoResReq[b.ItemID] {
+			for _, r := range a {
+				if k < b.CountBought || r.Time <= currentTime {
+					ids = append(ids, r.RequestID)
+				}
+			}
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// x0 に足す値の候補. ids[i] は values[i] を選んだときに反映されたとする request_id (無ければ -1)
+type solverVar struct {
+	values []*big.Int
+	ids    []int
+}
+
+type solverSearch struct {
+	vars   []solverVar
+	min    []*big.Int // vars[i:] の和の最小値
+	max    []*big.Int // vars[i:] の和の最大値
+	lo, hi *big.Int
+	nodes  int
+	choice []int
+
+	// x0 が範囲に入ったときに schedule と on_sale を比べる
+	check func(x0 *big.Int) bool
+}
+
+func (s *solverSearch) run(sum *big.Int, i int) (bool, error) {
+	s.nodes++
+	if solverNodeLimit < s.nodes {
+		return false, errSolverGaveUp
+	}
+
+	lo := new(big.Int).Add(sum, s.min[i])
+	hi := new(big.Int).Add(sum, s.max[i])
+	if s.hi.Cmp(lo) < 0 || hi.Cmp(s.lo) < 0 {
+		return false, nil
+	}
+	if i == len(s.vars) {
+		return s.check(sum), nil
+	}
+
+	for j, x := range s.vars[i].values {
+		s.choice[i] = j
+		ok, err := s.run(new(big.Int).Add(sum, x), i+1)
+		if ok || err != nil {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// amounts の部分集合で和が target になるものを探す
+func subsetSum(amounts []*big.Int, target *big.Int, nodes *int) ([]bool, error) {
+	used := make([]bool, len(amounts))
+	var rec func(i int, rest *big.Int) (bool, error)
+	rec = func(i int, rest *big.Int) (bool, error) {
+		*nodes++
+		if solverNodeLimit < *nodes {
+			return false, errSolverGaveUp
+		}
+		if rest.Sign() == 0 {
+			return true, nil
+		}
+		if rest.Sign() < 0 || i == len(amounts) {
+			return false, nil
+		}
+		used[i] = true
+		if ok, err := rec(i+1, new(big.Int).Sub(rest, amounts[i])); ok || err != nil {
+			return ok, err
+		}
+		used[i] = false
+		return rec(i+1, rest)
+	}
+	ok, err := rec(0, target)
+	if !ok || err != nil {
+		return nil, err
+	}
+	return used, nil
+}
+
+// validateStatusBench を通った status を説明できる応答の無い request の組み合わせを探す.
+// 応答の無い request が関係しなければ nil を返す. 探索が上限に達したら errSolverGaveUp を返す
+func (v *streamValidator) solve(st *GameStatusLog) (*noResAssumption, error) {
+	candidates := v.noResCandidates(st)
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+	// 捨てた request より前の status は, 捨てた request を反映されたかどうか選べないので探さない
+	if st.Schedule[0].Time <= v.prunedTime {
+		return nil, errSolverGaveUp
+	}
+
+	var (
+		currentTime = st.Schedule[0].Time
+		nodes       = 0
+		applied     = map[int]bool{}
+
+		x0   = new(big.Int) // 応答の無い request で決まらない分
+		vars []solverVar
+
+		totalPower = new(big.Int)
+		itemPower  = map[int]*big.Int{}
+		itemBuilt  = map[int]int{}
+		adding     = map[int64]*big.Int{}
+		buyingAt   = map[int64][]Buying{}
+	)
+
+	for i := len(v.addIsuKeys) - 1; i >= 0; i-- {
+		if t := v.addIsuKeys[i]; t <= currentTime {
+			x0.Add(x0, v.addIsuSum[t])
+			break
+		}
+	}
+	x0.Add(x0, new(big.Int).Mul(v.addIsuPruned, big.NewInt(1000)))
+	for t, a := range v.addIsuNoResReq {
+		if currentTime < t {
+			continue
+		}
+		for _, r := range a {
+			vars = append(vars, solverVar{
+				values: []*big.Int{new(big.Int), new(big.Int).Mul(r.Isu, big.NewInt(1000))},
+				ids:    []int{-1, r.RequestID},
+			})
+		}
+	}
+
+	for i, a := range st.Adding {
+		isu := str2big(a.Isu)
+		adding[a.Time] = isu
+		noRes := v.addIsuNoResReq[a.Time]
+		if len(noRes) == 0 {
+			continue
+		}
+		// 成功した addIsu は必ず含まれるので, 残りを応答の無い addIsu から探す
+		rest := new(big.Int).Set(isu)
+		for _, x := range v.addIsuReq[a.Time] {
+			rest.Sub(rest, x)
+		}
+		amounts := make([]*big.Int, len(noRes))
+		for j, r := range noRes {
+			amounts[j] = r.Isu
+		}
+		used, err := subsetSum(amounts, rest, &nodes)
+		if err != nil {
+			return nil, err
+		}
+		if used == nil {
+			return nil, fmt.Errorf("adding[%v].isu が正しくありません : actual %v, time = %v の成功した addIsu と応答の無い addIsu のどの組み合わせの和にもなりません", i, a.Isu, a.Time)
+		}
+		for j, r := range noRes {
+			if used[j] {
+				applied[r.RequestID] = true
+			}
+		}
+	}
+
+	for _, b := range st.Items {
+		itemPower[b.ItemID] = new(big.Int)
+		itemBuilt[b.ItemID] = b.CountBuilt
+		for k := 0; k < b.CountBought; k++ {
+			x0.Sub(x0, v.itemMasterObj.getData(b.ItemID, k+1).v1K)
+			power := v.itemMasterObj.getPower(b.ItemID, k+1)
+			t0, ok := v.buyItemDict[b.ItemID][k]
+			if !ok {
+				t0, ok = v.buyItemPruned[b.ItemID][k]
+			}
+			noRes := v.buyItemNoResReq[b.ItemID][k]
+
+			if k < b.CountBuilt {
+				totalPower.Add(totalPower, power)
+				itemPower[b.ItemID].Add(itemPower[b.ItemID], power)
+				if ok {
+					x0.Add(x0, new(big.Int).Mul(power, big.NewInt(currentTime-t0)))
+					continue
+				}
+				// 同じ時刻の request は区別しない
+				x := solverVar{}
+				seen := map[int64]bool{}
+				for _, r := range noRes {
+					if r.Time <= currentTime && !seen[r.Time] {
+						seen[r.Time] = true
+						x.values = append(x.values, new(big.Int).Mul(power, big.NewInt(currentTime-r.Time)))
+						x.ids = append(x.ids, r.RequestID)
+					}
+				}
+				switch len(x.values) {
+				case 0:
+					if 0 <= v.prunedTime {
+						return nil, errSolverGaveUp
+					}
+					return nil, fmt.Errorf("items[item_id = %v].count_built が正しくありません : 時刻 %v までの buyItem(count_bought = %v) が存在しません",
+						b.ItemID, currentTime, k)
+				case 1:
+					x0.Add(x0, x.values[0])
+					applied[x.ids[0]] = true
+				default:
+					vars = append(vars, x)
+				}
+				continue
+			}
+
+			var t int64 = -1
+			for _, y := range b.Building {
+				if k+1 <= y.CountBuilt {
+					t = y.Time
+					break
+				}
+			}
+			if t < 0 {
+				return nil, fmt.Errorf("something wrong 5")
+			}
+			buyingAt[t] = append(buyingAt[t], Buying{ItemID: b.ItemID, Ordinal: k + 1, Time: t})
+			if ok {
+				continue
+			}
+			found := false
+			for _, r := range noRes {
+				if r.Time == t {
+					applied[r.RequestID] = true
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("items[item_id = %v].building の time = %v に建設される buyItem(count_bought = %v) が存在しません",
+					b.ItemID, t, k)
+			}
+		}
+	}
+
+	// x0 = 0 としたときの推移
+	tl0, _ := game.Simulate(game.State{
+		Time:      currentTime,
+		MilliIsu:  new(big.Int),
+		Power:     totalPower,
+		ItemPower: itemPower,
+		ItemBuilt: itemBuilt,
+	}, adding, buyingAt, v.itemMasterObj.getPower)
+	if len(tl0.Time) != len(st.Schedule) {
+		return nil, fmt.Errorf("schedule の要素数が正しくありません : actual %v, expected %v", len(st.Schedule), len(tl0.Time))
+	}
+
+	// milli_isu は15桁に切り捨てられているので, x0 は範囲になる
+	s := &solverSearch{lo: new(big.Int), choice: make([]int, len(vars))}
+	for i, x := range st.Schedule {
+		e := x.MilliIsu.Normalize()
+		if e.Sign() < 0 {
+			return nil, fmt.Errorf("schedule[%v].milli_isu が負です : %v", i, x.MilliIsu)
+		}
+		lo := new(big.Int).Sub(e.ToBig(), tl0.MilliIsu[i])
+		hi := new(big.Int).Add(lo, new(big.Int).Exp(big.NewInt(10), big.NewInt(e.Exponent), nil))
+		hi.Sub(hi, big.NewInt(1))
+		if s.lo.Cmp(lo) < 0 {
+			s.lo = lo
+		}
+		if s.hi == nil || hi.Cmp(s.hi) < 0 {
+			s.hi = hi
+		}
+	}
+
+	statusOnSale := map[int]int64{}
+	for _, x := range st.OnSale {
+		statusOnSale[x.ItemID] = x.Time
+	}
+	s.check = func(x0 *big.Int) bool {
+		tl := tl0.Shift(x0)
+		for i, x := range st.Schedule {
+			if !big2exp(tl.MilliIsu[i]).Eq(x.MilliIsu) {
+				return false
+			}
+		}
+		for _, b := range st.Items {
+			t1, ok1 := tl.OnSale(v.itemMasterObj.getData(b.ItemID, b.CountBought+1).v1K)
+			t2, ok2 := statusOnSale[b.ItemID]
+			if ok1 != ok2 || t1 != t2 {
+				return false
+			}
+		}
+		return true
+	}
+
+	// 大きいものから決める
+	maxOf := func(x solverVar) *big.Int {
+		m := x.values[0]
+		for _, y := range x.values {
+			if m.Cmp(y) < 0 {
+				m = y
+			}
+		}
+		return m
+	}
+	sort.SliceStable(vars, func(i, j int) bool {
+		return maxOf(vars[i]).Cmp(maxOf(vars[j])) > 0
+	})
+	s.vars = vars
+	s.min = make([]*big.Int, len(vars)+1)
+	s.max = make([]*big.Int, len(vars)+1)
+	s.min[len(vars)], s.max[len(vars)] = new(big.Int), new(big.Int)
+	for i := len(vars) - 1; 0 <= i; i-- {
+		mn, mx := vars[i].values[0], vars[i].values[0]
+		for _, y := range vars[i].values {
+			if y.Cmp(mn) < 0 {
+				mn = y
+			}
+			if mx.Cmp(y) < 0 {
+				mx = y
+			}
+		}
+		s.min[i] = new(big.Int).Add(s.min[i+1], mn)
+		s.max[i] = new(big.Int).Add(s.max[i+1], mx)
+	}
+
+	s.nodes = nodes
+	ok, err := s.run(x0, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !ok && 0 <= v.prunedTime {
+		return nil, errSolverGaveUp
+	}
+	if !ok {
+		return nil, fmt.Errorf("応答の無い request (%v 件) のどの組み合わせが反映されたとしても schedule と on_sale が正しくありません", len(candidates))
+	}
+
+	for i, x := range vars {
+		if id := x.ids[s.choice[i]]; 0 <= id {
+			applied[id] = true
+		}
+	}
+	a := &noResAssumption{Time: currentTime, Applied: []int{}, NotApplied: []int{}}
+	for _, id := range candidates {
+		if applied[id] {
+			a.Applied = append(a.Applied, id)
+		} else {
+			a.NotApplied = append(a.NotApplied, id)
+		}
+	}
+	return a, nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"app/game"
+)
+
+// 価格 10, count 個目の生産力 count+1 の item だけのマスタ
+func setTestItems() {
+	mItems = map[int]mItem{
+		1: {ItemID: 1, Power1: 0, Power2: 0, Power3: 1, Power4: 1, Price1: 0, Price2: 1, Price3: 0, Price4: 10},
+	}
+}
+
+func newTestValidator() *streamValidator {
+	setTestItems()
+	return newStreamValidator("test")
+}
+
+func (v *streamValidator) testAddIsu(tm int64, isu string) {
+	if _, ok := v.addIsuDict[tm]; !ok {
+		v.addIsuDict[tm] = new(big.Int)
+	}
+	v.addIsuDict[tm].Add(v.addIsuDict[tm], str2big(isu))
+	v.addIsuReq[tm] = append(v.addIsuReq[tm], str2big(isu))
+	v.addIsuKeys, v.addIsuSum = prefixSumMilliIsu(v.addIsuDict)
+}
+
+func (v *streamValidator) testNoRes(t *testing.T, req *GameRequest) {
+	if err := v.foldNoResponse(&GameRequestLog{GameRequest: req}); err != nil {
+		t.Fatal(err)
+	}
+	v.addIsuKeys, v.addIsuSum = prefixSumMilliIsu(v.addIsuDict)
+	v.addIsuNoResKeys, v.addIsuSumNoRes = prefixSumMilliIsu(v.addIsuDictNoRes)
+}
+
+func testStatus(currentTime int64, addings []game.Adding, buyings []game.Buying) *GameStatusLog {
+	return &GameStatusLog{GameStatus: game.CalcStatus(currentTime, mItems, addings, buyings)}
+}
+
+func TestSubsetSum(t *testing.T) {
+	amounts := []*big.Int{big.NewInt(3), big.NewInt(5), big.NewInt(7)}
+	cases := []struct {
+		target int64
+		used   []bool
+	}{
+		{0, []bool{false, false, false}},
+		{12, []bool{false, true, true}},
+		{10, []bool{true, false, true}},
+		{15, []bool{true, true, true}},
+		{4, nil},
+		{-3, nil},
+	}
+	for _, c := range cases {
+		nodes := 0
+		used, err := subsetSum(amounts, big.NewInt(c.target), &nodes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(used, c.used) {
+			t.Errorf("target %v: got %v, expected %v", c.target, used, c.used)
+		}
+	}
+
+	nodes := solverNodeLimit
+	if _, err := subsetSum(amounts, big.NewInt(15), &nodes); err != errSolverGaveUp {
+		t.Errorf("上限を超えても打ち切られません: %v", err)
+	}
+}
+
+func TestSolveAddIsu(t *testing.T) {
+	v := newTestValidator()
+	v.testAddIsu(0, "100")
+	v.testNoRes(t, &GameRequest{RequestID: 7, Action: "addIsu", Time: 500, Isu: "50"})
+
+	// 応答の無い addIsu が反映された
+	a, err := v.solve(testStatus(1000, []game.Adding{{Time: 0, Isu: "100"}, {Time: 500, Isu: "50"}}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a.Applied, []int{7}) || len(a.NotApplied) != 0 {
+		t.Errorf("got %+v", a)
+	}
+
+	// 反映されなかった
+	a, err = v.solve(testStatus(1000, []game.Adding{{Time: 0, Isu: "100"}}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Applied) != 0 || !reflect.DeepEqual(a.NotApplied, []int{7}) {
+		t.Errorf("got %+v", a)
+	}
+
+	// どちらでも説明できない
+	if _, err := v.solve(testStatus(1000, []game.Adding{{Time: 0, Isu: "120"}}, nil)); err == nil {
+		t.Error("100 + 50 の部分和でない椅子の数が通りました")
+	}
+
+	// 応答の無い request が無い status は探索しない
+	if a, err := newTestValidator().solve(testStatus(1000, nil, nil)); a != nil || err != nil {
+		t.Errorf("got %+v, %v", a, err)
+	}
+}
+
+func TestSolveAdding(t *testing.T) {
+	v := newTestValidator()
+	v.testAddIsu(1500, "5")
+	v.testNoRes(t, &GameRequest{RequestID: 8, Action: "addIsu", Time: 1500, Isu: "3"})
+
+	a, err := v.solve(testStatus(1000, []game.Adding{{Time: 1500, Isu: "8"}}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a.Applied, []int{8}) {
+		t.Errorf("got %+v", a)
+	}
+
+	a, err = v.solve(testStatus(1000, []game.Adding{{Time: 1500, Isu: "5"}}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a.NotApplied, []int{8}) {
+		t.Errorf("got %+v", a)
+	}
+
+	// 成功した addIsu を落とした adding
+	if _, err := v.solve(testStatus(1000, []game.Adding{{Time: 1500, Isu: "3"}}, nil)); err == nil {
+		t.Error("成功した addIsu が含まれない adding が通りました")
+	}
+}
+
+func TestSolveBuyItem(t *testing.T) {
+	v := newTestValidator()
+	v.testAddIsu(0, "100")
+	v.testNoRes(t, &GameRequest{RequestID: 9, Action: "buyItem", Time: 200, ItemID: 1, CountBought: 0})
+	v.testNoRes(t, &GameRequest{RequestID: 10, Action: "buyItem", Time: 400, ItemID: 1, CountBought: 0})
+	addings := []game.Adding{{Time: 0, Isu: "100"}}
+
+	// 同じ count_bought の buyItem のどちらが建設されたか
+	for _, c := range []struct {
+		time    int64
+		applied []int
+	}{
+		{200, []int{9}},
+		{400, []int{10}},
+	} {
+		st := testStatus(1000, addings, []game.Buying{{ItemID: 1, Ordinal: 1, Time: c.time}})
+		a, err := v.solve(st)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(a.Applied, c.applied) {
+			t.Errorf("time = %v: got %+v", c.time, a)
+		}
+	}
+
+	// 建設中
+	a, err := v.solve(testStatus(300, addings, []game.Buying{{ItemID: 1, Ordinal: 1, Time: 400}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a.Applied, []int{10}) {
+		t.Errorf("got %+v", a)
+	}
+
+	// どの buyItem とも時刻が合わない
+	if _, err := v.solve(testStatus(1000, addings, []game.Buying{{ItemID: 1, Ordinal: 1, Time: 300}})); err == nil {
+		t.Error("存在しない時刻の buyItem が通りました")
+	}
+}
+
+func TestSolveTrimNoRes(t *testing.T) {
+	v := newTestValidator()
+	v.testAddIsu(0, "100")
+	v.testNoRes(t, &GameRequest{RequestID: 7, Action: "addIsu", Time: 500, Isu: "50"})
+	v.testNoRes(t, &GameRequest{RequestID: 9, Action: "buyItem", Time: 600, ItemID: 1, CountBought: 0})
+	addings := []game.Adding{{Time: 0, Isu: "100"}, {Time: 500, Isu: "50"}}
+	buyings := []game.Buying{{ItemID: 1, Ordinal: 1, Time: 600}}
+
+	if err := v.explain(testStatus(1000, addings, buyings)); err != nil {
+		t.Fatal(err)
+	}
+	v.trimNoRes(900)
+	if len(v.addIsuNoResReq) != 0 || len(v.buyItemNoResReq[1]) != 0 || len(v.noResApplied) != 0 {
+		t.Fatalf("捨てられていません: %v %v %v", v.addIsuNoResReq, v.buyItemNoResReq, v.noResApplied)
+	}
+	if v.addIsuPruned.Cmp(big.NewInt(50)) != 0 || v.buyItemPruned[1][0] != 600 {
+		t.Fatalf("反映されたとした request が残っていません: %v %v", v.addIsuPruned, v.buyItemPruned)
+	}
+
+	// 捨てた request は反映されたものとして探す
+	v.testNoRes(t, &GameRequest{RequestID: 11, Action: "addIsu", Time: 1200, Isu: "1"})
+	a, err := v.solve(testStatus(1500, append(addings, game.Adding{Time: 1200, Isu: "1"}), buyings))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a.Applied, []int{11}) {
+		t.Errorf("got %+v", a)
+	}
+
+	// 捨てた request の仮定と合わなければ打ち切る
+	if _, err := v.solve(testStatus(1500, []game.Adding{{Time: 0, Isu: "100"}, {Time: 1200, Isu: "1"}}, buyings)); err != errSolverGaveUp {
+		t.Errorf("got %v", err)
+	}
+	if _, err := v.solve(testStatus(550, append(addings, game.Adding{Time: 1200, Isu: "1"}), buyings)); err != errSolverGaveUp {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,693 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 負荷走行中のゲームログの検証
+//
+// 負荷走行中のルームのログを1秒毎に取り出して検証し, 検証が終わった status は捨てる.
+// currentTime = T の status は T+1000 までに影響する request の結果 (成功, 失敗, 応答なし) が
+// 全て確定してから検証する. request は結果が確定した時点で addIsuDict などにまとめて捨てる.
+
+// status を受信してから検証するまでの最短時間. status は受信後 1000 ミリ秒先までを含むので
+// その間に送られる request を待つ
+const streamStatusDelay = 2 * time.Second
+
+// ログが届くまでの遅れ. エージェントからログを集める場合はその間隔と時計のずれの分だけ遅らせて検証する
+var streamValidationLag time.Duration
+
+var streamValidationError atomic.Value
+
+func getStreamValidationError() error {
+	err := streamValidationError.Load()
+	if err != nil {
+		return err.(error)
+	}
+	return nil
+}
+
+// 応答が無い request を応答なしとして扱うまでの時間
+func streamRequestSettle() time.Duration {
+	return ClientWriteTimeout + ClientRequestTimeout + time.Second
+}
+
+type streamValidator struct {
+	room string
+
+	statusDict map[int][]*GameStatusLog // ClientID => 検証していない status
+
+	requests     []*GameRequestLog        // 結果が確定していない request
+	responses    map[int]*GameResponseLog // requestKey => まだ request と対応させていない response
+	seenResponse map[int]time.Time        // 受信した response の requestKey => 受信した時刻
+	folded       map[int]time.Time        // 結果を確定させた request の requestKey => 確定させた時刻
+	duplicated   map[int]bool             // chaos で2回送った request の RequestID
+	lastConn     map[int]int              // ClientID => 受信した最後の接続の番号
+
+	addIsuDict        map[int64]*big.Int
+	addIsuDictNoRes   map[int64]*big.Int
+	buyItemDict       map[int]map[int]int64
+	buyItemDictNoRes1 map[int]map[int]int64
+	buyItemDictNoRes2 map[int]map[int]int64
+
+	// 応答の無い request の組み合わせを探すために, まだ検証していない status に関係するものを1つずつ残しておく
+	addIsuReq       map[int64][]*big.Int           // Time => 成功した addIsu の isu
+	addIsuNoResReq  map[int64][]noResRequest       // Time => 応答の無い addIsu
+	buyItemNoResReq map[int]map[int][]noResRequest // ItemID => CountBought => 応答の無い buyItem
+
+	// 捨てた応答の無い request は, 最後に説明できた status での仮定で反映されたかどうかを決める
+	noResApplied  map[int]bool          // RequestID => 最後に説明できた status で反映されたとしたか
+	addIsuPruned  *big.Int              // 捨てた addIsu のうち反映されたとしたものの isu の和
+	buyItemPruned map[int]map[int]int64 // ItemID => CountBought => 捨てた buyItem のうち反映されたとしたものの time
+	prunedTime    int64                 // 捨てた request の最大の time. -1 なら捨てていない
+
+	// addIsuDict, addIsuDictNoRes の累積和. addIsuDirty なら作り直す
+	addIsuDirty     bool
+	addIsuKeys      []int64
+	addIsuNoResKeys []int64
+	addIsuSum       map[int64]*big.Int
+	addIsuSumNoRes  map[int64]*big.Int
+
+	itemMasterObj *itemMaster
+
+	validated     int
+	solved        int   // 応答の無い request の組み合わせで説明できた status の数
+	gaveUp        int   // 組み合わせの探索を打ち切った status の数
+	validatedTime int64 // 最後に検証した status の currentTime
+
+	// 失敗したときのレポート用に, まだ検証していない status の前後の結果が確定した request を残しておく
+	history []traceEntry
+}
+
+func newStreamValidator(room string) *streamValidator {
+	v := &streamValidator{
+		room:              room,
+		statusDict:        map[int][]*GameStatusLog{},
+		responses:         map[int]*GameResponseLog{},
+		seenResponse:      map[int]time.Time{},
+		folded:            map[int]time.Time{},
+		duplicated:        map[int]bool{},
+		lastConn:          map[int]int{},
+		addIsuDict:        map[int64]*big.Int{},
+		addIsuDictNoRes:   map[int64]*big.Int{},
+		buyItemDict:       map[int]map[int]int64{},
+		buyItemDictNoRes1: map[int]map[int]int64{},
+		buyItemDictNoRes2: map[int]map[int]int64{},
+		addIsuReq:         map[int64][]*big.Int{},
+		addIsuNoResReq:    map[int64][]noResRequest{},
+		buyItemNoResReq:   map[int]map[int][]noResRequest{},
+		noResApplied:      map[int]bool{},
+		addIsuPruned:      new(big.Int),
+		buyItemPruned:     map[int]map[int]int64{},
+		prunedTime:        -1,
+		itemMasterObj:     newItemMaster(),
+	}
+	for itemID := range mItems {
+		v.buyItemDict[itemID] = map[int]int64{}
+		v.buyItemDictNoRes1[itemID] = map[int]int64{}
+		v.buyItemDictNoRes2[itemID] = map[int]int64{}
+		v.buyItemNoResReq[itemID] = map[int][]noResRequest{}
+		v.buyItemPruned[itemID] = map[int]int64{}
+	}
+	return v
+}
+
+func (v *streamValidator) add(status []*GameStatusLog, request []*GameRequestLog, response []*GameResponseLog) error {
+	updated := map[int]bool{}
+	for _, st := range status {
+		if err := validateStatusFormat(st); err != nil {
+			return err
+		}
+		v.statusDict[st.ClientID] = append(v.statusDict[st.ClientID], st)
+		updated[st.ClientID] = true
+		if v.lastConn[st.ClientID] < st.Conn {
+			v.lastConn[st.ClientID] = st.Conn
+		}
+	}
+	for id := range updated {
+		a := v.statusDict[id]
+		sort.SliceStable(a, func(i, j int) bool {
+			return a[i].ClientTime.Before(a[j].ClientTime)
+		})
+	}
+
+	// response より先に request を記録しているので, 同じ drain で届いても先に印を付ける
+	for _, req := range request {
+		if req.Chaos == chaosDuplicate {
+			v.duplicated[req.RequestID] = true
+		}
+		if v.lastConn[req.ClientID] < req.Conn {
+			v.lastConn[req.ClientID] = req.Conn
+		}
+	}
+
+	for _, x := range response {
+		if v.lastConn[x.ClientID] < x.Conn {
+			v.lastConn[x.ClientID] = x.Conn
+		}
+		key := x.RequestID
+		if _, ok := v.seenResponse[key]; ok && v.duplicated[key] {
+			key = -key
+		}
+		if _, ok := v.seenResponse[key]; ok {
+			return fmt.Errorf("request_id = %v に対するreponseが複数あります", x.RequestID)
+		}
+		v.seenResponse[key] = x.ClientTime
+		if _, ok := v.folded[key]; ok {
+			log.Println("response after timeout", x.RequestID)
+			continue
+		}
+		v.responses[key] = x
+	}
+
+	v.requests = append(v.requests, request...)
+	return nil
+}
+
+// chaos で2回送った request は同じ RequestID なので, 2つ目を負の値で区別する.
+// 2つは同じ内容なので, どちらの response と対応させても結果は変わらない
+func requestKey(req *GameRequestLog) int {
+	if req.Chaos == chaosDuplicate {
+		return -req.RequestID
+	}
+	return req.RequestID
+}
+
+func (v *streamValidator) foldNoResponse(req *GameRequestLog) error {
+	if req.Action == "addIsu" {
+		if _, ok := v.addIsuDictNoRes[req.Time]; !ok {
+			v.addIsuDictNoRes[req.Time] = new(big.Int)
+		}
+		v.addIsuDictNoRes[req.Time].Add(v.addIsuDictNoRes[req.Time], str2big(req.Isu))
+		v.addIsuNoResReq[req.Time] = append(v.addIsuNoResReq[req.Time], noResRequest{RequestID: req.RequestID, Time: req.Time, Isu: str2big(req.Isu)})
+		v.addIsuDirty = true
+	} else if req.Action == "buyItem" {
+		if t, ok := v.buyItemDictNoRes1[req.ItemID][req.CountBought]; !ok || req.Time < t {
+			v.buyItemDictNoRes1[req.ItemID][req.CountBought] = req.Time
+		}
+		if t, ok := v.buyItemDictNoRes2[req.ItemID][req.CountBought]; !ok || req.Time > t {
+			v.buyItemDictNoRes2[req.ItemID][req.CountBought] = req.Time
+		}
+		v.buyItemNoResReq[req.ItemID][req.CountBought] = append(v.buyItemNoResReq[req.ItemID][req.CountBought], noResRequest{RequestID: req.RequestID, Time: req.Time})
+	} else {
+		return fmt.Errorf("something wrong 3")
+	}
+	return nil
+}
+
+func (v *streamValidator) foldResponse(req *GameRequestLog, res *GameResponseLog) error {
+	if req.ClientID != res.ClientID {
+		return fmt.Errorf("request_id = %v に対するrequestとresponseでclient idが一致していません", req.RequestID)
+	}
+	if req.Conn != res.Conn {
+		return fmt.Errorf("request_id = %v に対するresponseをrequestを送ったのとは別の接続で受信しました", req.RequestID)
+	}
+	if !res.IsSuccess {
+		return nil
+	}
+
+	status := getLatestStatus(v.statusDict[req.ClientID], req.ClientTime, res.ClientTime)
+	if status == nil {
+		return fmt.Errorf("request_id = %v に対するstatusを受信していません", req.RequestID)
+	}
+
+	if req.Action == "addIsu" {
+		if err := validateAddIsu(req.RequestID, req.Time, req.Isu, status); err != nil {
+			return err
+		}
+		if _, ok := v.addIsuDict[req.Time]; !ok {
+			v.addIsuDict[req.Time] = new(big.Int)
+		}
+		v.addIsuDict[req.Time].Add(v.addIsuDict[req.Time], str2big(req.Isu))
+		v.addIsuReq[req.Time] = append(v.addIsuReq[req.Time], str2big(req.Isu))
+		v.addIsuDirty = true
+	} else if req.Action == "buyItem" {
+		if err := validateBuyItem(req.RequestID, req.ItemID, req.CountBought, status); err != nil {
+			return err
+		}
+		if _, ok := v.buyItemDict[req.ItemID][req.CountBought]; ok {
+			return fmt.Errorf("buyItem(item_id = %v, count_bought = %v) に対して複数回 is_success = true が存在します : request_id = %v",
+				req.ItemID, req.CountBought, req.RequestID)
+		}
+		v.buyItemDict[req.ItemID][req.CountBought] = req.Time
+	} else {
+		return fmt.Errorf("something wrong 4")
+	}
+	return nil
+}
+
+func prefixSumMilliIsu(dict map[int64]*big.Int) ([]int64, map[int64]*big.Int) {
+	var keys []int64
+	for t := range dict {
+		keys = append(keys, t)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+	big1000 := big.NewInt(1000)
+	sum := make(map[int64]*big.Int)
+	x := big.NewInt(0)
+	for _, t := range keys {
+		x = new(big.Int).Add(x, new(big.Int).Mul(dict[t], big1000))
+		sum[t] = x
+	}
+	return keys, sum
+}
+
+// final でなければ結果が確定したものだけ検証する. final なら残りを全て検証する
+func (v *streamValidator) step(ctx context.Context, now time.Time, final bool) error {
+	settle := streamRequestSettle()
+
+	var (
+		pending              []*GameRequestLog
+		minPendingTime       int64
+		minPendingClientTime time.Time
+	)
+	for _, req := range v.requests {
+		key := requestKey(req)
+		if res, ok := v.responses[key]; ok {
+			if err := v.foldResponse(req, res); err != nil {
+				return err
+			}
+			delete(v.responses, key)
+			result := "failure"
+			if res.IsSuccess {
+				result = "success"
+			}
+			v.history = append(v.history, newTraceEntry(req, res, result))
+		} else if final || settle < now.Sub(req.ClientTime) || req.Conn < v.lastConn[req.ClientID] {
+			// 接続し直した client の前の接続の request には, もう応答が来ない
+			if err := v.foldNoResponse(req); err != nil {
+				return err
+			}
+			v.history = append(v.history, newTraceEntry(req, nil, "no_response"))
+		} else {
+			if len(pending) == 0 || req.Time < minPendingTime {
+				minPendingTime = req.Time
+			}
+			if len(pending) == 0 || req.ClientTime.Before(minPendingClientTime) {
+				minPendingClientTime = req.ClientTime
+			}
+			pending = append(pending, req)
+			continue
+		}
+		v.folded[key] = now
+	}
+	v.requests = pending
+	v.trimKeys(now)
+
+	if v.addIsuDirty {
+		v.addIsuKeys, v.addIsuSum = prefixSumMilliIsu(v.addIsuDict)
+		v.addIsuNoResKeys, v.addIsuSumNoRes = prefixSumMilliIsu(v.addIsuDictNoRes)
+		v.addIsuDirty = false
+	}
+
+	for clientID, a := range v.statusDict {
+		n := 0
+		for _, x := range a {
+			if !final {
+				if now.Sub(x.ClientTime) < streamStatusDelay {
+					break
+				}
+				// 結果が確定していない request が影響する, または request の確定にこの status が要る
+				if 0 < len(pending) && (minPendingTime <= x.Schedule[0].Time+1000 || !x.ClientTime.Before(minPendingClientTime)) {
+					break
+				}
+			}
+			if err := validateStatusBench(
+				x, v.addIsuDict, v.addIsuDictNoRes, v.addIsuSum, v.addIsuSumNoRes, v.addIsuKeys, v.addIsuNoResKeys,
+				v.buyItemDict, v.buyItemDictNoRes1, v.buyItemDictNoRes2, v.itemMasterObj, nil); err != nil {
+				err = fmt.Errorf("time = %v の status において %v", x.Time, err)
+				saveValidationReport(v.report(x, err))
+				return err
+			}
+			if err := v.explain(x); err != nil {
+				err = fmt.Errorf("time = %v の status において %v", x.Time, err)
+				saveValidationReport(v.report(x, err))
+				return err
+			}
+			v.validatedTime = x.Schedule[0].Time
+			n++
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		v.validated += n
+		if n == len(a) {
+			delete(v.statusDict, clientID)
+		} else {
+			v.statusDict[clientID] = append(a[:0:0], a[n:]...)
+		}
+		if ctx.Err() != nil {
+			if final {
+				return ctx.Err()
+			}
+			return nil
+		}
+	}
+	low := v.trimTime()
+	v.trimHistory(low)
+	v.trimNoRes(low)
+	return nil
+}
+
+// 結果を確定させてから streamRequestSettle() 経った request の key を捨てる.
+// request と対応させないまま同じだけ経った response も捨てる
+func (v *streamValidator) trimKeys(now time.Time) {
+	settle := streamRequestSettle()
+	for key, t := range v.folded {
+		if now.Sub(t) <= settle {
+			continue
+		}
+		delete(v.folded, key)
+		delete(v.seenResponse, key)
+		if key < 0 {
+			delete(v.duplicated, -key)
+		}
+	}
+	for key, x := range v.responses {
+		if settle < now.Sub(x.ClientTime) {
+			log.Println("response without request", x.RequestID)
+			delete(v.responses, key)
+			delete(v.seenResponse, key)
+		}
+	}
+}
+
+// これより前の request はまだ検証していない status の前後に入らない
+func (v *streamValidator) trimTime() int64 {
+	low := v.validatedTime
+	for _, a := range v.statusDict {
+		if 0 < len(a) && a[0].Schedule[0].Time < low {
+			low = a[0].Schedule[0].Time
+		}
+	}
+	return low - validationReportWindow
+}
+
+// low より前の request を history から捨てる
+func (v *streamValidator) trimHistory(low int64) {
+	n := 0
+	for _, e := range v.history {
+		if low <= e.Time {
+			v.history[n] = e
+			n++
+		}
+	}
+	for i := n; i < len(v.history); i++ {
+		v.history[i] = traceEntry{}
+	}
+	v.history = v.history[:n]
+}
+
+// time が low より前の request を1つずつ残しておくのをやめる.
+// 成功した addIsu は adding にしか使わないので捨てるだけで良い.
+// 応答の無い request は, 反映されたとしたものを addIsuPruned, buyItemPruned にまとめる
+func (v *streamValidator) trimNoRes(low int64) {
+	for t := range v.addIsuReq {
+		if t < low {
+			delete(v.addIsuReq, t)
+		}
+	}
+	for t, a := range v.addIsuNoResReq {
+		if low <= t {
+			continue
+		}
+		for _, r := range a {
+			if v.noResApplied[r.RequestID] {
+				v.addIsuPruned.Add(v.addIsuPruned, r.Isu)
+			}
+			delete(v.noResApplied, r.RequestID)
+		}
+		delete(v.addIsuNoResReq, t)
+		if v.prunedTime < t {
+			v.prunedTime = t
+		}
+	}
+	for itemID, m := range v.buyItemNoResReq {
+		for k, a := range m {
+			n := 0
+			for _, r := range a {
+				if low <= r.Time {
+					a[n] = r
+					n++
+					continue
+				}
+				if v.noResApplied[r.RequestID] {
+					v.buyItemPruned[itemID][k] = r.Time
+				}
+				delete(v.noResApplied, r.RequestID)
+				if v.prunedTime < r.Time {
+					v.prunedTime = r.Time
+				}
+			}
+			if n == 0 {
+				delete(m, k)
+			} else {
+				m[k] = a[:n]
+			}
+		}
+	}
+}
+
+// 検証に失敗した status のレポートを作る
+func (v *streamValidator) report(st *GameStatusLog, err error) *validationReport {
+	r := &validationReport{
+		Room:       v.room,
+		Message:    err.Error(),
+		ClientID:   st.ClientID,
+		ClientTime: st.ClientTime,
+		Status:     st.GameStatus,
+		Trace:      []traceEntry{},
+		BuyHistory: []buyRecord{},
+		NoResponse: v.noResCandidates(st),
+	}
+
+	exp := new(statusExpected)
+	validateStatusBench(
+		st, v.addIsuDict, v.addIsuDictNoRes, v.addIsuSum, v.addIsuSumNoRes, v.addIsuKeys, v.addIsuNoResKeys,
+		v.buyItemDict, v.buyItemDictNoRes1, v.buyItemDictNoRes2, v.itemMasterObj, exp)
+	// 期待値を計算する前に失敗した
+	if len(exp.Schedule) != 0 {
+		r.Expected = exp
+	}
+
+	match := traceFilter(st, err)
+	if match == nil {
+		// どの値が食い違ったか分からなければ status の前後の request を全て出す
+		currentTime := st.Schedule[0].Time
+		match = func(e traceEntry) bool {
+			return currentTime-validationReportWindow <= e.Time && e.Time <= currentTime+validationReportWindow
+		}
+	}
+	for _, e := range v.history {
+		if match(e) {
+			r.Trace = append(r.Trace, e)
+		}
+	}
+	for _, req := range v.requests {
+		if e := newTraceEntry(req, nil, "pending"); match(e) {
+			r.Trace = append(r.Trace, e)
+		}
+	}
+	sort.Slice(r.Trace, func(i, j int) bool {
+		if r.Trace[i].Time != r.Trace[j].Time {
+			return r.Trace[i].Time < r.Trace[j].Time
+		}
+		return r.Trace[i].RequestID < r.Trace[j].RequestID
+	})
+
+	// 期待値と食い違う item の, count_bought 付近の購入履歴
+	expected := map[int]expectedItem{}
+	if r.Expected != nil {
+		for _, x := range r.Expected.Items {
+			expected[x.ItemID] = x
+		}
+	}
+	for _, x := range st.Items {
+		if e, ok := expected[x.ItemID]; ok && e.CountBought == x.CountBought && e.CountBuilt == x.CountBuilt &&
+			e.Power == x.Power && len(e.Building) == len(x.Building) {
+			continue
+		}
+		for c := x.CountBought - validationReportBuyHistory; c <= x.CountBought+validationReportBuyHistory; c++ {
+			if c < 0 {
+				continue
+			}
+			if t, ok := v.buyItemDict[x.ItemID][c]; ok {
+				r.BuyHistory = append(r.BuyHistory, buyRecord{x.ItemID, c, "success", t, t})
+			}
+			if t1, ok := v.buyItemDictNoRes1[x.ItemID][c]; ok {
+				t2 := v.buyItemDictNoRes2[x.ItemID][c]
+				r.BuyHistory = append(r.BuyHistory, buyRecord{x.ItemID, c, "no_response", t1, t2})
+			}
+		}
+	}
+	sort.SliceStable(r.BuyHistory, func(i, j int) bool {
+		return r.BuyHistory[i].ItemID < r.BuyHistory[j].ItemID
+	})
+	return r
+}
+
+var (
+	traceAddingRe   = regexp.MustCompile(`adding\[(\d+)\]`)
+	traceAddIsuRe   = regexp.MustCompile(`addIsu \(request_id = \d+\) .* time = (\d+) `)
+	traceItemRe     = regexp.MustCompile(`(?:items|on_sale)\[item_id = (\d+)\]|on_saleに item_id = (\d+) `)
+	traceScheduleRe = regexp.MustCompile(`schedule\[(\d+)\]`)
+)
+
+// err で食い違った値を決める request だけを選ぶ. 応答の無い request も同じ条件で選ぶ.
+// どの値か分からなければ nil を返す
+func traceFilter(st *GameStatusLog, err error) func(traceEntry) bool {
+	msg := err.Error()
+
+	// adding[i] は time が同じ addIsu の和
+	if m := traceAddingRe.FindStringSubmatch(msg); m != nil {
+		i, _ := strconv.Atoi(m[1])
+		if len(st.Adding) <= i {
+			return nil
+		}
+		t := st.Adding[i].Time
+		return func(e traceEntry) bool {
+			return e.Action == "addIsu" && e.Time == t
+		}
+	}
+	if m := traceAddIsuRe.FindStringSubmatch(msg); m != nil {
+		t, _ := strconv.ParseInt(m[1], 10, 64)
+		return func(e traceEntry) bool {
+			return e.Action == "addIsu" && e.Time == t
+		}
+	}
+
+	// schedule[i] と椅子の数は time までの全ての addIsu と buyItem で決まる
+	upTo := int64(-1)
+	if m := traceScheduleRe.FindStringSubmatch(msg); m != nil {
+		i, _ := strconv.Atoi(m[1])
+		if i < len(st.Schedule) {
+			upTo = st.Schedule[i].Time
+		}
+	} else if strings.Contains(msg, "椅子の数が負") || strings.Contains(msg, "schedule の要素数") || strings.Contains(msg, "schedule と on_sale") {
+		upTo = st.Schedule[len(st.Schedule)-1].Time
+	}
+
+	// items[item_id] は count_bought 付近の同じ item の buyItem で決まる.
+	// on_sale はそれに加えて, 売り出される時刻までの椅子の数で決まる
+	itemID := 0
+	if m := traceItemRe.FindStringSubmatch(msg); m != nil {
+		itemID, _ = strconv.Atoi(m[1] + m[2])
+		if strings.Contains(msg, "on_sale") {
+			upTo = st.Schedule[len(st.Schedule)-1].Time
+		}
+	}
+	if itemID == 0 && upTo < 0 {
+		return nil
+	}
+
+	countBought := -1
+	for _, x := range st.Items {
+		if x.ItemID == itemID {
+			countBought = x.CountBought
+		}
+	}
+	return func(e traceEntry) bool {
+		if e.Time <= upTo {
+			return true
+		}
+		if e.Action != "buyItem" || e.ItemID != itemID {
+			return false
+		}
+		return countBought < 0 ||
+			countBought-validationReportBuyHistory <= e.CountBought && e.CountBought <= countBought+validationReportBuyHistory
+	}
+}
+
+// validateStatusBench を通った status を応答の無い request の組み合わせで説明する
+func (v *streamValidator) explain(st *GameStatusLog) error {
+	a, err := v.solve(st)
+	if err == errSolverGaveUp {
+		v.gaveUp++
+		validationNoResponseTotal.With("gave_up").Inc()
+		return nil
+	}
+	if err != nil {
+		validationNoResponseTotal.With("failure").Inc()
+		return err
+	}
+	if a != nil {
+		for _, id := range a.Applied {
+			v.noResApplied[id] = true
+		}
+		for _, id := range a.NotApplied {
+			v.noResApplied[id] = false
+		}
+		v.solved++
+		validationNoResponseTotal.With("solved").Inc()
+		if onNoResAssumption != nil {
+			onNoResAssumption(v.room, st, a)
+		}
+	}
+	return nil
+}
+
+// gamelog validate -explain で, 応答の無い request の組み合わせを status 毎に出力する
+var onNoResAssumption func(room string, st *GameStatusLog, a *noResAssumption)
+
+func (v *streamValidator) finish(ctx context.Context) error {
+	err := v.step(ctx, time.Now(), true)
+	if 0 < v.solved+v.gaveUp {
+		log.Println("no response requests", v.room, "solved:", v.solved, "gave up:", v.gaveUp)
+	}
+	return err
+}
+
+// 負荷走行中のルームのログを ctx が終わるまで1秒毎に検証する. 失敗したら onFail を呼んで終わる
+func runStreamValidation(ctx context.Context, onFail func(error)) {
+	beat := time.NewTicker(time.Second)
+	defer beat.Stop()
+
+	for {
+		select {
+		case <-beat.C:
+		case <-ctx.Done():
+			return
+		}
+
+		var (
+			wg      sync.WaitGroup
+			failure atomic.Value
+			sem     = make(chan struct{}, runtime.NumCPU())
+		)
+		for _, r := range getRoomNameByTag("load") {
+			room := r
+			sem <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				err := getGameLogger(room).stepValidation(ctx)
+				if err != nil {
+					failure.Store(recordError(errCodeValidation, fmt.Errorf("Room %v にて %v", room, err), room))
+				}
+			}()
+		}
+		wg.Wait()
+
+		if err := failure.Load(); err != nil {
+			streamValidationError.Store(err)
+			onFail(err.(error))
+			return
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"app/game"
+)
+
+func TestReportTrace(t *testing.T) {
+	v := newTestValidator()
+	v.history = []traceEntry{
+		{RequestID: 1, Action: "addIsu", Time: 100, Isu: "100", Result: "success"},
+		{RequestID: 2, Action: "buyItem", Time: 200, ItemID: 1, CountBought: 0, Result: "success"},
+		{RequestID: 3, Action: "addIsu", Time: 1500, Isu: "5", Result: "success"},
+		{RequestID: 4, Action: "addIsu", Time: 1500, Isu: "3", Result: "no_response"},
+		{RequestID: 5, Action: "addIsu", Time: 1600, Isu: "7", Result: "success"},
+		{RequestID: 6, Action: "buyItem", Time: 1700, ItemID: 1, CountBought: 1, Result: "no_response"},
+	}
+	st := testStatus(1000,
+		[]game.Adding{{Time: 100, Isu: "100"}, {Time: 1500, Isu: "4"}, {Time: 1600, Isu: "7"}},
+		[]game.Buying{{ItemID: 1, Ordinal: 1, Time: 200}})
+
+	trace := func(msg string) []int {
+		var ids []int
+		for _, e := range v.report(st, errors.New("time = 1000 の status において "+msg)).Trace {
+			ids = append(ids, e.RequestID)
+		}
+		return ids
+	}
+	for _, c := range []struct {
+		msg string
+		ids []int
+	}{
+		{"adding[0].isu が正しくありません : actual 4, expected 5 以上", []int{3, 4}},
+		{"addIsu (request_id = 5) に対するaddingの反映が行われていません : addingに time = 1600 の要素が存在しません", []int{5}},
+		{"items[item_id = 1].count_bought が正しくありません : actual 1, expected 2", []int{2, 6}},
+		{"schedule[0].milli_isu が正しくありません : actual 1, expected 2", []int{1, 2}},
+		{"something wrong 1", []int{1, 2, 3, 4, 5, 6}},
+	} {
+		if ids := trace(c.msg); !reflect.DeepEqual(ids, c.ids) {
+			t.Errorf("%v: got %v, expected %v", c.msg, ids, c.ids)
+		}
+	}
+}
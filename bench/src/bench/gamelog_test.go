@@ -0,0 +1,99 @@
+package main
+
+import (
+	"app/game"
+	"app/game/gametest"
+	"app/proptest"
+	"math/big"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// webapp と同じ参照実装で計算した status がベンチマーカーの検証を通ること
+func TestValidateStatusReference(t *testing.T) {
+	proptest.Seeds(t, 300, func(seed int64, r *rand.Rand) {
+		c := gametest.GenCase(r)
+		mItems = c.MItems
+
+		// ベンチマーカーは currentTime から 1000 ミリ秒より先の buyItem を送らない
+		buyings := c.Buyings[:0]
+		for _, b := range c.Buyings {
+			if b.Time <= c.CurrentTime+1000 {
+				buyings = append(buyings, b)
+			}
+		}
+		c.Buyings = buyings
+
+		// count_bought の順に買うので, item 毎に time は昇順になる
+		sort.SliceStable(c.Buyings, func(i, j int) bool {
+			if c.Buyings[i].ItemID != c.Buyings[j].ItemID {
+				return c.Buyings[i].ItemID < c.Buyings[j].ItemID
+			}
+			return c.Buyings[i].Time < c.Buyings[j].Time
+		})
+		for i := range c.Buyings {
+			if 0 < i && c.Buyings[i-1].ItemID == c.Buyings[i].ItemID {
+				c.Buyings[i].Ordinal = c.Buyings[i-1].Ordinal + 1
+			} else {
+				c.Buyings[i].Ordinal = 1
+			}
+		}
+
+		st := &GameStatusLog{GameStatus: game.CalcStatus(c.CurrentTime, c.MItems, c.Addings, c.Buyings)}
+		// webapp は椅子が足りない buyItem を受け付けない
+		if st.Schedule[0].MilliIsu.Mantissa < 0 {
+			return
+		}
+		if err := validateStatusFormat(st); err != nil {
+			t.Fatalf("seed %v: %v\n%v", seed, err, c)
+		}
+
+		// 全て成功した
+		v := newTestCaseValidator(c, nil)
+		if err := validateStatus(st, v.addIsuDict, v.buyItemDict, newItemMaster()); err != nil {
+			t.Fatalf("seed %v: validateStatus: %v\n%v", seed, err, c)
+		}
+		if err := v.validateStatusBench(st); err != nil {
+			t.Fatalf("seed %v: validateStatusBench: %v\n%v", seed, err, c)
+		}
+
+		// 一部の addIsu に応答が無かった
+		v = newTestCaseValidator(c, r)
+		if err := v.validateStatusBench(st); err != nil {
+			t.Fatalf("seed %v: validateStatusBench: %v\n%v", seed, err, c)
+		}
+		if _, err := v.solve(st); err != nil && err != errSolverGaveUp {
+			t.Fatalf("seed %v: solve: %v\n%v", seed, err, c)
+		}
+	})
+}
+
+// c の request を全て受け付けた検証. r があれば addIsu の半分を応答なしにする
+func newTestCaseValidator(c *gametest.Case, r *rand.Rand) *streamValidator {
+	v := newStreamValidator("test")
+	for _, a := range c.Addings {
+		req := &GameRequest{RequestID: len(v.addIsuReq) + len(v.addIsuNoResReq) + 1, Action: "addIsu", Time: a.Time, Isu: a.Isu}
+		if r != nil && r.Intn(2) == 0 {
+			v.foldNoResponse(&GameRequestLog{GameRequest: req})
+			continue
+		}
+		if _, ok := v.addIsuDict[a.Time]; !ok {
+			v.addIsuDict[a.Time] = new(big.Int)
+		}
+		v.addIsuDict[a.Time].Add(v.addIsuDict[a.Time], str2big(a.Isu))
+		v.addIsuReq[a.Time] = append(v.addIsuReq[a.Time], str2big(a.Isu))
+	}
+	for _, b := range c.Buyings {
+		v.buyItemDict[b.ItemID][b.Ordinal-1] = b.Time
+	}
+	v.addIsuKeys, v.addIsuSum = prefixSumMilliIsu(v.addIsuDict)
+	v.addIsuNoResKeys, v.addIsuSumNoRes = prefixSumMilliIsu(v.addIsuDictNoRes)
+	return v
+}
+
+func (v *streamValidator) validateStatusBench(st *GameStatusLog) error {
+	return validateStatusBench(
+		st, v.addIsuDict, v.addIsuDictNoRes, v.addIsuSum, v.addIsuSumNoRes, v.addIsuKeys, v.addIsuNoResKeys,
+		v.buyItemDict, v.buyItemDictNoRes1, v.buyItemDictNoRes2, v.itemMasterObj, nil)
+}
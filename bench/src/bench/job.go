@@ -8,17 +8,88 @@ type BenchResult struct {
 	JobID   string `json:"job_id"`
 	IPAddrs string `json:"ip_addrs"`
 
-	Pass      bool     `json:"pass"`
-	Score     int64    `json:"score"`
-	Message   string   `json:"message"`
-	Errors    []string `json:"error"`
-	Logs      []string `json:"log"`
-	LoadLevel int      `json:"load_level"`
+	Pass      bool         `json:"pass"`
+	Score     int64        `json:"score"`
+	Message   string       `json:"message"`
+	Errors    []BenchError `json:"error"`
+	Logs      []string     `json:"log"`
+	LoadLevel int          `json:"load_level"`
+
+	// Score の内訳
+	ScoreBreakdown *ScoreBreakdown `json:"score_breakdown,omitempty"`
+
+	// 処理時間の分布 (latency-response|addIsu など)
+	Latency map[string]LatencySummary `json:"latency,omitempty"`
+
+	TimeSeries []MetricsSample `json:"time_series,omitempty"`
+
+	// -protocol-check の検査毎の結果
+	Checks []CheckResult `json:"checks,omitempty"`
 
 	StartTime time.Time `json:"start_time"`
 	EndTime   time.Time `json:"end_time"`
 }
 
+// preTest の名前付きの検査の結果
+type CheckResult struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Pass        bool    `json:"pass"`
+	Message     string  `json:"message,omitempty"`
+	Elapsed     float64 `json:"elapsed"` // 秒
+}
+
+// 分類毎のエラー. Sample, Context は最初に起きたもの
+type BenchError struct {
+	Code        string    `json:"code"`
+	Description string    `json:"description"`
+	Count       int64     `json:"count"`
+	First       time.Time `json:"first"`
+	Last        time.Time `json:"last"`
+	Sample      string    `json:"sample"`
+	Context     string    `json:"context,omitempty"`
+}
+
+type ScoreBreakdown struct {
+	Formula string      `json:"formula"`
+	Items   []ScoreItem `json:"items"`
+	Total   int64       `json:"total"`
+}
+
+// Points = floor(Count * Weight)
+type ScoreItem struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Count       float64 `json:"count"`
+	Weight      float64 `json:"weight"`
+	Points      int64   `json:"points"`
+}
+
+// ミリ秒
+type LatencySummary struct {
+	Count int64   `json:"count"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+// 負荷走行中の1秒毎の記録. Open から Errors まではその1秒間の数
+type MetricsSample struct {
+	Elapsed   float64 `json:"elapsed"`
+	LoadLevel int     `json:"load_level"`
+	Active    int64   `json:"active"`
+	Open      int64   `json:"open"`
+	Close     int64   `json:"close"`
+	AddIsuOK  int64   `json:"addisu_ok"`
+	AddIsuNG  int64   `json:"addisu_ng"`
+	BuyItemOK int64   `json:"buyitem_ok"`
+	BuyItemNG int64   `json:"buyitem_ng"`
+	Errors    int64   `json:"errors"`
+
+	Latency map[string]LatencySummary `json:"latency,omitempty"`
+}
+
 type Job struct {
 	ID      int    `json:"id"`
 	TeamID  int    `json:"team_id"`
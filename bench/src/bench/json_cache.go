@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bench/counter"
 	"sync"
 )
 
@@ -26,16 +25,16 @@ func retrieveBinCache(b []byte, h uint64) (*GameStatus, bool) {
 	}
 
 	if !StrictCheckCacheConflict {
-		counter.IncKey("hash-bin-hit")
+		jsonCacheTotal.With("bin", "hit").Inc()
 		return v, true
 	}
 
 	if checkSameAdding(b, v.Adding) && checkSameSchedule(b, v.Schedule) &&
 		checkSameItems(b, v.Items) && checkSameOnSale(b, v.OnSale) {
-		counter.IncKey("hash-bin-hit")
+		jsonCacheTotal.With("bin", "hit").Inc()
 		return v, true
 	} else {
-		counter.IncKey("hash-bin-conflict")
+		jsonCacheTotal.With("bin", "conflict").Inc()
 		return nil, false
 	}
 }
@@ -56,15 +55,15 @@ func retrieveAddingCache(b []byte, h uint64) ([]Adding, bool) {
 	}
 
 	if !StrictCheckCacheConflict {
-		counter.IncKey("hash-adding-hit")
+		jsonCacheTotal.With("adding", "hit").Inc()
 		return v, true
 	}
 
 	if checkSameAdding(b, v) {
-		counter.IncKey("hash-adding-hit")
+		jsonCacheTotal.With("adding", "hit").Inc()
 		return v, true
 	} else {
-		counter.IncKey("hash-adding-conflict")
+		jsonCacheTotal.With("adding", "conflict").Inc()
 		return nil, false
 	}
 }
@@ -85,15 +84,15 @@ func retrieveScheduleCache(b []byte, h uint64) ([]Schedule, bool) {
 	}
 
 	if !StrictCheckCacheConflict {
-		counter.IncKey("hash-schedule-hit")
+		jsonCacheTotal.With("schedule", "hit").Inc()
 		return v, true
 	}
 
 	if checkSameSchedule(b, v) {
-		counter.IncKey("hash-schedule-hit")
+		jsonCacheTotal.With("schedule", "hit").Inc()
 		return v, true
 	} else {
-		counter.IncKey("hash-schedule-conflict")
+		jsonCacheTotal.With("schedule", "conflict").Inc()
 		return nil, false
 	}
 }
@@ -114,15 +113,15 @@ func retrieveItemsCache(b []byte, h uint64) ([]Item, bool) {
 	}
 
 	if !StrictCheckCacheConflict {
-		counter.IncKey("hash-items-hit")
+		jsonCacheTotal.With("items", "hit").Inc()
 		return v, true
 	}
 
 	if checkSameItems(b, v) {
-		counter.IncKey("hash-items-hit")
+		jsonCacheTotal.With("items", "hit").Inc()
 		return v, true
 	} else {
-		counter.IncKey("hash-items-conflict")
+		jsonCacheTotal.With("items", "conflict").Inc()
 		return nil, false
 	}
 }
@@ -143,15 +142,15 @@ func retrieveOnSaleCache(b []byte, h uint64) ([]OnSale, bool) {
 	}
 
 	if !StrictCheckCacheConflict {
-		counter.IncKey("hash-onsale-hit")
+		jsonCacheTotal.With("onsale", "hit").Inc()
 		return v, true
 	}
 
 	if checkSameOnSale(b, v) {
-		counter.IncKey("hash-onsale-hit")
+		jsonCacheTotal.With("onsale", "hit").Inc()
 		return v, true
 	} else {
-		counter.IncKey("hash-onsale-conflict")
+		jsonCacheTotal.With("onsale", "conflict").Inc()
 		return nil, false
 	}
 }
@@ -148,8 +148,8 @@ func decodeScheduleJson(b []byte) ([]Schedule, error) {
 		}
 		schedule = append(schedule, Schedule{
 			Time:       time,
-			MilliIsu:   Exponential{milli_isu0, milli_isu1},
-			TotalPower: Exponential{total_power0, total_power1},
+			MilliIsu:   Exponential{Mantissa: milli_isu0, Exponent: milli_isu1},
+			TotalPower: Exponential{Mantissa: total_power0, Exponent: total_power1},
 		})
 	}, "schedule")
 	if err != nil {
@@ -230,7 +230,7 @@ func decodeItemsJson(b []byte) ([]Item, error) {
 			building = append(building, Building{
 				Time:       time,
 				CountBuilt: int(count_built),
-				Power:      Exponential{power0, power1},
+				Power:      Exponential{Mantissa: power0, Exponent: power1},
 			})
 		}, "building")
 		if err != nil {
@@ -241,8 +241,8 @@ func decodeItemsJson(b []byte) ([]Item, error) {
 			ItemID:      int(item_id),
 			CountBought: int(count_bought),
 			CountBuilt:  int(count_built),
-			NextPrice:   Exponential{next_price0, next_price1},
-			Power:       Exponential{power0, power1},
+			NextPrice:   Exponential{Mantissa: next_price0, Exponent: next_price1},
+			Power:       Exponential{Mantissa: power0, Exponent: power1},
 			Building:    building,
 		})
 	}, "items")
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+)
+
+// 負荷レベルの制御
+//
+// benchmarkMain は1秒毎に loadMetrics を集めて LoadController に渡し、
+// 返ってきた loadDecision に従って LoadScenario の LevelUp, AddUsers を呼ぶ。
+type LoadController interface {
+	Decide(m loadMetrics) loadDecision
+}
+
+// 1秒毎の負荷の状況
+type loadMetrics struct {
+	Elapsed time.Duration
+	Level   int
+
+	// 接続数の累計と現在の接続数. Close はシナリオが意図して切断したものを除く
+	Open   int64
+	Close  int64
+	Active int64
+
+	// リクエスト数とエラー数の累計
+	Requests int64
+	Errors   int64
+
+	RecentError *clientError
+}
+
+type loadDecision struct {
+	// 負荷レベルを上げる回数
+	LevelUp int
+	// 0 より大きければ AddUsers を呼ぶ
+	AddUsers int
+	// loadLogs に残す内容. 空なら残さない
+	Log string
+}
+
+// フラグで指定するパラメータ
+type loadControlParams struct {
+	Level            int
+	Rate             float64
+	StepSize         int
+	StepInterval     time.Duration
+	TargetErrorRate  float64
+	Kp, Ki, Kd       float64
+	MaxLevelUpPerSec int
+}
+
+var loadControl = loadControlParams{
+	Level:            10,
+	Rate:             0.2,
+	StepSize:         5,
+	StepInterval:     10 * time.Second,
+	TargetErrorRate:  0.01,
+	Kp:               50,
+	Ki:               5,
+	Kd:               0,
+	MaxLevelUpPerSec: 3,
+}
+
+var loadControllers = map[string]func() LoadController{
+	// 従来の判定. エラーが無ければ毎秒レベルを上げ, 切断が増えたら最大接続数の6割を上限にする
+	"heuristic": func() LoadController { return &heuristicController{activeLimit: -1} },
+	// -load-level まで上げたらそのまま
+	"fixed": func() LoadController { return &fixedController{level: loadControl.Level} },
+	// 毎秒 -load-rate ずつレベルを上げる
+	"linear": func() LoadController { return &linearController{rate: loadControl.Rate} },
+	// -load-step-interval 毎に -load-step ずつレベルを上げる
+	"step": func() LoadController {
+		return &stepController{size: loadControl.StepSize, interval: loadControl.StepInterval}
+	},
+	// エラー率が -load-target-error に近づくようにレベルを上げる
+	"pid": func() LoadController {
+		return &pidController{
+			target: loadControl.TargetErrorRate,
+			kp:     loadControl.Kp, ki: loadControl.Ki, kd: loadControl.Kd,
+			maxStep: loadControl.MaxLevelUpPerSec,
+		}
+	},
+}
+
+func loadControllerNames() []string {
+	var names []string
+	for name := range loadControllers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newLoadController(name string) (LoadController, error) {
+	f, ok := loadControllers[name]
+	if !ok {
+		return nil, fmt.Errorf("負荷制御 %v は存在しません %v", name, loadControllerNames())
+	}
+	return f(), nil
+}
+
+// 目標のレベルまで上げる. 下げることはできない
+func levelUpTo(m loadMetrics, level int, name string) loadDecision {
+	if level <= m.Level {
+		return loadDecision{}
+	}
+	return loadDecision{
+		LevelUp: level - m.Level,
+		Log:     fmt.Sprintf("接続数が増加します (%v: レベル %v)", name, level),
+	}
+}
+
+type heuristicController struct {
+	maxActive   int64
+	prevClose   int64
+	prevActive  int64
+	activeLimit int64
+}
+
+func (c *heuristicController) Decide(m loadMetrics) loadDecision {
+	err := m.RecentError
+	hasRecentErr := err != nil && time.Since(err.t) < 5*time.Second
+
+	if c.maxActive < m.Active {
+		c.maxActive = m.Active
+	}
+
+	if 5 < c.prevActive && float64(c.prevActive)*0.2 < float64(m.Close-c.prevClose) {
+		c.activeLimit = int64(float64(c.maxActive) * 0.6)
+	}
+	defer func() {
+		c.prevClose = m.Close
+		c.prevActive = m.Active
+	}()
+
+	log.Println("Active:", m.Active, "Limit:", c.activeLimit)
+
+	if hasRecentErr {
+		log.Println("RecentError", err)
+	}
+	if !hasRecentErr && c.activeLimit == -1 {
+		return loadDecision{LevelUp: 1, Log: "接続数が増加します"}
+	} else if 0 < c.activeLimit && m.Active < c.activeLimit {
+		return loadDecision{
+			AddUsers: int(c.activeLimit - m.Active),
+			Log:      fmt.Sprintf("接続数が増加します (上限:%v)", c.activeLimit),
+		}
+	}
+	return loadDecision{}
+}
+
+type fixedController struct {
+	level int
+}
+
+func (c *fixedController) Decide(m loadMetrics) loadDecision {
+	return levelUpTo(m, c.level, "fixed")
+}
+
+type linearController struct {
+	rate float64
+}
+
+func (c *linearController) Decide(m loadMetrics) loadDecision {
+	return levelUpTo(m, int(c.rate*m.Elapsed.Seconds()), "linear")
+}
+
+type stepController struct {
+	size     int
+	interval time.Duration
+}
+
+func (c *stepController) Decide(m loadMetrics) loadDecision {
+	return levelUpTo(m, c.size*int(m.Elapsed/c.interval), "step")
+}
+
+const pidIntegralLimit = 1.0
+
+// 直前の1秒間のエラー率 (エラー数 / リクエスト数) と目標の差からレベルを上げる数を決める.
+// レベルは下げられないので, エラー率が目標を超えている間は何もしない
+type pidController struct {
+	target     float64
+	kp, ki, kd float64
+	maxStep    int
+
+	integral     float64
+	prevErr      float64
+	prevRequests int64
+	prevErrors   int64
+	carry        float64
+}
+
+func (c *pidController) Decide(m loadMetrics) loadDecision {
+	requests := m.Requests - c.prevRequests
+	errors := m.Errors - c.prevErrors
+	c.prevRequests, c.prevErrors = m.Requests, m.Errors
+
+	rate := 0.0
+	if 0 < requests {
+		rate = float64(errors) / float64(requests)
+	} else if 0 < errors {
+		rate = 1
+	}
+
+	e := c.target - rate
+	c.integral += e
+	// 上げ続けた結果 積分項が大きくなりすぎないようにする
+	c.integral = math.Max(-pidIntegralLimit, math.Min(c.integral, pidIntegralLimit))
+	d := e - c.prevErr
+	c.prevErr = e
+
+	u := c.kp*e + c.ki*c.integral + c.kd*d
+	log.Printf("PID error_rate:%.4f target:%.4f output:%.3f", rate, c.target, u)
+
+	if u <= 0 {
+		c.carry = 0
+		return loadDecision{}
+	}
+	c.carry += u
+	n := int(c.carry)
+	c.carry -= float64(n)
+	if c.maxStep < n {
+		n = c.maxStep
+	}
+	if n == 0 {
+		return loadDecision{}
+	}
+	return loadDecision{
+		LevelUp: n,
+		Log:     fmt.Sprintf("接続数が増加します (pid: エラー率 %.2f%%)", rate*100),
+	}
+}
+
+// シナリオファイルの ramp に従う
+type rampController struct {
+	scenario rampScenario
+}
+
+func (c *rampController) Decide(m loadMetrics) loadDecision {
+	level, _ := c.scenario.TargetLevel(m.Elapsed)
+	return levelUpTo(m, level, "ramp")
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// 負荷走行のシナリオ
+//
+// benchmarkMain は負荷レベルの判定だけを行い、実際にどのような接続を作るかはシナリオに任せる。
+type LoadScenario interface {
+	// 負荷走行の開始時に1度だけ呼ばれる. ctx は負荷走行の終了時に Done になる
+	Setup(ctx context.Context) error
+
+	// 負荷レベルが上がった時 (と開始直後) に呼ばれる
+	LevelUp()
+
+	// 接続数の上限に達していない時に呼ばれる. max 人以上は増やさないこと (max <= 0 なら上限なし)
+	AddUsers(max int)
+
+	// 負荷走行の終了後に呼ばれる
+	Teardown()
+
+	// printMetrics で出力する追加の情報
+	Metrics() []string
+}
+
+var loadScenarios = map[string]func() LoadScenario{
+	// 従来の負荷. ルームを増やしつつ各ルームに5人ずつaddIsuするユーザを入れる
+	"default": func() LoadScenario {
+		return &roomScenario{user: LoadAddIsu, resident: LoadBuyItem, residents: 1, usersPerRoom: 5, roomsPerLevel: 1}
+	},
+	// 1-2人しかいないルームを大量に作る
+	"tiny-rooms": func() LoadScenario {
+		return &roomScenario{user: LoadAddIsu, resident: LoadBuyItem, residents: 1, usersPerRoom: 1, roomsPerLevel: 5, newRoomOnAdd: true}
+	},
+	// 1つのルームに全員を入れる
+	"huge-room": func() LoadScenario {
+		return &roomScenario{user: LoadAddIsu, resident: LoadBuyItem, residents: 3, usersPerRoom: 10, roomsPerLevel: 1, maxRooms: 1}
+	},
+	// 接続と切断を繰り返す
+	"reconnect-storm": func() LoadScenario {
+		return &roomScenario{user: LoadReconnect, resident: LoadBuyItem, residents: 1, usersPerRoom: 5, roomsPerLevel: 1}
+	},
+	// ほとんどのユーザが buyItem する
+	"buy-heavy": func() LoadScenario {
+		return &roomScenario{user: LoadBuyItem, resident: LoadAddIsu, residents: 2, usersPerRoom: 5, roomsPerLevel: 1}
+	},
+}
+
+func loadScenarioNames() []string {
+	var names []string
+	for name := range loadScenarios {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// name は登録されたシナリオ名, または YAML/JSON のシナリオファイルのパス
+func newLoadScenario(name string) (LoadScenario, error) {
+	if isScenarioFile(name) {
+		return newFileScenario(name)
+	}
+	f, ok := loadScenarios[name]
+	if !ok {
+		return nil, fmt.Errorf("シナリオ %v は存在しません %v", name, loadScenarioNames())
+	}
+	return f(), nil
+}
+
+// RSLoadRoom を使ってルーム単位で負荷をかけるシナリオ
+type roomScenario struct {
+	user      userLoop
+	resident  userLoop
+	residents int
+
+	// 1つのルームに一度に追加するユーザ数
+	usersPerRoom int
+	// LevelUp で作るルーム数
+	roomsPerLevel int
+	// 0 なら無制限
+	maxRooms int
+	// AddUsers で既存のルームではなく新しいルームにユーザを入れる
+	newRoomOnAdd bool
+
+	ctx   context.Context
+	wg    sync.WaitGroup
+	chans []chan struct{}
+	users int
+}
+
+func (s *roomScenario) Setup(ctx context.Context) error {
+	s.ctx = ctx
+	return nil
+}
+
+func (s *roomScenario) addRoom() bool {
+	if 0 < s.maxRooms && s.maxRooms <= len(s.chans) {
+		return false
+	}
+	c := make(chan struct{}, 1000)
+	// ルーム名の順序が -seed で決まるようにここで作る
+	roomName := genRandomRoomName("load")
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		RSLoadRoom(s.ctx, roomName, c, s.user, s.resident, s.residents)
+	}()
+	s.chans = append(s.chans, c)
+	return true
+}
+
+// 各ルームに usersPerRoom 人ずつ入れるが max人到達時点でやめる
+func (s *roomScenario) addRoomUser(chans []chan struct{}, max int) int {
+	cnt := 0
+	for i := 0; i < s.usersPerRoom; i++ {
+		for _, i := range rand.Perm(len(chans)) {
+			c := chans[i]
+			if 0 < max && max <= cnt {
+				break
+			}
+			select {
+			case c <- struct{}{}:
+				cnt++
+			default:
+			}
+		}
+	}
+	s.users += cnt
+	return cnt
+}
+
+func (s *roomScenario) LevelUp() {
+	n := len(s.chans)
+	for i := 0; i < s.roomsPerLevel; i++ {
+		s.addRoom()
+	}
+	if s.newRoomOnAdd {
+		s.addRoomUser(s.chans[n:], -1)
+	} else {
+		s.addRoomUser(s.chans, -1)
+	}
+}
+
+func (s *roomScenario) AddUsers(max int) {
+	if !s.newRoomOnAdd {
+		s.addRoomUser(s.chans, max)
+		return
+	}
+
+	rooms := max / s.usersPerRoom
+	if max <= 0 {
+		rooms = s.roomsPerLevel
+	}
+	n := len(s.chans)
+	for i := 0; i < rooms; i++ {
+		if !s.addRoom() {
+			break
+		}
+	}
+	s.addRoomUser(s.chans[n:], max)
+}
+
+func (s *roomScenario) Teardown() {
+	s.wg.Wait()
+}
+
+func (s *roomScenario) Metrics() []string {
+	return []string{
+		fmt.Sprintf("Rooms:%v Users:%v Reconnect:%v", len(s.chans), s.users, clientReconnectTotal.Sum()),
+	}
+}
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"bench/counter"
+	"bench/metrics"
 	"context"
 	"encoding/csv"
 	"encoding/json"
@@ -27,17 +27,24 @@ import (
 )
 
 var (
-	benchDuration     = 1 * time.Minute
-	preTestTimeout    = 15 * time.Second
-	postTestTimeout   = 10 * time.Second
-	loadLogs          []string
-	loadLevel         int
-	noLevelup         bool
-	noCheckStaticFile bool
-	preTestOnly       bool
-	mItems            = map[int]mItem{}
-	itemIDs           []int
-	remoteAddrs       []string
+	benchDuration      = 1 * time.Minute
+	preTestTimeout     = 15 * time.Second
+	postTestTimeout    = 10 * time.Second
+	loadLogs           []string
+	loadLogsMtx        sync.Mutex
+	loadLevel          int32
+	noLevelup          bool
+	noCheckStaticFile  bool
+	preTestOnly        bool
+	checkProtocol      bool
+	mItems             = map[int]mItem{}
+	itemIDs            []int
+	remoteAddrs        []string
+	loadScenarioName   = "default"
+	recordPath         string
+	replayRequests     []requestRecord
+	loadControllerName = "heuristic"
+	agentAddrs         []string
 
 	pprofPort  = 16060
 	httpClient = http.Client{
@@ -45,12 +52,8 @@ var (
 	}
 )
 
-func getRemoteAddr() string {
-	return remoteAddrs[rand.Intn(len(remoteAddrs))]
-}
-
-func randomItem() mItem {
-	return mItems[itemIDs[rand.Intn(len(itemIDs))]]
+func randomItem(rnd *rand.Rand) mItem {
+	return mItems[itemIDs[rnd.Intn(len(itemIDs))]]
 }
 
 func randomCheapItem() mItem {
@@ -60,7 +63,6 @@ func randomCheapItem() mItem {
 func printMetrics() {
 	// room metrics
 	log.Println("- Metrics -")
-	m := counter.GetMap()
 	rooms := getRoomNameByTag("load")
 
 	type record struct {
@@ -70,16 +72,17 @@ func printMetrics() {
 
 	msgs := []record{}
 	for _, r := range rooms {
-		open := m["client-open|"+r]
-		closed := m["client-close|"+r]
-		active := open - closed
-		addok := m["client-addisu-ok|"+r]
-		addng := m["client-addisu-ng|"+r]
-		buyok := m["client-buyitem-ok|"+r]
-		buyng := m["client-buyitem-ng|"+r]
-
-		msg := fmt.Sprintf("%v Open:%v Close:%v Active:%v AddOK-NG:%v-%v BuyOK-NG:%v-%v",
-			r, open, closed, active, addok, addng, buyok, buyng)
+		open := clientOpenTotal.Sum(r)
+		closed := clientCloseTotal.Sum(r)
+		active := clientActive.Sum(r)
+		resume := clientResumeTotal.Sum(r, "ok")
+		addok := clientResponseTotal.Sum(r, "addIsu", "ok")
+		addng := clientResponseTotal.Sum(r, "addIsu", "ng")
+		buyok := clientResponseTotal.Sum(r, "buyItem", "ok")
+		buyng := clientResponseTotal.Sum(r, "buyItem", "ng")
+
+		msg := fmt.Sprintf("%v Open:%v Close:%v Active:%v Resume:%v AddOK-NG:%v-%v BuyOK-NG:%v-%v",
+			r, open, closed, active, resume, addok, addng, buyok, buyng)
 		msgs = append(msgs, record{key: open, msg: msg})
 	}
 
@@ -91,31 +94,93 @@ func printMetrics() {
 		log.Print(msg.msg)
 	}
 
-	for _, key := range []string{
-		"hash-bin-hit",
-		"hash-adding-hit",
-		"hash-schedule-hit",
-		"hash-items-hit",
-		"hash-onsale-hit",
-	} {
-		log.Println(key, counter.GetKey(key))
+	jsonCacheKinds := []string{"bin", "adding", "schedule", "items", "onsale"}
+	for _, kind := range jsonCacheKinds {
+		log.Println("hash-"+kind+"-hit", jsonCacheTotal.Sum(kind, "hit"))
+	}
+
+	latency := getLatencySummary()
+	keys := make([]string, 0, len(latency))
+	for key := range latency {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		l := latency[key]
+		log.Printf("%v count:%v p50:%.1fms p90:%.1fms p99:%.1fms max:%.1fms", key, l.Count, l.P50, l.P90, l.P99, l.Max)
+	}
+
+	printRemoteMetrics()
+
+	for _, fault := range chaosFaultNames {
+		if n := chaosInjectedTotal.Sum(fault); 0 < n {
+			log.Println("chaos-"+fault, n)
+		}
 	}
 
 	if StrictCheckCacheConflict {
-		for _, key := range []string{
-			"hash-bin-conflict",
-			"hash-adding-conflict",
-			"hash-schedule-conflict",
-			"hash-items-conflict",
-			"hash-onsale-conflict",
-		} {
-			log.Println(key, counter.GetKey(key))
+		for _, kind := range jsonCacheKinds {
+			log.Println("hash-"+kind+"-conflict", jsonCacheTotal.Sum(kind, "conflict"))
 		}
 	}
 }
 
+func appendLoadLog(msg string) {
+	loadLogsMtx.Lock()
+	loadLogs = append(loadLogs, msg)
+	loadLogsMtx.Unlock()
+	log.Println(msg)
+}
+
+// n 件目以降
+func getLoadLogsFrom(n int) []string {
+	loadLogsMtx.Lock()
+	defer loadLogsMtx.Unlock()
+	if len(loadLogs) <= n {
+		return nil
+	}
+	return append([]string{}, loadLogs[n:]...)
+}
+
+func getLoadLogs() []string {
+	return getLoadLogsFrom(0)
+}
+
+func summarizeLatency(h metrics.HistogramSnapshot) LatencySummary {
+	ms := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+	return LatencySummary{
+		Count: h.Count,
+		P50:   ms(h.Quantile(0.5)),
+		P90:   ms(h.Quantile(0.9)),
+		P99:   ms(h.Quantile(0.99)),
+		Max:   ms(h.Max),
+	}
+}
+
+func getLatencySummary() map[string]LatencySummary {
+	res := map[string]LatencySummary{}
+	for key, h := range latencySnapshots() {
+		res[key] = summarizeLatency(h)
+	}
+	return res
+}
+
 func resolveWsAddr(roomName string) (string, error) {
-	url := fmt.Sprintf("http://%v/room/%v", getRemoteAddr(), roomName)
+	remote := getRemoteAddr(roomName)
+	addr, err := resolveWsAddrAt(remote, roomName)
+	if err != nil {
+		remoteHTTPTotal.With(remote, "ng").Inc()
+		recordError(errCodeHTTP, err, roomName)
+	} else {
+		remoteHTTPTotal.With(remote, "ok").Inc()
+	}
+	return addr, err
+}
+
+func resolveWsAddrAt(remote, roomName string) (string, error) {
+	url := fmt.Sprintf("http://%v/room/%v", remote, escapeRoomName(roomName))
 	log.Println(url)
 	res, err := httpClient.Get(url)
 	if err != nil {
@@ -164,99 +229,81 @@ func requestInitialize(host string) error {
 	return nil
 }
 
-func benchmarkMain(ctx context.Context) {
-	var chans []chan struct{}
-
-	// ルームのユーザ数を増やす
-	// 各ルーム5人ずつ入れるが max人到達時点でやめる
-	addRoomUser := func(max int) {
-		cnt := 0
-		for i := 0; i < 5; i++ {
-			for _, i := range rand.Perm(len(chans)) {
-				c := chans[i]
-				if 0 < max && max <= cnt {
-					break
-				}
-				select {
-				case c <- struct{}{}:
-					cnt++
-				default:
-				}
-			}
-		}
+func benchmarkMain(ctx context.Context) error {
+	sc, err := newLoadScenario(loadScenarioName)
+	if err != nil {
+		return err
+	}
+
+	// 途中で抜けた場合も Teardown の前に負荷を止める
+	ctx, cancel := context.WithCancel(ctx)
+	err = sc.Setup(ctx)
+	if err != nil {
+		cancel()
+		return err
 	}
+	defer sc.Teardown()
+	defer cancel()
 
-	// ルームを新しく作る
-	addRoom := func() {
-		c1 := make(chan struct{}, 1000)
-		go RSLoadIikanji(ctx, c1)
-		chans = append(chans, c1)
+	ctrl, err := newLoadController(loadControllerName)
+	if err != nil {
+		return err
+	}
+	if rs, ok := sc.(rampScenario); ok {
+		if _, ok := rs.TargetLevel(0); ok {
+			ctrl = &rampController{scenario: rs}
+		}
 	}
 
-	addRoom()
-	addRoomUser(-1)
+	sc.LevelUp()
+	startTime := time.Now()
 
 	beat := time.NewTicker(time.Second)
 	defer beat.Stop()
 
-	maxActive := int64(0)
-	prevClose := int64(0)
-	prevActive := int64(0)
-	activeLimit := int64(-1)
-
 	for {
 		select {
 		case <-beat.C:
 			if getFormatError() != nil {
-				return
+				return nil
 			}
 
 			printMetrics()
+			for _, m := range sc.Metrics() {
+				log.Println(m)
+			}
 
 			if noLevelup {
 				continue
 			}
 
-			err := getRecentClientError()
-			hasRecentErr := err != nil && time.Since(err.t) < 5*time.Second
-
-			open := counter.SumPrefix("client-open|")
-			close := counter.SumPrefix("client-close|")
-			active := open - close
-			if maxActive < active {
-				maxActive = active
-			}
-
-			if 5 < prevActive && float64(prevActive)*0.2 < float64(close-prevClose) {
-				activeLimit = int64(float64(maxActive) * 0.6)
+			open := clientOpenTotal.Sum()
+			close := clientCloseTotal.Sum()
+			d := ctrl.Decide(loadMetrics{
+				Elapsed: time.Since(startTime),
+				Level:   int(atomic.LoadInt32(&loadLevel)),
+				Open:    open,
+				// シナリオが意図して切断したものは除く
+				Close:       close - clientReconnectTotal.Sum(),
+				Active:      open - close,
+				Requests:    clientRequestTotal.Value(),
+				Errors:      clientErrorTotal.Value(),
+				RecentError: getRecentClientError(),
+			})
+
+			if d.Log != "" {
+				msg := fmt.Sprintf("%v %v", time.Now().Format("01/02 15:04:05"), d.Log)
+				appendLoadLog(msg)
 			}
-
-			now := time.Now().Format("01/02 15:04:05")
-			log.Println("Active:", active, "Limit:", activeLimit)
-
-			if hasRecentErr {
-				log.Println("RecentError", err)
+			for i := 0; i < d.LevelUp; i++ {
+				loadLevelGauge.Set(int64(atomic.AddInt32(&loadLevel, 1)))
+				sc.LevelUp()
 			}
-			if !hasRecentErr && activeLimit == -1 {
-				msg := fmt.Sprintf("%v 接続数が増加します", now)
-				loadLogs = append(loadLogs, msg)
-
-				loadLevel++
-				addRoom()
-				addRoomUser(-1)
-				log.Println(msg)
-			} else if 0 < activeLimit && active < activeLimit {
-				msg := fmt.Sprintf("%v 接続数が増加します (上限:%v)", now, activeLimit)
-				loadLogs = append(loadLogs, msg)
-
-				addRoomUser(int(activeLimit - active))
-				log.Println(msg)
+			if 0 < d.AddUsers {
+				sc.AddUsers(d.AddUsers)
 			}
-
-			prevClose = close
-			prevActive = active
 		case <-ctx.Done():
-			return
+			return nil
 		}
 	}
 }
@@ -309,6 +356,13 @@ func preTest() error {
 		return err
 	}
 
+	if checkProtocol {
+		err = runProtocolChecks(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
 	for _, room := range getRoomNameByTag("preTest") {
 		err := ValidateGameLog(ctx, room, true)
 		if err != nil {
@@ -343,7 +397,7 @@ func postTest() error {
 				}()
 
 				err := ValidateGameLog(ctx, room, false)
-				if err != nil {
+				if err != nil && err != context.Canceled {
 					ret.Store(fmt.Errorf("Room %v にて %v", room, err))
 					cancel()
 					return
@@ -355,26 +409,33 @@ func postTest() error {
 
 	wg.Wait()
 
-	if ctx.Err() == context.DeadlineExceeded {
-		log.Println("Validation Timeout")
-	}
-
 	err := ret.Load()
 	if err != nil {
 		return err.(error)
 	}
+	// 検証していない status が残っている
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Println("Validation Timeout")
+		return fmt.Errorf("ゲームログの検証がタイムアウトしました")
+	}
 	return nil
 }
 
 func startBenchmark() *BenchResult {
 	result := new(BenchResult)
 	result.StartTime = time.Now()
+	clearBenchErrors()
+	clearValidationReport()
 	defer func() {
 		result.EndTime = time.Now()
+		result.Errors = getBenchErrors()
 	}()
 
+	defer setPhase("done")
+
+	setPhase("initialize")
 	log.Println("requestInitialize()")
-	err := requestInitialize(getRemoteAddr())
+	err := recordError(errCodeHTTP, requestInitialize(getRemoteAddr("/initialize")), "/initialize")
 	if err != nil {
 		result.Score = 0
 		result.Message = fmt.Sprint("/initialize へのリクエストに失敗しました。", err)
@@ -382,8 +443,10 @@ func startBenchmark() *BenchResult {
 	}
 	log.Println("requestInitialize() Done")
 
+	setPhase("preTest")
 	log.Println("preTest()")
 	err = preTest()
+	result.Checks = getProtocolCheckResults()
 	if getFormatError() != nil {
 		err = getFormatError()
 	}
@@ -404,23 +467,69 @@ func startBenchmark() *BenchResult {
 
 	// 負荷レベルを上げる条件に関わる為 PreTestのエラーを無視する
 	clearRecentClientError()
-	log.Println("benchmarkMain()")
-	benchmarkMain(ctx)
+	setPhase("load")
+	go recordTimeSeries(ctx)
+
+	// 検証に失敗したらその時点で負荷走行を止める
+	validateDone := make(chan struct{})
+	go func() {
+		defer close(validateDone)
+		runStreamValidation(ctx, func(err error) {
+			log.Println("負荷走行中の検証に失敗しました", err)
+			cancel()
+		})
+	}()
+
+	if recordPath != "" {
+		err = startRecorder(recordPath)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if replayRequests != nil {
+		log.Println("replayRecords()", len(replayRequests))
+		err = replayRecords(ctx, replayRequests)
+	} else if 0 < len(agentAddrs) {
+		log.Println("runAgents()", agentAddrs)
+		err = runAgents(ctx, agentAddrs)
+	} else {
+		log.Println("benchmarkMain()", loadScenarioName)
+		err = benchmarkMain(ctx)
+	}
+	if recordPath != "" {
+		if err := stopRecorder(); err != nil {
+			log.Println("stopRecorder", err)
+		} else {
+			log.Println("requests recorded to", recordPath)
+		}
+	}
+	cancel()
+	<-validateDone
+	if err != nil {
+		result.Score = 0
+		result.Message = fmt.Sprint("負荷走行の開始に失敗しました。", err)
+		return result
+	}
 	log.Println("benchmarkMain() Done")
 
 	// ベンチ終わった瞬間の値を取っておく
-	a := counter.GetKey("addisu-ok")
-	b := counter.GetKey("buyitem-ok")
-	log.Println(a, b)
-	result.Logs = loadLogs
+	scoreInput := readScoreInput()
+	log.Println(scoreInput.AddIsuOK, scoreInput.BuyItemOK)
+	result.Logs = getLoadLogs()
+	result.Latency = getLatencySummary()
+	result.TimeSeries = getTimeSeries()
 
 	err = getFormatError()
+	if err == nil {
+		err = getStreamValidationError()
+	}
 	if err != nil {
 		result.Score = 0
 		result.Message = fmt.Sprint("負荷走行中のバリデーションに失敗しました。", err)
 		return result
 	}
 
+	setPhase("postTest")
 	err = postTest()
 	if err != nil {
 		result.Score = 0
@@ -428,9 +537,12 @@ func startBenchmark() *BenchResult {
 		return result
 	}
 
-	result.Score = a + 10*b
+	breakdown := computeScore(scoreInput)
+	logScoreBreakdown(breakdown)
+	result.ScoreBreakdown = &breakdown
+	result.Score = breakdown.Total
 	result.Pass = true
-	result.LoadLevel = loadLevel
+	result.LoadLevel = int(atomic.LoadInt32(&loadLevel))
 	result.Message = "ok"
 	return result
 }
@@ -484,6 +596,14 @@ func main() {
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.Lshortfile)
 	log.SetPrefix("[isu7f-bench] ")
 
+	if 1 < len(os.Args) && os.Args[1] == "gamelog" {
+		err := runGameLogCommand(os.Args[2:])
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
 	var (
 		workermode   bool
 		portalUrl    string
@@ -501,6 +621,18 @@ func main() {
 		dumpgamelog  int
 		strictcache  bool
 		validatelog  string
+		conformance  string
+		scenario     string
+		seed         int64
+		record       string
+		replay       string
+		controller   string
+		score        string
+		agent        string
+		agents       string
+		balance      string
+		weights      string
+		faults       string
 	)
 
 	flag.BoolVar(&workermode, "workermode", false, "workermode")
@@ -510,6 +642,7 @@ func main() {
 	flag.StringVar(&dataPath, "data", "./data", "path to data directory")
 	flag.StringVar(&remotes, "remotes", "localhost:5000", "remote addrs to benchmark")
 	flag.BoolVar(&test, "test", false, "run pretest only")
+	flag.BoolVar(&checkProtocol, "protocol-check", false, "run extended websocket protocol checks in pretest")
 	flag.BoolVar(&nolevelup, "nolevelup", false, "dont increase load level")
 	flag.BoolVar(&nostaticfile, "nostaticfile", false, "dont check static file")
 	flag.StringVar(&output, "output", "", "path to write result json")
@@ -518,7 +651,37 @@ func main() {
 	flag.BoolVar(&memprofile, "memprof", false, "save mem profile into tmp direcotry")
 	flag.IntVar(&dumpgamelog, "dumpgamelog", 0, "save gamelog into tmp direcotry (1:if postTest failed 2:always)")
 	flag.BoolVar(&strictcache, "strictcache", false, "compare cached json strictly")
-	flag.StringVar(&validatelog, "validatelog", "", "path to gzipped gamelog to debug validation")
+	flag.StringVar(&validatelog, "validatelog", "", "path to gamelog dump to debug validation (json lines or gob)")
+	flag.StringVar(&conformance, "conformance", "", "remote addrs to compare with the first of -remotes")
+	flag.StringVar(&scenario, "scenario", "default", fmt.Sprintf("load scenario %v or path to scenario file (.yaml, .json)", loadScenarioNames()))
+	flag.Int64Var(&seed, "seed", 0, "random seed for room names and user actions (0: use current time)")
+	flag.StringVar(&record, "record", "", "path to write requests sent while benchmarking (json lines)")
+	flag.StringVar(&replay, "replay", "", "path to requests recorded by -record to send instead of the load scenario")
+	flag.StringVar(&controller, "controller", "heuristic", fmt.Sprintf("load level controller %v", loadControllerNames()))
+	flag.IntVar(&loadControl.Level, "load-level", loadControl.Level, "load level (-controller=fixed)")
+	flag.Float64Var(&loadControl.Rate, "load-rate", loadControl.Rate, "load levels per second (-controller=linear)")
+	flag.IntVar(&loadControl.StepSize, "load-step", loadControl.StepSize, "load levels per step (-controller=step)")
+	flag.DurationVar(&loadControl.StepInterval, "load-step-interval", loadControl.StepInterval, "interval between steps (-controller=step)")
+	flag.Float64Var(&loadControl.TargetErrorRate, "load-target-error", loadControl.TargetErrorRate, "target error rate (-controller=pid)")
+	flag.Float64Var(&loadControl.Kp, "load-kp", loadControl.Kp, "proportional gain (-controller=pid)")
+	flag.Float64Var(&loadControl.Ki, "load-ki", loadControl.Ki, "integral gain (-controller=pid)")
+	flag.Float64Var(&loadControl.Kd, "load-kd", loadControl.Kd, "derivative gain (-controller=pid)")
+	flag.IntVar(&loadControl.MaxLevelUpPerSec, "load-max-step", loadControl.MaxLevelUpPerSec, "max load levels per second (-controller=pid)")
+	flag.StringVar(&score, "score", scoreFormulaName, fmt.Sprintf("score formula %v", scoreFormulaNames()))
+	flag.Float64Var(&scoreParams.AddIsu, "score-addisu", scoreParams.AddIsu, "points per successful addIsu (-score=penalty,latency)")
+	flag.Float64Var(&scoreParams.BuyItem, "score-buyitem", scoreParams.BuyItem, "points per successful buyItem (-score=penalty,latency)")
+	flag.Float64Var(&scoreParams.ErrorPenalty, "score-error-penalty", scoreParams.ErrorPenalty, "points deducted per client error (-score=penalty,latency)")
+	flag.Float64Var(&scoreParams.TimeoutPenalty, "score-timeout-penalty", scoreParams.TimeoutPenalty, "points deducted per timeout (-score=penalty,latency)")
+	flag.StringVar(&scoreParams.LatencyKey, "score-latency-key", scoreParams.LatencyKey, "latency used for the bonus (-score=latency)")
+	flag.Float64Var(&scoreParams.LatencyTarget, "score-latency-target", scoreParams.LatencyTarget, "p99 latency in ms below which the bonus is given (-score=latency)")
+	flag.Float64Var(&scoreParams.LatencyBonus, "score-latency-bonus", scoreParams.LatencyBonus, "max bonus as a ratio of the score (-score=latency)")
+	flag.StringVar(&agent, "agent", "", "listen addr to run as an agent that generates load for a coordinator")
+	flag.StringVar(&agents, "agents", "", "agent addrs to generate load instead of this process")
+	flag.StringVar(&balance, "balance", remoteBalanceName, fmt.Sprintf("how to choose one of -remotes %v", remoteBalancerNames()))
+	flag.StringVar(&weights, "remote-weights", "", "weights of -remotes separated by comma (-balance=weighted)")
+	flag.IntVar(&ClientResumeRetry, "resume", ClientResumeRetry, "times to retry reconnecting to the same room when a load client is disconnected (0 = never)")
+	flag.Float64Var(&chaosRate, "chaos", 0, "probability to inject a fault into each request of load rooms")
+	flag.StringVar(&faults, "chaos-faults", strings.Join(chaosFaultNames, ","), "faults to inject (-chaos)")
 	flag.Parse()
 
 	loadMasterData(dataPath)
@@ -528,10 +691,17 @@ func main() {
 		return
 	}
 
+	metrics.RegisterHandlers(http.DefaultServeMux, metrics.Default)
+	registerStatusHandlers(http.DefaultServeMux)
 	go func() {
 		log.Println(http.ListenAndServe(fmt.Sprintf(":%d", pprofPort), nil))
 	}()
 
+	if agent != "" {
+		runAgentMode(agent)
+		return
+	}
+
 	if saveprofile {
 		defer profile.Start().Stop()
 	}
@@ -545,6 +715,43 @@ func main() {
 	saveGameLogDump = dumpgamelog
 	StrictCheckCacheConflict = strictcache
 	remoteAddrs = strings.Split(remotes, ",")
+	if w, err := parseRemoteWeights(weights); err != nil {
+		log.Fatalln(err)
+	} else {
+		remoteWeights = w
+	}
+	remoteBalanceName = balance
+	if err := setRemoteBalancer(remoteBalanceName, remoteWeights); err != nil {
+		log.Fatalln(err)
+	}
+	if f, err := parseChaosFaults(faults); err != nil {
+		log.Fatalln(err)
+	} else {
+		chaosFaults = f
+	}
+	loadScenarioName = scenario
+	loadControllerName = controller
+	scoreFormulaName = score
+	if _, err := newScoreFormula(score); err != nil {
+		log.Fatalln(err)
+	}
+	if _, err := newLoadController(controller); err != nil {
+		log.Fatalln(err)
+	}
+	if loadControl.StepInterval <= 0 {
+		log.Fatalln("-load-step-interval must be positive")
+	}
+	if isScenarioFile(scenario) {
+		sf, err := readScenarioFile(scenario)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if 0 < sf.Duration {
+			benchDuration = sf.Duration
+		}
+	} else if _, err := newLoadScenario(scenario); err != nil {
+		log.Fatalln(err)
+	}
 
 	if validatelog != "" {
 		err := ValidateGameLogDump(validatelog)
@@ -554,7 +761,41 @@ func main() {
 		return
 	}
 
-	rand.Seed(time.Now().UnixNano())
+	if conformance != "" {
+		err := runConformance(remoteAddrs[0], strings.Split(conformance, ","))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	log.Println("seed:", seed)
+	seedRand(seed)
+
+	agentAddrs = parseAgentAddrs(agents)
+	if 0 < len(agentAddrs) {
+		if record != "" || replay != "" {
+			log.Fatalln("Cannot use -record or -replay with -agents")
+		}
+		// エージェントからログが届くまでの遅れ
+		streamValidationLag = 2*agentPollInterval + agentClockSkew
+	}
+
+	recordPath = record
+	if replay != "" {
+		if 0 < chaosRate {
+			log.Fatalln("Cannot use -chaos with -replay")
+		}
+		records, err := readRecords(replay)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		replayRequests = records
+	}
+
 	benchResult := startBenchmark()
 	benchResult.IPAddrs = remotes
 	benchResult.JobID = jobid
@@ -571,5 +812,18 @@ func main() {
 			log.Fatalln(err)
 		}
 		log.Println("result json saved to ", output)
+
+		err = writeTimeSeries(output, benchResult.TimeSeries)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		if r := getValidationReport(); r != nil {
+			err = writeValidationReport(output, r)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			log.Println("validation report saved")
+		}
 	}
 }
@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Prometheus 形式で出力するバケツの上限
+var PrometheusBuckets = []time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2 * time.Second,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// JSON ではミリ秒で出力する
+func (h HistogramSnapshot) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Count int64   `json:"count"`
+		Sum   float64 `json:"sum_ms"`
+		P50   float64 `json:"p50_ms"`
+		P90   float64 `json:"p90_ms"`
+		P99   float64 `json:"p99_ms"`
+		Max   float64 `json:"max_ms"`
+	}{
+		Count: h.Count,
+		Sum:   ms(h.Sum),
+		P50:   ms(h.Quantile(0.5)),
+		P90:   ms(h.Quantile(0.9)),
+		P99:   ms(h.Quantile(0.99)),
+		Max:   ms(h.Max),
+	})
+}
+
+func labelString(s Sample, extra ...string) string {
+	keys := make([]string, 0, len(s.Labels))
+	for k := range s.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+strconv.Quote(s.Labels[k]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+"="+strconv.Quote(extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// 人が読むための形式. 1行1値
+func WriteText(w io.Writer, families []Family) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		for _, s := range f.Samples {
+			if s.Histogram != nil {
+				h := *s.Histogram
+				fmt.Fprintf(bw, "%v%v count=%v p50=%.3fms p90=%.3fms p99=%.3fms max=%.3fms\n",
+					f.Name, labelString(s), h.Count,
+					ms(h.Quantile(0.5)), ms(h.Quantile(0.9)), ms(h.Quantile(0.99)), ms(h.Max))
+				continue
+			}
+			fmt.Fprintf(bw, "%v%v %v\n", f.Name, labelString(s), s.Value)
+		}
+	}
+	return bw.Flush()
+}
+
+func WriteJSON(w io.Writer, families []Family) error {
+	return json.NewEncoder(w).Encode(families)
+}
+
+// Prometheus の text exposition format. 時間は秒で出力する
+func WritePrometheus(w io.Writer, families []Family) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		if f.Help != "" {
+			fmt.Fprintf(bw, "# HELP %v %v\n", f.Name, strings.Replace(f.Help, "\n", " ", -1))
+		}
+		fmt.Fprintf(bw, "# TYPE %v %v\n", f.Name, f.Type)
+		for _, s := range f.Samples {
+			if s.Histogram == nil {
+				fmt.Fprintf(bw, "%v%v %v\n", f.Name, labelString(s), s.Value)
+				continue
+			}
+			h := *s.Histogram
+			for _, b := range PrometheusBuckets {
+				le := strconv.FormatFloat(b.Seconds(), 'g', -1, 64)
+				fmt.Fprintf(bw, "%v_bucket%v %v\n", f.Name, labelString(s, "le", le), h.CountBelow(b))
+			}
+			fmt.Fprintf(bw, "%v_bucket%v %v\n", f.Name, labelString(s, "le", "+Inf"), h.Count)
+			fmt.Fprintf(bw, "%v_sum%v %v\n", f.Name, labelString(s), h.Sum.Seconds())
+			fmt.Fprintf(bw, "%v_count%v %v\n", f.Name, labelString(s), h.Count)
+		}
+	}
+	return bw.Flush()
+}
+
+// /metrics (Prometheus), /metrics.json, /metrics.txt を登録する
+func RegisterHandlers(mux *http.ServeMux, r *Registry) {
+	handle := func(contentType string, write func(io.Writer, []Family) error) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			write(w, r.Snapshot())
+		}
+	}
+	mux.Handle("/metrics", handle("text/plain; version=0.0.4; charset=utf-8", WritePrometheus))
+	mux.Handle("/metrics.json", handle("application/json", WriteJSON))
+	mux.Handle("/metrics.txt", handle("text/plain; charset=utf-8", WriteText))
+}
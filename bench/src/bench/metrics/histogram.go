@@ -0,0 +1,224 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// 時間の分布
+//
+// マイクロ秒単位で, 2倍毎の範囲を16分割したバケツに数える (誤差は最大 1/16 程度).
+// バケツは固定長で, 約 2^40 マイクロ秒 (12日) を超える値は最後のバケツに入れる.
+
+const (
+	histSub     = 16
+	histMaxExp  = 40
+	histBuckets = histSub*2 + (histMaxExp-4)*histSub
+)
+
+func histBucket(v int64) int {
+	if v < histSub*2 {
+		return int(v)
+	}
+	e := bits.Len64(uint64(v)) - 5
+	i := histSub*2 + (e-1)*histSub + int(v>>uint(e)) - histSub
+	if histBuckets <= i {
+		i = histBuckets - 1
+	}
+	return i
+}
+
+// バケツに入る値の上限
+func histUpper(i int) int64 {
+	if i < histSub*2 {
+		return int64(i)
+	}
+	e := uint((i-histSub*2)/histSub + 1)
+	sub := int64((i-histSub*2)%histSub + histSub)
+	return (sub+1)<<e - 1
+}
+
+type Histogram struct {
+	counts [histBuckets]int64
+	count  int64
+	sum    int64
+	max    int64
+}
+
+func (h *Histogram) Observe(d time.Duration) {
+	v := int64(d / time.Microsecond)
+	if v < 0 {
+		v = 0
+	}
+	atomic.AddInt64(&h.counts[histBucket(v)], 1)
+	atomic.AddInt64(&h.sum, v)
+	for {
+		max := atomic.LoadInt64(&h.max)
+		if v <= max || atomic.CompareAndSwapInt64(&h.max, max, v) {
+			break
+		}
+	}
+	// count は最後に増やすので, Snapshot で count よりバケツの合計が少なくなることは無い
+	atomic.AddInt64(&h.count, 1)
+}
+
+// Observe と同時に呼んでもよい. その場合 Count とバケツの合計は僅かにずれることがある
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	s := HistogramSnapshot{
+		Count: atomic.LoadInt64(&h.count),
+		Sum:   time.Duration(atomic.LoadInt64(&h.sum)) * time.Microsecond,
+		Max:   time.Duration(atomic.LoadInt64(&h.max)) * time.Microsecond,
+	}
+	last := -1
+	for i := range h.counts {
+		if atomic.LoadInt64(&h.counts[i]) != 0 {
+			last = i
+		}
+	}
+	if 0 <= last {
+		s.counts = make([]int64, last+1)
+		for i := range s.counts {
+			s.counts[i] = atomic.LoadInt64(&h.counts[i])
+		}
+	}
+	return s
+}
+
+// s の分を記録する. 別のプロセスで記録した分布を合わせるのに使う
+func (h *Histogram) Merge(s HistogramSnapshot) {
+	for i, c := range s.counts {
+		if i < histBuckets {
+			atomic.AddInt64(&h.counts[i], c)
+		}
+	}
+	atomic.AddInt64(&h.sum, int64(s.Sum/time.Microsecond))
+	v := int64(s.Max / time.Microsecond)
+	for {
+		max := atomic.LoadInt64(&h.max)
+		if v <= max || atomic.CompareAndSwapInt64(&h.max, max, v) {
+			break
+		}
+	}
+	atomic.AddInt64(&h.count, s.Count)
+}
+
+type HistogramSnapshot struct {
+	Count int64         `json:"count"`
+	Sum   time.Duration `json:"sum"`
+	Max   time.Duration `json:"max"`
+
+	counts []int64
+}
+
+// q (0 - 1) 分位点. 実際の値より少し大きい値を返す
+func (h HistogramSnapshot) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(h.Count)))
+	if rank < 1 {
+		rank = 1
+	}
+	var n int64
+	for i, c := range h.counts {
+		n += c
+		if rank <= n {
+			d := time.Duration(histUpper(i)) * time.Microsecond
+			if h.Max < d {
+				d = h.Max
+			}
+			return d
+		}
+	}
+	return h.Max
+}
+
+// d 以下の値の数. バケツの上限で判定するので実際より少し少ない
+func (h HistogramSnapshot) CountBelow(d time.Duration) int64 {
+	v := int64(d / time.Microsecond)
+	var n int64
+	for i, c := range h.counts {
+		if v < histUpper(i) {
+			break
+		}
+		n += c
+	}
+	return n
+}
+
+// prev 以降に記録された分だけの分布. Max はバケツの上限で近似する
+func (h HistogramSnapshot) Sub(prev HistogramSnapshot) HistogramSnapshot {
+	d := HistogramSnapshot{
+		Count:  h.Count - prev.Count,
+		Sum:    h.Sum - prev.Sum,
+		counts: append([]int64(nil), h.counts...),
+	}
+	for i, c := range prev.counts {
+		if i < len(d.counts) {
+			d.counts[i] -= c
+		}
+	}
+	for i := len(d.counts) - 1; 0 <= i; i-- {
+		if 0 < d.counts[i] {
+			d.Max = time.Duration(histUpper(i)) * time.Microsecond
+			if h.Max < d.Max {
+				d.Max = h.Max
+			}
+			break
+		}
+	}
+	return d
+}
+
+// 2つの分布を合わせたもの
+func (h HistogramSnapshot) Add(o HistogramSnapshot) HistogramSnapshot {
+	s := HistogramSnapshot{
+		Count: h.Count + o.Count,
+		Sum:   h.Sum + o.Sum,
+		Max:   h.Max,
+	}
+	if s.Max < o.Max {
+		s.Max = o.Max
+	}
+	n := len(h.counts)
+	if n < len(o.counts) {
+		n = len(o.counts)
+	}
+	if 0 < n {
+		s.counts = make([]int64, n)
+		for i, c := range h.counts {
+			s.counts[i] += c
+		}
+		for i, c := range o.counts {
+			s.counts[i] += c
+		}
+	}
+	return s
+}
+
+type histogramSnapshotGob struct {
+	Count    int64
+	Sum, Max time.Duration
+	Counts   []int64
+}
+
+// gob で送るときはバケツも含める
+func (h HistogramSnapshot) GobEncode() ([]byte, error) {
+	var b bytes.Buffer
+	err := gob.NewEncoder(&b).Encode(histogramSnapshotGob{h.Count, h.Sum, h.Max, h.counts})
+	return b.Bytes(), err
+}
+
+func (h *HistogramSnapshot) GobDecode(b []byte) error {
+	var x histogramSnapshotGob
+	err := gob.NewDecoder(bytes.NewReader(b)).Decode(&x)
+	if err != nil {
+		return err
+	}
+	h.Count, h.Sum, h.Max, h.counts = x.Count, x.Sum, x.Max, x.Counts
+	return nil
+}
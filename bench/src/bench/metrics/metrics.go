@@ -0,0 +1,331 @@
+// Package metrics はベンチマーカーの計測値を扱う。
+//
+// ラベル付きの Counter, Gauge, Histogram を提供する。値の更新は atomic 操作だけで行い、
+// ラベルの組み合わせ毎の値は sync.Map に保持するので、負荷走行中に多数の goroutine から更新してもロックを取らない。
+//
+//	var requests = metrics.NewCounterVec("requests_total", "リクエスト数", "room", "action")
+//	requests.With(room, "addIsu").Inc()
+//	requests.Sum("", "addIsu") // 全ルームの addIsu の合計
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type Type string
+
+const (
+	TypeCounter   Type = "counter"
+	TypeGauge     Type = "gauge"
+	TypeHistogram Type = "histogram"
+)
+
+// 単調増加する値
+type Counter struct {
+	v int64
+}
+
+func (c *Counter) Inc() {
+	atomic.AddInt64(&c.v, 1)
+}
+
+func (c *Counter) Add(n int64) {
+	if n < 0 {
+		panic("metrics: Counter.Add negative value")
+	}
+	atomic.AddInt64(&c.v, n)
+}
+
+func (c *Counter) Value() int64 {
+	return atomic.LoadInt64(&c.v)
+}
+
+// 増減する値
+type Gauge struct {
+	v int64
+}
+
+func (g *Gauge) Set(v int64) {
+	atomic.StoreInt64(&g.v, v)
+}
+
+func (g *Gauge) Add(n int64) {
+	atomic.AddInt64(&g.v, n)
+}
+
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+func (g *Gauge) Value() int64 {
+	return atomic.LoadInt64(&g.v)
+}
+
+// ラベルの値の組み合わせ毎の計測値
+type vec struct {
+	name   string
+	help   string
+	typ    Type
+	labels []string
+	newFn  func() interface{}
+
+	children sync.Map // string -> *child
+}
+
+type child struct {
+	values []string
+	metric interface{}
+}
+
+func (v *vec) with(values []string) interface{} {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %v expects %v labels, got %v", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	if c, ok := v.children.Load(key); ok {
+		return c.(*child).metric
+	}
+	c, _ := v.children.LoadOrStore(key, &child{
+		values: append([]string(nil), values...),
+		metric: v.newFn(),
+	})
+	return c.(*child).metric
+}
+
+// match はラベルの値. "" はどの値にも一致する. 省略したラベルも全てに一致する
+func (v *vec) each(match []string, f func(values []string, metric interface{})) {
+	if len(v.labels) < len(match) {
+		panic(fmt.Sprintf("metrics: %v has %v labels, got %v", v.name, len(v.labels), len(match)))
+	}
+	v.children.Range(func(_, x interface{}) bool {
+		c := x.(*child)
+		for i, m := range match {
+			if m != "" && m != c.values[i] {
+				return true
+			}
+		}
+		f(c.values, c.metric)
+		return true
+	})
+}
+
+func (v *vec) collect() []Sample {
+	var samples []Sample
+	v.each(nil, func(values []string, m interface{}) {
+		s := Sample{values: values}
+		if 0 < len(values) {
+			s.Labels = map[string]string{}
+			for i, l := range v.labels {
+				s.Labels[l] = values[i]
+			}
+		}
+		switch x := m.(type) {
+		case *Counter:
+			s.Value = x.Value()
+		case *Gauge:
+			s.Value = x.Value()
+		case *Histogram:
+			h := x.Snapshot()
+			s.Histogram = &h
+		}
+		samples = append(samples, s)
+	})
+	sort.Slice(samples, func(i, j int) bool {
+		a, b := samples[i].values, samples[j].values
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return false
+	})
+	return samples
+}
+
+type CounterVec struct {
+	vec
+}
+
+func (v *CounterVec) With(values ...string) *Counter {
+	return v.with(values).(*Counter)
+}
+
+// match に一致する値の合計
+func (v *CounterVec) Sum(match ...string) int64 {
+	var sum int64
+	v.each(match, func(_ []string, m interface{}) {
+		sum += m.(*Counter).Value()
+	})
+	return sum
+}
+
+// match に一致する値を全て呼ぶ
+func (v *CounterVec) Each(f func(values []string, c *Counter), match ...string) {
+	v.each(match, func(values []string, m interface{}) {
+		f(values, m.(*Counter))
+	})
+}
+
+type GaugeVec struct {
+	vec
+}
+
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return v.with(values).(*Gauge)
+}
+
+func (v *GaugeVec) Sum(match ...string) int64 {
+	var sum int64
+	v.each(match, func(_ []string, m interface{}) {
+		sum += m.(*Gauge).Value()
+	})
+	return sum
+}
+
+func (v *GaugeVec) Each(f func(values []string, g *Gauge), match ...string) {
+	v.each(match, func(values []string, m interface{}) {
+		f(values, m.(*Gauge))
+	})
+}
+
+type HistogramVec struct {
+	vec
+}
+
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return v.with(values).(*Histogram)
+}
+
+// match に一致する分布を合わせたもの
+func (v *HistogramVec) Merge(match ...string) HistogramSnapshot {
+	var s HistogramSnapshot
+	v.each(match, func(_ []string, m interface{}) {
+		s = s.Add(m.(*Histogram).Snapshot())
+	})
+	return s
+}
+
+func (v *HistogramVec) Each(f func(values []string, h *Histogram), match ...string) {
+	v.each(match, func(values []string, m interface{}) {
+		f(values, m.(*Histogram))
+	})
+}
+
+// 計測値の登録先
+type Registry struct {
+	mtx  sync.Mutex
+	vecs map[string]*vec
+}
+
+func NewRegistry() *Registry {
+	return &Registry{vecs: map[string]*vec{}}
+}
+
+// New* で作った計測値はここに登録される
+var Default = NewRegistry()
+
+func (r *Registry) register(v *vec) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if _, ok := r.vecs[v.name]; ok {
+		panic("metrics: duplicate metric " + v.name)
+	}
+	r.vecs[v.name] = v
+}
+
+func (v *vec) init(name, help string, typ Type, labels []string, newFn func() interface{}) {
+	v.name, v.help, v.typ, v.labels, v.newFn = name, help, typ, labels, newFn
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{}
+	v.init(name, help, TypeCounter, labels, func() interface{} { return new(Counter) })
+	r.register(&v.vec)
+	return v
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{}
+	v.init(name, help, TypeGauge, labels, func() interface{} { return new(Gauge) })
+	r.register(&v.vec)
+	return v
+}
+
+func (r *Registry) NewHistogramVec(name, help string, labels ...string) *HistogramVec {
+	v := &HistogramVec{}
+	v.init(name, help, TypeHistogram, labels, func() interface{} { return new(Histogram) })
+	r.register(&v.vec)
+	return v
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return Default.NewGaugeVec(name, help, labels...)
+}
+
+func NewHistogramVec(name, help string, labels ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, labels...)
+}
+
+// ラベルの無い計測値
+func NewCounter(name, help string) *Counter {
+	return NewCounterVec(name, help).With()
+}
+
+func NewGauge(name, help string) *Gauge {
+	return NewGaugeVec(name, help).With()
+}
+
+func NewHistogram(name, help string) *Histogram {
+	return NewHistogramVec(name, help).With()
+}
+
+// ある時点の計測値
+type Family struct {
+	Name    string   `json:"name"`
+	Help    string   `json:"help"`
+	Type    Type     `json:"type"`
+	Samples []Sample `json:"samples"`
+}
+
+type Sample struct {
+	Labels    map[string]string  `json:"labels,omitempty"`
+	Value     int64              `json:"value"`
+	Histogram *HistogramSnapshot `json:"histogram,omitempty"`
+
+	values []string
+}
+
+// 全ての計測値を名前順に返す
+func (r *Registry) Snapshot() []Family {
+	r.mtx.Lock()
+	vecs := make([]*vec, 0, len(r.vecs))
+	for _, v := range r.vecs {
+		vecs = append(vecs, v)
+	}
+	r.mtx.Unlock()
+
+	sort.Slice(vecs, func(i, j int) bool { return vecs[i].name < vecs[j].name })
+
+	families := make([]Family, 0, len(vecs))
+	for _, v := range vecs {
+		families = append(families, Family{
+			Name:    v.name,
+			Help:    v.help,
+			Type:    v.typ,
+			Samples: v.collect(),
+		})
+	}
+	return families
+}
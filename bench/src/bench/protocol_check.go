@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocket のプロトコルの細かい場合の検査
+//
+// -protocol-check を付けると preTest の最後に以下をそれぞれ別のルームで並列に行い,
+// 名前毎の結果を BenchResult.Checks に出力する. 1つでも失敗したら preTest の失敗とする.
+// 各ルームのゲームログは preTest と同じく厳密に検証する.
+var protocolChecks = []protocolCheck{
+	{"empty-room-name", "空の部屋名のルームに接続して addIsu できる", checkEmptyRoomName},
+	{"unicode-room-name", "日本語や絵文字を含む部屋名の2つの接続が同じルームになる", checkUnicodeRoomName},
+	{"past-time", "過去の time の addIsu, buyItem が失敗する", checkPastTime},
+	{"count-bought-conflict", "2つのクライアントが同じ count_bought で buyItem すると1つだけ成功する", checkCountBoughtConflict},
+	{"huge-isu", "数千桁の isu の addIsu が反映される", checkHugeIsu},
+	{"interleaved-requests", "応答を待たずに送った複数の request にそれぞれ応答する", checkInterleavedRequests},
+	{"status-before-response", "成功した request の GameResponse より前に反映済みの GameStatus を送る", checkStatusBeforeResponse},
+}
+
+// huge-isu の isu の桁数
+const protocolHugeIsuDigits = 5000
+
+type protocolCheck struct {
+	Name        string
+	Description string
+	Run         func(ctx context.Context) error
+}
+
+var protocolCheckResults struct {
+	mtx     sync.Mutex
+	results []CheckResult
+}
+
+func getProtocolCheckResults() []CheckResult {
+	protocolCheckResults.mtx.Lock()
+	defer protocolCheckResults.mtx.Unlock()
+
+	return protocolCheckResults.results
+}
+
+// 失敗した検査の名前を並べたエラーを返す
+func runProtocolChecks(ctx context.Context) error {
+	results := make([]CheckResult, len(protocolChecks))
+
+	var wg sync.WaitGroup
+	for i, pc := range protocolChecks {
+		wg.Add(1)
+		go func(i int, pc protocolCheck) {
+			defer wg.Done()
+
+			start := time.Now()
+			err := pc.Run(ctx)
+			results[i] = CheckResult{
+				Name:        pc.Name,
+				Description: pc.Description,
+				Pass:        err == nil,
+				Elapsed:     time.Since(start).Seconds(),
+			}
+			if err != nil {
+				results[i].Message = err.Error()
+			}
+		}(i, pc)
+	}
+	wg.Wait()
+
+	protocolCheckResults.mtx.Lock()
+	protocolCheckResults.results = results
+	protocolCheckResults.mtx.Unlock()
+
+	var ng []string
+	for _, r := range results {
+		if r.Pass {
+			log.Printf("[OK] %v", r.Name)
+		} else {
+			log.Printf("[NG] %v: %v", r.Name, r.Message)
+			ng = append(ng, r.Name)
+		}
+	}
+	if 0 < len(ng) {
+		return fmt.Errorf("プロトコルの検査 %v に失敗しました", strings.Join(ng, ", "))
+	}
+	return nil
+}
+
+// /room/ に渡す部屋名. 日本語なども使えるように
+func escapeRoomName(roomName string) string {
+	return url.PathEscape(roomName)
+}
+
+func startProtocolClients(ctx context.Context, roomName string, n int) ([]*client, error) {
+	wsAddr, err := resolveWsAddr(roomName)
+	if err != nil {
+		return nil, err
+	}
+
+	var clients []*client
+	for i := 0; i < n; i++ {
+		c := new(client)
+		err := c.Start(ctx, roomName, wsAddr)
+		if err != nil {
+			for _, c := range clients {
+				c.Close()
+			}
+			return nil, fmt.Errorf("Room %v の接続に失敗しました. %v", roomName, err)
+		}
+		clients = append(clients, c)
+	}
+	return clients, nil
+}
+
+func closeProtocolClients(clients []*client) {
+	for _, c := range clients {
+		c.Close()
+	}
+}
+
+// status の currentTime が t を過ぎるまで待つ
+func waitCurrentTime(ctx context.Context, c *client, t int64) error {
+	c.WaitUntil(ctx, t)
+	for i := 0; i < 30; i++ {
+		if t < c.GetStatus().Schedule[0].Time {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("Room %v にて time = %v を過ぎた GameStatus を受信しませんでした", c.roomName, t)
+}
+
+// addIsu して反映されるまで待つ
+func addIsuAndWait(ctx context.Context, c *client, isu string) error {
+	addt := c.AfterDefault()
+	_, res, err := c.AddIsu(isu, addt)
+	if err != nil {
+		return fmt.Errorf("Room %v にて addIsu のリクエストに失敗しました. %v", c.roomName, err)
+	}
+	if !res.IsSuccess {
+		return recordError(errCodeResponse, fmt.Errorf("Room %v にて addIsu が成功しませんでした. request_id = %v", c.roomName, res.RequestID), c.roomName)
+	}
+	return waitCurrentTime(ctx, c, addt)
+}
+
+func checkEmptyRoomName(ctx context.Context) error {
+	roomName := ""
+	registerRoomName(roomName, "protocol")
+
+	clients, err := startProtocolClients(ctx, roomName, 1)
+	if err != nil {
+		return err
+	}
+	defer closeProtocolClients(clients)
+
+	err = addIsuAndWait(ctx, clients[0], "1")
+	if err != nil {
+		return err
+	}
+	return ValidateGameLog(ctx, roomName, true)
+}
+
+func checkUnicodeRoomName(ctx context.Context) error {
+	roomName := genRandomRoomName("protocol") + "椅子🪑 部屋"
+	registerRoomName(roomName, "protocol")
+
+	clients, err := startProtocolClients(ctx, roomName, 2)
+	if err != nil {
+		return err
+	}
+	defer closeProtocolClients(clients)
+
+	// 1つ目の接続で addIsu したものが2つ目の接続の adding に見える
+	c1, c2 := clients[0], clients[1]
+	addt := c1.After(999)
+	_, res, err := c1.AddIsu("12345", addt)
+	if err != nil {
+		return fmt.Errorf("Room %v にて addIsu のリクエストに失敗しました. %v", roomName, err)
+	}
+	if !res.IsSuccess {
+		return recordError(errCodeResponse, fmt.Errorf("Room %v にて addIsu が成功しませんでした. request_id = %v", roomName, res.RequestID), roomName)
+	}
+
+	found := false
+	for i := 0; i < 30 && !found; i++ {
+		for _, x := range c2.GetStatus().Adding {
+			found = found || x.Time == addt
+		}
+		if !found {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	if !found {
+		return recordError(errCodeResponse, fmt.Errorf("Room %v にて 別の接続の addIsu (time = %v) が adding にありません", roomName, addt), roomName)
+	}
+
+	err = waitCurrentTime(ctx, c2, addt)
+	if err != nil {
+		return err
+	}
+	return ValidateGameLog(ctx, roomName, true)
+}
+
+func checkPastTime(ctx context.Context) error {
+	roomName := genRandomRoomName("protocol")
+	clients, err := startProtocolClients(ctx, roomName, 1)
+	if err != nil {
+		return err
+	}
+	defer closeProtocolClients(clients)
+	c := clients[0]
+
+	// isu が足りないから失敗するのではないことを確かめるため, 先に買えるだけ作っておく
+	mitem := mItems[1]
+	err = addIsuAndWait(ctx, c, fmt.Sprint(mitem.GetPrice(1)))
+	if err != nil {
+		return err
+	}
+	// 過去の time でも addIsu が反映された後になるように
+	c.Wait(ctx, 500)
+
+	_, res, err := c.AddIsu("1", c.After(-200))
+	if err != nil {
+		return fmt.Errorf("Room %v にて addIsu のリクエストに失敗しました. %v", roomName, err)
+	}
+	if res.IsSuccess {
+		return recordError(errCodeResponse, fmt.Errorf("Room %v にて 過去に対する addIsu が成功しました. request_id = %v", roomName, res.RequestID), roomName)
+	}
+
+	_, res, err = c.BuyItem(mitem.ItemID, 0, c.After(-200))
+	if err != nil {
+		return fmt.Errorf("Room %v にて buyItem のリクエストに失敗しました. %v", roomName, err)
+	}
+	if res.IsSuccess {
+		return recordError(errCodeResponse, fmt.Errorf("Room %v にて 過去に対する buyItem が成功しました. request_id = %v", roomName, res.RequestID), roomName)
+	}
+
+	return ValidateGameLog(ctx, roomName, true)
+}
+
+func checkCountBoughtConflict(ctx context.Context) error {
+	roomName := genRandomRoomName("protocol")
+	clients, err := startProtocolClients(ctx, roomName, 2)
+	if err != nil {
+		return err
+	}
+	defer closeProtocolClients(clients)
+	c1, c2 := clients[0], clients[1]
+
+	// 2つとも買えるだけ作っておく
+	mitem := mItems[1]
+	isu := new(big.Int).Add(mitem.GetPrice(1), mitem.GetPrice(2))
+	err = addIsuAndWait(ctx, c1, isu.String())
+	if err != nil {
+		return err
+	}
+
+	buyt := c1.AfterDefault()
+	var (
+		wg      sync.WaitGroup
+		success [2]bool
+		errs    [2]error
+	)
+	for i, c := range []*client{c1, c2} {
+		wg.Add(1)
+		go func(i int, c *client) {
+			defer wg.Done()
+			_, res, err := c.BuyItem(mitem.ItemID, 0, buyt)
+			success[i], errs[i] = res.IsSuccess, err
+		}(i, c)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return fmt.Errorf("Room %v にて buyItem のリクエストに失敗しました. %v", roomName, err)
+		}
+	}
+	if success[0] && success[1] {
+		return recordError(errCodeResponse, fmt.Errorf("Room %v にて count_bought = 0 の buyItem が2つとも成功しました", roomName), roomName)
+	}
+	if !success[0] && !success[1] {
+		return recordError(errCodeResponse, fmt.Errorf("Room %v にて count_bought = 0 の buyItem がどちらも成功しませんでした", roomName), roomName)
+	}
+
+	err = waitCurrentTime(ctx, c1, buyt)
+	if err != nil {
+		return err
+	}
+	return ValidateGameLog(ctx, roomName, true)
+}
+
+func checkHugeIsu(ctx context.Context) error {
+	roomName := genRandomRoomName("protocol")
+	clients, err := startProtocolClients(ctx, roomName, 1)
+	if err != nil {
+		return err
+	}
+	defer closeProtocolClients(clients)
+
+	isu := "9" + strings.Repeat("0123456789", protocolHugeIsuDigits/10)[1:]
+	err = addIsuAndWait(ctx, clients[0], isu)
+	if err != nil {
+		return err
+	}
+	return ValidateGameLog(ctx, roomName, true)
+}
+
+func checkInterleavedRequests(ctx context.Context) error {
+	roomName := genRandomRoomName("protocol")
+	clients, err := startProtocolClients(ctx, roomName, 1)
+	if err != nil {
+		return err
+	}
+	defer closeProtocolClients(clients)
+	c := clients[0]
+
+	// 同じ接続から応答を待たずに送る. client は request_id で応答を対応させる
+	const n = 5
+	base := c.AfterDefault()
+	var (
+		wg   sync.WaitGroup
+		errs [n]error
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, res, err := c.AddIsu(fmt.Sprint(i+1), base+int64(i)*10)
+			if err != nil {
+				errs[i] = fmt.Errorf("Room %v にて addIsu のリクエストに失敗しました. %v", roomName, err)
+			} else if !res.IsSuccess {
+				errs[i] = recordError(errCodeResponse, fmt.Errorf("Room %v にて addIsu が成功しませんでした. request_id = %v", roomName, res.RequestID), roomName)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	err = waitCurrentTime(ctx, c, base+(n-1)*10)
+	if err != nil {
+		return err
+	}
+	return ValidateGameLog(ctx, roomName, true)
+}
+
+func checkStatusBeforeResponse(ctx context.Context) error {
+	roomName := genRandomRoomName("protocol")
+	clients, err := startProtocolClients(ctx, roomName, 1)
+	if err != nil {
+		return err
+	}
+	defer closeProtocolClients(clients)
+	c := clients[0]
+
+	addt := c.After(999)
+	req, res, err := c.AddIsu("123", addt)
+	if err != nil {
+		return fmt.Errorf("Room %v にて addIsu のリクエストに失敗しました. %v", roomName, err)
+	}
+	if !res.IsSuccess {
+		return recordError(errCodeResponse, fmt.Errorf("Room %v にて addIsu が成功しませんでした. request_id = %v", roomName, res.RequestID), roomName)
+	}
+
+	// request を送ってから response を受信するまでに, この接続で受信した status
+	g := getGameLogger(roomName)
+	var (
+		status  []*GameStatusLog
+		reqTime time.Time
+		resTime time.Time
+		logs    int
+	)
+	g.mtx.Lock()
+	for _, x := range g.status {
+		if x.ClientID == c.id {
+			status = append(status, x)
+		}
+	}
+	for _, x := range g.request {
+		if x.RequestID == req.RequestID {
+			reqTime = x.ClientTime
+			logs++
+		}
+	}
+	for _, x := range g.response {
+		if x.RequestID == req.RequestID {
+			resTime = x.ClientTime
+			logs++
+		}
+	}
+	g.mtx.Unlock()
+	if logs != 2 {
+		return fmt.Errorf("Room %v にて request_id = %v のゲームログがありません", roomName, req.RequestID)
+	}
+
+	st := getLatestStatus(status, reqTime, resTime)
+	if st == nil {
+		return recordError(errCodeResponse, fmt.Errorf("Room %v にて is_success = true の GameResponse (request_id = %v) より前に GameStatus を受信しませんでした", roomName, req.RequestID), roomName)
+	}
+	err = validateAddIsu(req.RequestID, req.Time, req.Isu, st)
+	if err != nil {
+		return recordError(errCodeResponse, fmt.Errorf("Room %v にて GameResponse より前の GameStatus に %v", roomName, err), roomName)
+	}
+
+	err = waitCurrentTime(ctx, c, addt)
+	if err != nil {
+		return err
+	}
+	return ValidateGameLog(ctx, roomName, true)
+}
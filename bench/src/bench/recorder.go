@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 負荷走行中に送ったリクエストの記録
+//
+// 1行1イベントの JSON で書き出す. 時刻は記録開始からのミリ秒.
+// 別の webapp に対して同じタイミングで同じリクエストを送り直す (replay) のに使う.
+type requestRecord struct {
+	At     int64  `json:"at"`
+	Event  string `json:"event"` // open, request, close
+	Room   string `json:"room"`
+	Client int    `json:"client"`
+
+	// request のみ. Request.Time は記録時のサーバの時刻なので, 再生時は Lead を使う
+	Request *GameRequest `json:"request,omitempty"`
+	Lead    int64        `json:"lead,omitempty"`
+}
+
+var recorder struct {
+	enabled int32
+
+	mtx   sync.Mutex
+	file  *os.File
+	w     *bufio.Writer
+	enc   *json.Encoder
+	start time.Time
+}
+
+func startRecorder(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	recorder.mtx.Lock()
+	recorder.file = f
+	recorder.w = bufio.NewWriter(f)
+	recorder.enc = json.NewEncoder(recorder.w)
+	recorder.start = time.Now()
+	recorder.mtx.Unlock()
+
+	atomic.StoreInt32(&recorder.enabled, 1)
+	return nil
+}
+
+func stopRecorder() error {
+	if atomic.SwapInt32(&recorder.enabled, 0) == 0 {
+		return nil
+	}
+
+	recorder.mtx.Lock()
+	defer recorder.mtx.Unlock()
+
+	recorder.enc = nil
+	err := recorder.w.Flush()
+	if err != nil {
+		recorder.file.Close()
+		return err
+	}
+	return recorder.file.Close()
+}
+
+func recordEvent(event string, c *client, req *GameRequest) {
+	if atomic.LoadInt32(&recorder.enabled) == 0 {
+		return
+	}
+
+	r := requestRecord{
+		Event:   event,
+		Room:    c.roomName,
+		Client:  c.id,
+		Request: req,
+	}
+	if req != nil {
+		r.Lead = req.Time - c.Now()
+	}
+
+	recorder.mtx.Lock()
+	defer recorder.mtx.Unlock()
+
+	if recorder.enc == nil {
+		return
+	}
+	r.At = time.Since(recorder.start).Nanoseconds() / 1000000
+	err := recorder.enc.Encode(&r)
+	if err != nil {
+		log.Println("recordEvent", err)
+	}
+}
+
+func readRecords(path string) ([]requestRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []requestRecord
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for dec.More() {
+		var r requestRecord
+		err := dec.Decode(&r)
+		if err != nil {
+			return nil, fmt.Errorf("%v の %v 行目: %v", path, len(records)+1, err)
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// 記録したリクエストを記録時と同じタイミングで送り直す. 負荷走行 (benchmarkMain) の代わりに使う.
+// 接続はクライアント毎に作り直し, ルーム名は記録時のものを使う.
+func replayRecords(ctx context.Context, records []requestRecord) error {
+	if len(records) == 0 {
+		return fmt.Errorf("再生するリクエストがありません")
+	}
+
+	clients := map[int][]requestRecord{}
+	var order []int
+	for _, r := range records {
+		if _, ok := clients[r.Client]; !ok {
+			order = append(order, r.Client)
+		}
+		clients[r.Client] = append(clients[r.Client], r)
+	}
+
+	var (
+		wsAddrMtx sync.Mutex
+		wsAddrs   = map[string]string{}
+	)
+	resolve := func(room string) (string, error) {
+		wsAddrMtx.Lock()
+		defer wsAddrMtx.Unlock()
+
+		if addr, ok := wsAddrs[room]; ok {
+			return addr, nil
+		}
+		registerRoomName(room, "load")
+		addr, err := resolveWsAddr(room)
+		if err != nil {
+			return "", err
+		}
+		wsAddrs[room] = addr
+		return addr, nil
+	}
+
+	start := time.Now()
+	waitUntil := func(at int64) bool {
+		d := time.Duration(at)*time.Millisecond - time.Since(start)
+		if d <= 0 {
+			return ctx.Err() == nil
+		}
+		select {
+		case <-time.After(d):
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, id := range order {
+		events := clients[id]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var c *client
+			defer func() {
+				if c != nil {
+					c.Close()
+				}
+			}()
+
+			for _, r := range events {
+				if !waitUntil(r.At) {
+					return
+				}
+
+				switch r.Event {
+				case "open":
+					wsAddr, err := resolve(r.Room)
+					if err != nil {
+						onError(err, r)
+						return
+					}
+					c = new(client)
+					err = c.Start(ctx, r.Room, wsAddr)
+					if err != nil {
+						c = nil
+						return
+					}
+				case "request":
+					if c == nil || r.Request == nil {
+						continue
+					}
+					var err error
+					t := c.After(r.Lead)
+					switch r.Request.Action {
+					case "addIsu":
+						_, _, err = c.AddIsu(r.Request.Isu, t)
+					case "buyItem":
+						_, _, err = c.BuyItem(r.Request.ItemID, r.Request.CountBought, t)
+					}
+					if err != nil && err != errConnectionResumed {
+						return
+					}
+				case "close":
+					if c != nil {
+						c.Close()
+						c = nil
+					}
+				}
+			}
+		}()
+	}
+
+	// 全て送り終えるか ctx が終わるまで待つ
+	wg.Wait()
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bench/metrics"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// 負荷をかける先 (-remotes) の選び方
+//
+// /initialize, トップページ, 静的ファイル, /room の度に getRemoteAddr が RemoteBalancer で remote を選ぶ.
+// key はルーム名やパスで, sticky は同じ key を必ず同じ remote に送る.
+// websocket は /room が返した host に接続するので, remote 毎の計測値はその host 毎に数える.
+type RemoteBalancer interface {
+	Pick(key string) string
+}
+
+// フラグで指定するパラメータ
+var (
+	remoteBalanceName = "random"
+	remoteWeights     []int
+)
+
+var remoteBalancers = map[string]func(addrs []string, weights []int) RemoteBalancer{
+	// 毎回ランダムに選ぶ (従来の動作)
+	"random": func(addrs []string, _ []int) RemoteBalancer {
+		return &weightedBalancer{addrs: addrs}
+	},
+	// 順番に選ぶ
+	"roundrobin": func(addrs []string, _ []int) RemoteBalancer {
+		return &roundRobinBalancer{addrs: addrs}
+	},
+	// key のハッシュで選ぶ. 同じルームの /room は同じ remote に送る
+	"sticky": func(addrs []string, _ []int) RemoteBalancer {
+		return &stickyBalancer{addrs: addrs}
+	},
+	// -remote-weights の重みでランダムに選ぶ
+	"weighted": func(addrs []string, weights []int) RemoteBalancer {
+		return &weightedBalancer{addrs: addrs, weights: weights}
+	},
+}
+
+func remoteBalancerNames() []string {
+	var names []string
+	for name := range remoteBalancers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newRemoteBalancer(name string, addrs []string, weights []int) (RemoteBalancer, error) {
+	f, ok := remoteBalancers[name]
+	if !ok {
+		return nil, fmt.Errorf("remote の選び方 %v は存在しません %v", name, remoteBalancerNames())
+	}
+	if name == "weighted" {
+		if len(weights) != len(addrs) {
+			return nil, fmt.Errorf("-remote-weights の数 (%v) が -remotes の数 (%v) と一致しません", len(weights), len(addrs))
+		}
+		total := 0
+		for _, w := range weights {
+			if w < 0 {
+				return nil, fmt.Errorf("-remote-weights に負の重みがあります")
+			}
+			total += w
+		}
+		if total == 0 {
+			return nil, fmt.Errorf("-remote-weights の合計が 0 です")
+		}
+	}
+	return f(addrs, weights), nil
+}
+
+// 1,2,3 のような重み
+func parseRemoteWeights(s string) ([]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var weights []int
+	for _, x := range strings.Split(s, ",") {
+		w, err := strconv.Atoi(strings.TrimSpace(x))
+		if err != nil {
+			return nil, fmt.Errorf("-remote-weights が正しくありません: %v", err)
+		}
+		weights = append(weights, w)
+	}
+	return weights, nil
+}
+
+var remoteBalancer RemoteBalancer
+
+func getRemoteAddr(key string) string {
+	if remoteBalancer == nil {
+		return remoteAddrs[rand.Intn(len(remoteAddrs))]
+	}
+	return remoteBalancer.Pick(key)
+}
+
+func setRemoteBalancer(name string, weights []int) error {
+	b, err := newRemoteBalancer(name, remoteAddrs, weights)
+	if err != nil {
+		return err
+	}
+	remoteBalancer = b
+	return nil
+}
+
+type weightedBalancer struct {
+	addrs   []string
+	weights []int // nil なら同じ重み
+}
+
+func (b *weightedBalancer) Pick(_ string) string {
+	if b.weights == nil {
+		return b.addrs[rand.Intn(len(b.addrs))]
+	}
+	total := 0
+	for _, w := range b.weights {
+		total += w
+	}
+	x := rand.Intn(total)
+	for i, w := range b.weights {
+		if x < w {
+			return b.addrs[i]
+		}
+		x -= w
+	}
+	return b.addrs[len(b.addrs)-1]
+}
+
+type roundRobinBalancer struct {
+	addrs []string
+	n     uint64
+}
+
+func (b *roundRobinBalancer) Pick(_ string) string {
+	i := atomic.AddUint64(&b.n, 1) - 1
+	return b.addrs[i%uint64(len(b.addrs))]
+}
+
+type stickyBalancer struct {
+	addrs []string
+}
+
+func (b *stickyBalancer) Pick(key string) string {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return b.addrs[h.Sum32()%uint32(len(b.addrs))]
+}
+
+// websocket のアドレスの host. 計測値の remote に使う
+func wsRemote(wsAddr string) string {
+	u, err := url.Parse(wsAddr)
+	if err != nil || u.Host == "" {
+		return wsAddr
+	}
+	return u.Host
+}
+
+// remote 毎の接続数, エラー数, 処理時間
+func printRemoteMetrics() {
+	seen := map[string]bool{}
+	var remotes []string
+	add := func(values []string) {
+		if !seen[values[0]] {
+			seen[values[0]] = true
+			remotes = append(remotes, values[0])
+		}
+	}
+	remoteOpenTotal.Each(func(values []string, _ *metrics.Counter) { add(values) })
+	remoteErrorTotal.Each(func(values []string, _ *metrics.Counter) { add(values) })
+	remoteHTTPTotal.Each(func(values []string, _ *metrics.Counter) { add(values) })
+	sort.Strings(remotes)
+
+	for _, r := range remotes {
+		l := summarizeLatency(latencyRemote.Merge(r))
+		log.Printf("remote %v Open:%v Active:%v Errors:%v RoomOK-NG:%v-%v p50:%.1fms p99:%.1fms",
+			r, remoteOpenTotal.Sum(r), remoteActive.Sum(r), remoteErrorTotal.Sum(r),
+			remoteHTTPTotal.Sum(r, "ok"), remoteHTTPTotal.Sum(r, "ng"), l.P50, l.P99)
+	}
+}
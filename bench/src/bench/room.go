@@ -8,12 +8,17 @@ import (
 
 var genDebugRoomName = false
 
+// -debugname のルーム名の前に付ける. エージェント毎にルーム名が重ならないようにする
+var debugRoomNamePrefix = ""
+
 var genRoom = struct {
 	mtx  sync.Mutex
 	cnt  int
 	tags map[string]string
+	rnd  *rand.Rand
 }{
 	tags: map[string]string{},
+	rnd:  rand.New(rand.NewSource(1)),
 }
 
 func genRandomRoomName(tag string) string {
@@ -23,14 +28,14 @@ func genRandomRoomName(tag string) string {
 	genRoom.cnt++
 
 	if genDebugRoomName {
-		name := fmt.Sprint("bench", genRoom.cnt)
+		name := fmt.Sprint(debugRoomNamePrefix, "bench", genRoom.cnt)
 		genRoom.tags[name] = tag
 		return name
 	} else {
 		letterRunes := []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
 		b := make([]rune, 20)
 		for i := range b {
-			b[i] = letterRunes[rand.Intn(len(letterRunes))]
+			b[i] = letterRunes[genRoom.rnd.Intn(len(letterRunes))]
 		}
 		name := "ROOM" + string(b)
 		genRoom.tags[name] = tag
@@ -38,6 +43,15 @@ func genRandomRoomName(tag string) string {
 	}
 }
 
+// 既に決まっているルーム名を登録する
+func registerRoomName(name, tag string) {
+	genRoom.mtx.Lock()
+	defer genRoom.mtx.Unlock()
+
+	genRoom.cnt++
+	genRoom.tags[name] = tag
+}
+
 func getRoomNameByTag(tag string) []string {
 	genRoom.mtx.Lock()
 	defer genRoom.mtx.Unlock()
@@ -50,3 +64,10 @@ func getRoomNameByTag(tag string) []string {
 	}
 	return res
 }
+
+func getRoomTag(name string) string {
+	genRoom.mtx.Lock()
+	defer genRoom.mtx.Unlock()
+
+	return genRoom.tags[name]
+}
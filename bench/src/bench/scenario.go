@@ -20,8 +20,8 @@ import (
 // load scenario
 
 // 乱数個の椅子をaddIsuし続ける
-func LoadAddIsu(ctx context.Context, room, wsAddr string) error {
-	c := new(client)
+func LoadAddIsu(ctx context.Context, room, wsAddr string, rnd *rand.Rand) error {
+	c := &client{rnd: rnd}
 	err := c.Start(ctx, room, wsAddr)
 	if err != nil {
 		return err
@@ -29,27 +29,28 @@ func LoadAddIsu(ctx context.Context, room, wsAddr string) error {
 	defer c.Close()
 
 	for {
-		s := genRandomNumberString(rand.Intn(50) + 1)
+		s := genRandomNumberString(rnd, rnd.Intn(50)+1)
 		_, _, err := c.AddIsu(s, c.AfterDefault())
-		if err != nil {
+		if err != nil && err != errConnectionResumed {
 			return err
 		}
 
 		// ユーザ数が増えることにメリットを与えるため, 1ユーザで頑張りすぎない
-		time.Sleep(time.Millisecond * time.Duration(50+rand.Intn(10)))
+		time.Sleep(time.Millisecond * time.Duration(50+rnd.Intn(10)))
 	}
 }
 
 // 購入可能なアイテムIDの一番大きいアイテムを買う
-func LoadBuyItem(ctx context.Context, room, wsAddr string) error {
-	c := new(client)
+func LoadBuyItem(ctx context.Context, room, wsAddr string, rnd *rand.Rand) error {
+	c := &client{rnd: rnd}
 	err := c.Start(ctx, room, wsAddr)
 	if err != nil {
 		return err
 	}
 	defer c.Close()
 
-	for {
+	for ctx.Err() == nil {
+		bought := false
 		for i := len(itemIDs) - 1; 0 <= i; i-- {
 			mitem := mItems[itemIDs[i]]
 			if c.OnSaleTime(mitem.ItemID) != 0 {
@@ -57,50 +58,102 @@ func LoadBuyItem(ctx context.Context, room, wsAddr string) error {
 			}
 
 			_, _, err := c.BuyItem(mitem.ItemID, c.CountBought(mitem.ItemID), c.AfterDefault())
-			if err != nil {
+			if err != nil && err != errConnectionResumed {
 				return err
 			}
+			bought = true
 			break
+		}
 
+		if bought {
 			// 安いアイテムばかり高速に買ってしまわないように適度なsleep
 			time.Sleep(time.Millisecond * 10)
+		} else {
+			// 買えるアイテムが無い間は空回りしないように少し待つ
+			c.Wait(ctx, int64(100+rnd.Intn(10)))
 		}
 	}
+	return nil
 }
 
+// 何度も接続し直しながら少しだけaddIsuする
+func LoadReconnect(ctx context.Context, room, wsAddr string, rnd *rand.Rand) error {
+	for ctx.Err() == nil {
+		c := &client{rnd: rnd}
+		err := c.Start(ctx, room, wsAddr)
+		if err != nil {
+			return err
+		}
+
+		for i := rnd.Intn(3) + 1; 0 < i; i-- {
+			s := genRandomNumberString(rnd, rnd.Intn(50)+1)
+			_, _, err := c.AddIsu(s, c.AfterDefault())
+			if err != nil && err != errConnectionResumed {
+				c.Close()
+				return err
+			}
+			time.Sleep(time.Millisecond * time.Duration(50+rnd.Intn(10)))
+		}
+		c.Close()
+
+		// 意図した切断なので 負荷レベルの判定からは除外する
+		clientReconnectTotal.With(room).Inc()
+	}
+	return nil
+}
+
+// ユーザの振る舞い. ctx が終わるかエラーになるまで返らない
+// 乱数は rnd だけを使う (-seed で再現できるように)
+type userLoop func(ctx context.Context, room, wsAddr string, rnd *rand.Rand) error
+
 func RSLoadIikanji(ctx context.Context, para chan struct{}) error {
-	roomName := genRandomRoomName("load")
+	return RSLoadRoom(ctx, genRandomRoomName("load"), para, LoadAddIsu, LoadBuyItem, 1)
+}
+
+// 1つのルームに対する負荷.
+// para を受け取る度に user を1人追加し, それとは別に resident を常に residents 人維持する
+func RSLoadRoom(ctx context.Context, roomName string, para chan struct{}, user, resident userLoop, residents int) error {
 	wsAddr, err := resolveWsAddr(roomName)
 	if err != nil {
 		return err
 	}
 
 	addClient := int64(0)
-	buyClient := int64(0)
+	residentClient := int64(0)
+
+	residentCh := make(chan struct{}, 1000)
+	for i := 0; i < residents; i++ {
+		residentCh <- struct{}{}
+	}
 
-	buyClientCh := make(chan struct{}, 1000)
-	buyClientCh <- struct{}{}
+	userCount, residentCount := 0, 0
 
 	for {
 		select {
 		case <-para:
+			atomic.AddInt64(&addClient, 1)
+			rnd := newRand(roomName+"/user", userCount)
+			userCount++
 			go func() {
-				err := LoadAddIsu(ctx, roomName, wsAddr)
-				if err == nil {
+				err := user(ctx, roomName, wsAddr, rnd)
+				// 注入した故障で終わった場合も代わりのユーザを追加する
+				if err == nil || isChaosError(err) {
 					para <- struct{}{}
 				}
 				atomic.AddInt64(&addClient, -1)
 			}()
 
 			time.Sleep(10 * time.Millisecond)
-		case <-buyClientCh:
-			atomic.AddInt64(&buyClient, 1)
+		case <-residentCh:
+			atomic.AddInt64(&residentClient, 1)
+			rnd := newRand(roomName+"/resident", residentCount)
+			residentCount++
 			go func() {
-				LoadBuyItem(ctx, roomName, wsAddr)
+				resident(ctx, roomName, wsAddr, rnd)
 
-				atomic.AddInt64(&buyClient, -1)
+				atomic.AddInt64(&residentClient, -1)
 				select {
-				case buyClientCh <- struct{}{}:
+				case residentCh <- struct{}{}:
 				default:
 				}
 			}()
@@ -115,25 +168,25 @@ func RSLoadIikanji(ctx context.Context, para chan struct{}) error {
 // PreTest
 
 func PreTestIndexPage(ctx context.Context) error {
-	url := "http://" + getRemoteAddr()
+	url := "http://" + getRemoteAddr("/")
 	log.Println("PreTestIndexPage", url)
 	res, err := httpClient.Get(url)
 	if err != nil {
-		return fmt.Errorf("GETリクエストに失敗しました. %v", url)
+		return recordError(errCodeHTTP, fmt.Errorf("GETリクエストに失敗しました. %v", url), url)
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != 200 {
-		return fmt.Errorf("期待していないステータスコード. %v", url)
+		return recordError(errCodeHTTP, fmt.Errorf("期待していないステータスコード. %v", url), url)
 	}
 
 	doc, err := goquery.NewDocumentFromReader(res.Body)
 	if err != nil {
-		return fmt.Errorf("ページのHTMLがパースできませんでした")
+		return recordError(errCodeStaticFile, fmt.Errorf("ページのHTMLがパースできませんでした"), url)
 	}
 
 	if !strings.Contains(doc.Find("head > title").Text(), "Chair Constructor Online") {
-		return fmt.Errorf("トップページの title が Chair Constructor Online ではありません")
+		return recordError(errCodeStaticFile, fmt.Errorf("トップページの title が Chair Constructor Online ではありません"), url)
 	}
 
 	var (
@@ -156,13 +209,13 @@ func PreTestIndexPage(ctx context.Context) error {
 	})
 
 	if !phinajs {
-		return fmt.Errorf("トップページで phina.js が読み込まれていません。")
+		return recordError(errCodeStaticFile, fmt.Errorf("トップページで phina.js が読み込まれていません。"), url)
 	}
 	if !gamejs {
-		return fmt.Errorf("トップページで game.js が読み込まれていません。")
+		return recordError(errCodeStaticFile, fmt.Errorf("トップページで game.js が読み込まれていません。"), url)
 	}
 	if !guijs {
-		return fmt.Errorf("トップページで gui.js が読み込まれていません。")
+		return recordError(errCodeStaticFile, fmt.Errorf("トップページで gui.js が読み込まれていません。"), url)
 	}
 
 	return nil
@@ -170,27 +223,27 @@ func PreTestIndexPage(ctx context.Context) error {
 
 func PreTestStaticFile(ctx context.Context) error {
 	for _, sf := range StaticFiles {
-		url := "http://" + getRemoteAddr() + sf.Path
+		url := "http://" + getRemoteAddr(sf.Path) + sf.Path
 		log.Println("PreTestStaticFile", url)
 
 		res, err := httpClient.Get(url)
 		if err != nil {
-			return fmt.Errorf("GETリクエストに失敗しました. %v", url)
+			return recordError(errCodeHTTP, fmt.Errorf("GETリクエストに失敗しました. %v", url), url)
 		}
 		defer res.Body.Close()
 
 		if res.StatusCode != 200 {
-			return fmt.Errorf("期待していないステータスコード. %v", url)
+			return recordError(errCodeStaticFile, fmt.Errorf("期待していないステータスコード. %v", url), url)
 		}
 
 		body, err := ioutil.ReadAll(res.Body)
 		if err != nil {
-			return fmt.Errorf("レスポンスの取得に失敗 %v", url)
+			return recordError(errCodeHTTP, fmt.Errorf("レスポンスの取得に失敗 %v", url), url)
 		}
 
 		hash := md5.Sum(body)
 		if hex.EncodeToString(hash[:]) != sf.Hash {
-			return fmt.Errorf("静的ファイルの内容が一致していません %v", url)
+			return recordError(errCodeStaticFile, fmt.Errorf("静的ファイルの内容が一致していません %v", url), url)
 		}
 	}
 	return nil
@@ -212,17 +265,17 @@ func PreTestRoomAddr(ctx context.Context) error {
 
 		res1, err := httpClient.Get(url)
 		if err != nil {
-			return fmt.Errorf("GETリクエストに失敗しました. %v", url)
+			return recordError(errCodeHTTP, fmt.Errorf("GETリクエストに失敗しました. %v", url), url)
 		}
 		defer res1.Body.Close()
 
 		bytes, err := ioutil.ReadAll(res1.Body)
 		if err != nil {
-			return fmt.Errorf("レスポンスの取得に失敗しました. %v", url)
+			return recordError(errCodeHTTP, fmt.Errorf("レスポンスの取得に失敗しました. %v", url), url)
 		}
 		err = json.Unmarshal(bytes, &x)
 		if err != nil {
-			return fmt.Errorf("JSONが正常に読み取れません. %v", url)
+			return recordError(errCodeFormat, fmt.Errorf("JSONが正常に読み取れません. %v", url), url)
 		}
 
 		// 空の部屋名が許されるか
@@ -230,17 +283,17 @@ func PreTestRoomAddr(ctx context.Context) error {
 
 		res2, err := httpClient.Get(url)
 		if err != nil {
-			return fmt.Errorf("GETリクエストに失敗しました. %v", url)
+			return recordError(errCodeHTTP, fmt.Errorf("GETリクエストに失敗しました. %v", url), url)
 		}
 		defer res2.Body.Close()
 
 		bytes, err = ioutil.ReadAll(res2.Body)
 		if err != nil {
-			return fmt.Errorf("レスポンスの取得に失敗しました. %v", url)
+			return recordError(errCodeHTTP, fmt.Errorf("レスポンスの取得に失敗しました. %v", url), url)
 		}
 		err = json.Unmarshal(bytes, &x)
 		if err != nil {
-			return fmt.Errorf("JSONが正常に読み取れません. %v", url)
+			return recordError(errCodeFormat, fmt.Errorf("JSONが正常に読み取れません. %v", url), url)
 		}
 	}
 	return nil
@@ -267,7 +320,7 @@ func PreTestAddIsu(ctx context.Context) error {
 		return fmt.Errorf("Room %v にて addIsu のリクエストに失敗しました. %v", roomName, err)
 	}
 	if !res.IsSuccess {
-		return fmt.Errorf("Room %v にて addIsu が成功しませんでした. request_id = %v", roomName, res.RequestID)
+		return recordError(errCodeResponse, fmt.Errorf("Room %v にて addIsu が成功しませんでした. request_id = %v", roomName, res.RequestID), roomName)
 	}
 
 	// 過去
@@ -277,7 +330,7 @@ func PreTestAddIsu(ctx context.Context) error {
 		return fmt.Errorf("Room %v にて addIsu のリクエストに失敗しました. %v", roomName, err)
 	}
 	if res.IsSuccess {
-		return fmt.Errorf("Room %v にて 過去に対する addIsu が成功しました. request_id = %v", roomName, res.RequestID)
+		return recordError(errCodeResponse, fmt.Errorf("Room %v にて 過去に対する addIsu が成功しました. request_id = %v", roomName, res.RequestID), roomName)
 	}
 
 	return nil
@@ -320,7 +373,7 @@ func PreTestAddIsuMulti(ctx context.Context) error {
 			return
 		}
 		if !res.IsSuccess {
-			err1 = fmt.Errorf("Room %v にて addIsu が成功しませんでした. request_id = %v", roomName, res.RequestID)
+			err1 = recordError(errCodeResponse, fmt.Errorf("Room %v にて addIsu が成功しませんでした. request_id = %v", roomName, res.RequestID), roomName)
 			return
 		}
 	}()
@@ -334,7 +387,7 @@ func PreTestAddIsuMulti(ctx context.Context) error {
 			return
 		}
 		if !res.IsSuccess {
-			err2 = fmt.Errorf("Room %v にて addIsu が成功しませんでした. request_id = %v", roomName, res.RequestID)
+			err2 = recordError(errCodeResponse, fmt.Errorf("Room %v にて addIsu が成功しませんでした. request_id = %v", roomName, res.RequestID), roomName)
 			return
 		}
 	}()
@@ -392,7 +445,7 @@ func PreTestBuyItem(ctx context.Context) error {
 			return fmt.Errorf("Room %v にて addIsu のリクエストに失敗しました. %v", roomName, err)
 		}
 		if !res.IsSuccess {
-			return fmt.Errorf("Room %v にて addIsu が成功しませんでした. request_id = %v", roomName, res.RequestID)
+			return recordError(errCodeResponse, fmt.Errorf("Room %v にて addIsu が成功しませんでした. request_id = %v", roomName, res.RequestID), roomName)
 		}
 
 		c.WaitUntil(ctx, addt)
@@ -412,7 +465,7 @@ func PreTestBuyItem(ctx context.Context) error {
 			return fmt.Errorf("Room %v にて buyItem のリクエストに失敗しました. %v", roomName, err)
 		}
 		if !res.IsSuccess {
-			return fmt.Errorf("Room %v にて buyItem が成功しませんでした. request_id = %v item_id = %v", roomName, res.RequestID, mitem.ItemID)
+			return recordError(errCodeResponse, fmt.Errorf("Room %v にて buyItem が成功しませんでした. request_id = %v item_id = %v", roomName, res.RequestID, mitem.ItemID), roomName)
 		}
 
 		// buyItem が終わるまで待つ
@@ -464,7 +517,7 @@ func PreTestBuyNotEnough(ctx context.Context) error {
 			return fmt.Errorf("Room %v にて buyItem のリクエストに失敗しました. %v", roomName, err)
 		}
 		if res.IsSuccess {
-			return fmt.Errorf("Room %v にて Isu が足りないにもかかわらず buyItem が成功しました. request_id = %v item_id = %v", roomName, res.RequestID, mitem.ItemID)
+			return recordError(errCodeResponse, fmt.Errorf("Room %v にて Isu が足りないにもかかわらず buyItem が成功しました. request_id = %v item_id = %v", roomName, res.RequestID, mitem.ItemID), roomName)
 		}
 
 		return nil
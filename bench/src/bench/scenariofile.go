@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// YAML または JSON で記述された負荷シナリオ
+//
+// 例は scenarios/*.yaml を参照. 時間は "800ms", "1m" のような文字列で書く.
+type scenarioFile struct {
+	Name string `yaml:"name"`
+
+	// 負荷走行の時間. 0 なら変更しない
+	Duration time.Duration `yaml:"duration"`
+
+	Rooms struct {
+		// 負荷レベルが1上がる毎に作るルーム数
+		PerLevel int `yaml:"per_level"`
+		// ルーム数の上限. 0 なら無制限
+		Max int `yaml:"max"`
+		// 接続数を増やす時に既存のルームではなく新しいルームを作る
+		NewRoomOnAdd bool `yaml:"new_room_on_add"`
+	} `yaml:"rooms"`
+
+	// 負荷レベルに応じて増やすユーザ. count は1つのルームに一度に追加する人数
+	Users scenarioUsers `yaml:"users"`
+
+	// 各ルームに常駐するユーザ. count は1ルームあたりの人数
+	Residents scenarioUsers `yaml:"residents"`
+
+	// 負荷レベルの推移. 空なら接続数とエラーから自動で決める
+	Ramp []scenarioRampStep `yaml:"ramp"`
+
+	Profiles map[string]*scenarioProfile `yaml:"profiles"`
+}
+
+type scenarioUsers struct {
+	Count   int    `yaml:"count"`
+	Profile string `yaml:"profile"`
+}
+
+// 開始から at 経過した時点で負荷レベルを level にする
+type scenarioRampStep struct {
+	At    time.Duration `yaml:"at"`
+	Level int           `yaml:"level"`
+}
+
+type scenarioRange struct {
+	Min int `yaml:"min"`
+	Max int `yaml:"max"`
+}
+
+type scenarioDurationRange struct {
+	Min time.Duration `yaml:"min"`
+	Max time.Duration `yaml:"max"`
+}
+
+// ユーザの振る舞い
+type scenarioProfile struct {
+	// アクション名と重み
+	//   add_isu    : ランダムな数の椅子を追加する
+	//   buy_item   : 購入可能なアイテムのうちIDが一番大きいものを買う. 買えるものが無ければ何もしない
+	//   buy_random : ランダムなアイテムを買う. 椅子が足りなくてもリクエストする
+	Actions map[string]int `yaml:"actions"`
+
+	// アクション毎の待ち時間
+	ThinkTime scenarioDurationRange `yaml:"think_time"`
+
+	// 現在時刻からどれだけ先の時刻をリクエストに指定するか. 省略時は 800ms
+	LeadTime time.Duration `yaml:"lead_time"`
+
+	// add_isu の椅子の桁数. 省略時は 1-50
+	IsuDigits scenarioRange `yaml:"isu_digits"`
+
+	// 1回の接続で行うアクション数. 省略時は切断しない
+	Reconnect scenarioRange `yaml:"reconnect"`
+}
+
+var scenarioActions = []string{"add_isu", "buy_item", "buy_random"}
+
+func isScenarioFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+func readScenarioFile(path string) (*scenarioFile, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON は YAML として読める
+	sf := new(scenarioFile)
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	err = dec.Decode(sf)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+
+	sf.setDefaults()
+	err = sf.validate()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return sf, nil
+}
+
+func (sf *scenarioFile) setDefaults() {
+	if sf.Rooms.PerLevel == 0 {
+		sf.Rooms.PerLevel = 1
+	}
+	for _, p := range sf.Profiles {
+		if p == nil {
+			continue
+		}
+		if p.LeadTime == 0 {
+			p.LeadTime = 800 * time.Millisecond
+		}
+		if p.IsuDigits == (scenarioRange{}) {
+			p.IsuDigits = scenarioRange{Min: 1, Max: 50}
+		}
+	}
+}
+
+func (sf *scenarioFile) validate() error {
+	if sf.Name == "" {
+		return fmt.Errorf("name がありません")
+	}
+	if sf.Duration < 0 {
+		return fmt.Errorf("duration が負です")
+	}
+	if sf.Rooms.PerLevel < 0 || sf.Rooms.Max < 0 {
+		return fmt.Errorf("rooms.per_level, rooms.max は0以上にしてください")
+	}
+	if sf.Users.Count < 1 {
+		return fmt.Errorf("users.count は1以上にしてください")
+	}
+	if sf.Residents.Count < 0 {
+		return fmt.Errorf("residents.count は0以上にしてください")
+	}
+
+	for name, p := range sf.Profiles {
+		if p == nil {
+			return fmt.Errorf("profiles.%v が空です", name)
+		}
+		err := p.validate()
+		if err != nil {
+			return fmt.Errorf("profiles.%v: %v", name, err)
+		}
+	}
+	if _, ok := sf.Profiles[sf.Users.Profile]; !ok {
+		return fmt.Errorf("users.profile %q が profiles にありません", sf.Users.Profile)
+	}
+	if _, ok := sf.Profiles[sf.Residents.Profile]; !ok && 0 < sf.Residents.Count {
+		return fmt.Errorf("residents.profile %q が profiles にありません", sf.Residents.Profile)
+	}
+
+	for i, r := range sf.Ramp {
+		if r.At < 0 || r.Level < 0 {
+			return fmt.Errorf("ramp[%v] の at, level は0以上にしてください", i)
+		}
+		if 0 < i && r.At <= sf.Ramp[i-1].At {
+			return fmt.Errorf("ramp は at の昇順に並べてください")
+		}
+		if 0 < i && r.Level < sf.Ramp[i-1].Level {
+			return fmt.Errorf("ramp の level は下げられません")
+		}
+	}
+	return nil
+}
+
+func (p *scenarioProfile) validate() error {
+	if len(p.Actions) == 0 {
+		return fmt.Errorf("actions がありません")
+	}
+	total := 0
+	for name, w := range p.Actions {
+		known := false
+		for _, a := range scenarioActions {
+			known = known || a == name
+		}
+		if !known {
+			return fmt.Errorf("不明なアクション %v %v", name, scenarioActions)
+		}
+		if w < 0 {
+			return fmt.Errorf("actions.%v の重みが負です", name)
+		}
+		total += w
+	}
+	if total == 0 {
+		return fmt.Errorf("actions の重みの合計が0です")
+	}
+	if p.ThinkTime.Min < 0 || p.ThinkTime.Max < p.ThinkTime.Min {
+		return fmt.Errorf("think_time は 0 <= min <= max にしてください")
+	}
+	if p.LeadTime <= 0 || time.Second < p.LeadTime {
+		return fmt.Errorf("lead_time は 1ms から 1s の間にしてください")
+	}
+	if p.IsuDigits.Min < 1 || p.IsuDigits.Max < p.IsuDigits.Min {
+		return fmt.Errorf("isu_digits は 1 <= min <= max にしてください")
+	}
+	if p.Reconnect.Min < 0 || p.Reconnect.Max < p.Reconnect.Min || (p.Reconnect.Min == 0 && 0 < p.Reconnect.Max) {
+		return fmt.Errorf("reconnect は 1 <= min <= max にしてください")
+	}
+	return nil
+}
+
+func randRange(rnd *rand.Rand, min, max int) int {
+	return min + rnd.Intn(max-min+1)
+}
+
+func (p *scenarioProfile) pickAction(rnd *rand.Rand) string {
+	var names []string
+	total := 0
+	for name, w := range p.Actions {
+		names = append(names, name)
+		total += w
+	}
+	// map の順序に依存しないようにする
+	sort.Strings(names)
+
+	x := rnd.Intn(total)
+	for _, name := range names {
+		x -= p.Actions[name]
+		if x < 0 {
+			return name
+		}
+	}
+	return names[len(names)-1]
+}
+
+func (p *scenarioProfile) doAction(c *client, rnd *rand.Rand, action string) error {
+	t := c.After(int64(p.LeadTime / time.Millisecond))
+	switch action {
+	case "add_isu":
+		s := genRandomNumberString(rnd, randRange(rnd, p.IsuDigits.Min, p.IsuDigits.Max))
+		_, _, err := c.AddIsu(s, t)
+		return err
+	case "buy_item":
+		for i := len(itemIDs) - 1; 0 <= i; i-- {
+			mitem := mItems[itemIDs[i]]
+			if c.OnSaleTime(mitem.ItemID) != 0 {
+				continue
+			}
+			_, _, err := c.BuyItem(mitem.ItemID, c.CountBought(mitem.ItemID), t)
+			return err
+		}
+		return nil
+	case "buy_random":
+		mitem := randomItem(rnd)
+		_, _, err := c.BuyItem(mitem.ItemID, c.CountBought(mitem.ItemID), t)
+		return err
+	}
+	return fmt.Errorf("不明なアクション %v", action)
+}
+
+// userLoop として使う
+func (p *scenarioProfile) run(ctx context.Context, room, wsAddr string, rnd *rand.Rand) error {
+	for ctx.Err() == nil {
+		c := &client{rnd: rnd}
+		err := c.Start(ctx, room, wsAddr)
+		if err != nil {
+			return err
+		}
+
+		n := -1
+		if 0 < p.Reconnect.Max {
+			n = randRange(rnd, p.Reconnect.Min, p.Reconnect.Max)
+		}
+		for ; n != 0; n-- {
+			err := p.doAction(c, rnd, p.pickAction(rnd))
+			if err != nil && err != errConnectionResumed {
+				c.Close()
+				return err
+			}
+			if 0 < p.ThinkTime.Max {
+				d := p.ThinkTime.Min + time.Duration(rnd.Int63n(int64(p.ThinkTime.Max-p.ThinkTime.Min)+1))
+				time.Sleep(d)
+			}
+		}
+		c.Close()
+
+		// 意図した切断なので 負荷レベルの判定からは除外する
+		clientReconnectTotal.With(room).Inc()
+	}
+	return nil
+}
+
+// 負荷レベルの推移を自分で決めるシナリオ
+type rampScenario interface {
+	// 開始から elapsed 経過した時点の負荷レベル. ok が false なら自動で決める
+	TargetLevel(elapsed time.Duration) (level int, ok bool)
+}
+
+type fileScenario struct {
+	*roomScenario
+	file *scenarioFile
+}
+
+func newFileScenario(path string) (LoadScenario, error) {
+	sf, err := readScenarioFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rs := &roomScenario{
+		user:          sf.Profiles[sf.Users.Profile].run,
+		residents:     sf.Residents.Count,
+		usersPerRoom:  sf.Users.Count,
+		roomsPerLevel: sf.Rooms.PerLevel,
+		maxRooms:      sf.Rooms.Max,
+		newRoomOnAdd:  sf.Rooms.NewRoomOnAdd,
+	}
+	if 0 < sf.Residents.Count {
+		rs.resident = sf.Profiles[sf.Residents.Profile].run
+	}
+	return &fileScenario{roomScenario: rs, file: sf}, nil
+}
+
+func (s *fileScenario) TargetLevel(elapsed time.Duration) (int, bool) {
+	if len(s.file.Ramp) == 0 {
+		return 0, false
+	}
+	level := 0
+	for _, r := range s.file.Ramp {
+		if r.At <= elapsed {
+			level = r.Level
+		}
+	}
+	return level, true
+}
+
+func (s *fileScenario) Metrics() []string {
+	return append([]string{"Scenario: " + s.file.Name}, s.roomScenario.Metrics()...)
+}
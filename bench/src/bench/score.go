@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+)
+
+// スコアの計算
+//
+// 負荷走行の結果 (scoreInput) から ScoreFormula がスコアと内訳 (ScoreBreakdown) を計算する.
+// 内訳は BenchResult.ScoreBreakdown に入り, ポータルにも表示される.
+type ScoreFormula interface {
+	Score(in scoreInput) ScoreBreakdown
+}
+
+// 負荷走行の結果. 数は負荷走行全体の累計
+type scoreInput struct {
+	AddIsuOK  int64
+	BuyItemOK int64
+	Errors    int64
+	Timeouts  int64
+	Latency   map[string]LatencySummary
+}
+
+// フラグで指定するパラメータ
+var scoreParams = struct {
+	AddIsu         float64
+	BuyItem        float64
+	ErrorPenalty   float64
+	TimeoutPenalty float64
+	LatencyKey     string
+	LatencyTarget  float64
+	LatencyBonus   float64
+}{
+	AddIsu:         1,
+	BuyItem:        10,
+	ErrorPenalty:   1,
+	TimeoutPenalty: 10,
+	LatencyKey:     "latency-status|addIsu",
+	LatencyTarget:  1000,
+	LatencyBonus:   0.1,
+}
+
+var scoreFormulas = map[string]func() ScoreFormula{
+	// addIsu の成功数 + 10 * buyItem の成功数
+	"default": func() ScoreFormula {
+		return &weightedFormula{name: "default", addIsu: 1, buyItem: 10}
+	},
+	// -score-addisu, -score-buyitem の重みで数え, エラーとタイムアウトを減点する
+	"penalty": func() ScoreFormula {
+		return &weightedFormula{
+			name:           "penalty",
+			addIsu:         scoreParams.AddIsu,
+			buyItem:        scoreParams.BuyItem,
+			errorPenalty:   scoreParams.ErrorPenalty,
+			timeoutPenalty: scoreParams.TimeoutPenalty,
+		}
+	},
+	// penalty に加え, -score-latency-key の p99 が -score-latency-target (ms) より短ければ加点する
+	"latency": func() ScoreFormula {
+		return &weightedFormula{
+			name:           "latency",
+			addIsu:         scoreParams.AddIsu,
+			buyItem:        scoreParams.BuyItem,
+			errorPenalty:   scoreParams.ErrorPenalty,
+			timeoutPenalty: scoreParams.TimeoutPenalty,
+			latencyKey:     scoreParams.LatencyKey,
+			latencyTarget:  scoreParams.LatencyTarget,
+			latencyBonus:   scoreParams.LatencyBonus,
+		}
+	},
+}
+
+var scoreFormulaName = "default"
+
+func scoreFormulaNames() []string {
+	var names []string
+	for name := range scoreFormulas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newScoreFormula(name string) (ScoreFormula, error) {
+	f, ok := scoreFormulas[name]
+	if !ok {
+		return nil, fmt.Errorf("スコア計算 %v は存在しません %v", name, scoreFormulaNames())
+	}
+	return f(), nil
+}
+
+type weightedFormula struct {
+	name string
+
+	addIsu, buyItem              float64
+	errorPenalty, timeoutPenalty float64
+
+	latencyKey    string
+	latencyTarget float64
+	latencyBonus  float64
+}
+
+func (f *weightedFormula) Score(in scoreInput) ScoreBreakdown {
+	b := ScoreBreakdown{Formula: f.name}
+	add := func(name, desc string, count, weight float64) {
+		b.Items = append(b.Items, ScoreItem{
+			Name:        name,
+			Description: desc,
+			Count:       count,
+			Weight:      weight,
+			Points:      int64(math.Floor(count * weight)),
+		})
+	}
+
+	add("addisu_ok", "addIsu の成功数", float64(in.AddIsuOK), f.addIsu)
+	add("buyitem_ok", "buyItem の成功数", float64(in.BuyItemOK), f.buyItem)
+	if f.errorPenalty != 0 {
+		add("error", "エラー数", float64(in.Errors), -f.errorPenalty)
+	}
+	if f.timeoutPenalty != 0 {
+		add("timeout", "タイムアウト数", float64(in.Timeouts), -f.timeoutPenalty)
+	}
+
+	var base int64
+	for _, item := range b.Items {
+		base += item.Points
+	}
+
+	if f.latencyKey != "" && 0 < f.latencyTarget {
+		// 目標の処理時間に対してどれだけ短いか (0 - 1)
+		ratio := 0.0
+		if l, ok := in.Latency[f.latencyKey]; ok && 0 < l.Count {
+			ratio = math.Max(0, math.Min(1, (f.latencyTarget-l.P99)/f.latencyTarget))
+		}
+		bonus := 0.0
+		if 0 < base {
+			bonus = float64(base) * f.latencyBonus
+		}
+		add("latency_bonus", fmt.Sprintf("%v の p99 が %vms より短い割合", f.latencyKey, f.latencyTarget), ratio, bonus)
+	}
+
+	for _, item := range b.Items {
+		b.Total += item.Points
+	}
+	if b.Total < 0 {
+		b.Total = 0
+	}
+	return b
+}
+
+func readScoreInput() scoreInput {
+	return scoreInput{
+		AddIsuOK:  clientResponseTotal.Sum("", "addIsu", "ok"),
+		BuyItemOK: clientResponseTotal.Sum("", "buyItem", "ok"),
+		Errors:    clientErrorTotal.Value(),
+		Timeouts:  clientTimeoutTotal.Value(),
+		Latency:   getLatencySummary(),
+	}
+}
+
+func computeScore(in scoreInput) ScoreBreakdown {
+	f, err := newScoreFormula(scoreFormulaName)
+	if err != nil {
+		// フラグの検証で弾いているので来ない
+		panic(err)
+	}
+	return f.Score(in)
+}
+
+func logScoreBreakdown(b ScoreBreakdown) {
+	log.Println("score formula:", b.Formula)
+	for _, item := range b.Items {
+		log.Printf("  %v: %v x %v = %v (%v)", item.Name, item.Count, item.Weight, item.Points, item.Description)
+	}
+	log.Println("  total:", b.Total)
+}
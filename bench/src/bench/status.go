@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bench/metrics"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 実行中のベンチマークの進捗. pprofPort の /status (JSON) と /status.html で見られる
+
+const statusRecentErrors = 20
+
+var benchStatus struct {
+	mtx        sync.Mutex
+	phase      string
+	start      time.Time
+	phaseStart time.Time
+	errors     []statusError
+}
+
+type statusError struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+type statusRoom struct {
+	Room   string `json:"room"`
+	Active int64  `json:"active"`
+}
+
+type statusReport struct {
+	Phase        string        `json:"phase"`
+	Elapsed      float64       `json:"elapsed"`
+	PhaseElapsed float64       `json:"phase_elapsed"`
+	LoadLevel    int           `json:"load_level"`
+	Active       int64         `json:"active"`
+	Rooms        []statusRoom  `json:"rooms"`
+	AddIsuOK     int64         `json:"addisu_ok"`
+	BuyItemOK    int64         `json:"buyitem_ok"`
+	Score        int64         `json:"score"`
+	Errors       int64         `json:"errors"`
+	RecentErrors []statusError `json:"recent_errors"`
+}
+
+func init() {
+	benchStatus.phase = "idle"
+}
+
+// phase は initialize, preTest, load, postTest, done
+func setPhase(phase string) {
+	benchStatus.mtx.Lock()
+	defer benchStatus.mtx.Unlock()
+
+	now := time.Now()
+	if phase == "initialize" {
+		benchStatus.start = now
+		benchStatus.errors = nil
+	}
+	benchStatus.phase = phase
+	benchStatus.phaseStart = now
+}
+
+func addStatusError(err *clientError) {
+	benchStatus.mtx.Lock()
+	defer benchStatus.mtx.Unlock()
+
+	benchStatus.errors = append(benchStatus.errors, statusError{err.t, err.Error()})
+	if statusRecentErrors < len(benchStatus.errors) {
+		benchStatus.errors = benchStatus.errors[len(benchStatus.errors)-statusRecentErrors:]
+	}
+}
+
+func getStatusReport() statusReport {
+	benchStatus.mtx.Lock()
+	r := statusReport{
+		Phase:        benchStatus.phase,
+		RecentErrors: append([]statusError{}, benchStatus.errors...),
+	}
+	if !benchStatus.start.IsZero() {
+		r.Elapsed = time.Since(benchStatus.start).Seconds()
+		r.PhaseElapsed = time.Since(benchStatus.phaseStart).Seconds()
+	}
+	benchStatus.mtx.Unlock()
+
+	r.LoadLevel = int(atomic.LoadInt32(&loadLevel))
+	// バリデーションの結果は含まない
+	in := readScoreInput()
+	r.Score = computeScore(in).Total
+	r.AddIsuOK, r.BuyItemOK = in.AddIsuOK, in.BuyItemOK
+	r.Errors = clientErrorTotal.Value()
+
+	r.Rooms = []statusRoom{}
+	clientActive.Each(func(values []string, g *metrics.Gauge) {
+		if active := g.Value(); 0 < active {
+			r.Rooms = append(r.Rooms, statusRoom{values[0], active})
+			r.Active += active
+		}
+	})
+	sort.Slice(r.Rooms, func(i, j int) bool {
+		return r.Rooms[i].Active > r.Rooms[j].Active
+	})
+	return r
+}
+
+var statusTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="2">
+<title>bench status</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 2px 8px; text-align: left; }
+</style>
+</head>
+<body>
+<h1>{{.Phase}}</h1>
+<table>
+<tr><th>経過時間</th><td>{{printf "%.1f" .Elapsed}}s ({{.Phase}}: {{printf "%.1f" .PhaseElapsed}}s)</td></tr>
+<tr><th>負荷レベル</th><td>{{.LoadLevel}}</td></tr>
+<tr><th>接続数</th><td>{{.Active}}</td></tr>
+<tr><th>スコア</th><td>{{.Score}} (addIsu: {{.AddIsuOK}}, buyItem: {{.BuyItemOK}})</td></tr>
+<tr><th>エラー数</th><td>{{.Errors}}</td></tr>
+</table>
+
+<h2>ルーム</h2>
+<table>
+<tr><th>room</th><th>active</th></tr>
+{{range .Rooms}}<tr><td>{{.Room}}</td><td>{{.Active}}</td></tr>
+{{end}}</table>
+
+<h2>最近のエラー</h2>
+<ul>
+{{range .RecentErrors}}<li>{{.Time.Format "15:04:05.000"}} {{.Message}}</li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+func registerStatusHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(getStatusReport())
+	})
+	mux.HandleFunc("/status.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := statusTemplate.Execute(w, getStatusReport())
+		if err != nil {
+			log.Println("status.html", err)
+		}
+	})
+}
@@ -1,10 +1,8 @@
 package main
 
 import (
-	"encoding/json"
-	"errors"
-	"fmt"
-	"math/big"
+	"app/exponential"
+	"app/game"
 	"time"
 )
 
@@ -27,97 +25,20 @@ type GameResponse struct {
 }
 
 // 10進数の指数表記に使うデータ。JSONでは [仮数部, 指数部] という2要素配列になる。
-type Exponential struct {
-	// Mantissa * 10 ^ Exponent
-	Mantissa int64
-	Exponent int64
-}
-
-func (n *Exponential) MarshalJSON() ([]byte, error) {
-	return []byte(fmt.Sprintf("[%d,%d]", n.Mantissa, n.Exponent)), nil
-}
-
-func (n *Exponential) UnmarshalJSON(b []byte) error {
-	var data []int64
-	err := json.Unmarshal(b, &data)
-	if err != nil {
-		return err
-	}
-	if len(data) != 2 {
-		return errors.New("invalid length Exponential")
-	}
-	n.Mantissa = data[0]
-	n.Exponent = data[1]
-	return nil
-}
-
-// ==
-func (n *Exponential) Eq(o Exponential) bool {
-	return n.Mantissa == o.Mantissa && n.Exponent == o.Exponent
-}
-
-// <
-func (n *Exponential) Less(o Exponential) bool {
-	if n.Exponent == o.Exponent {
-		return n.Mantissa < o.Mantissa
-	}
-	return n.Exponent < o.Exponent
-}
-
-// <=
-func (n *Exponential) LessEq(o Exponential) bool {
-	if n.Exponent == o.Exponent {
-		return n.Mantissa <= o.Mantissa
-	}
-	return n.Exponent < o.Exponent
-}
-
-type Adding struct {
-	RoomName string `json:"-" db:"room_name"`
-	Time     int64  `json:"time" db:"time"`
-	Isu      string `json:"isu" db:"isu"`
-}
-
-type Buying struct {
-	RoomName string `db:"room_name"`
-	ItemID   int    `db:"item_id"`
-	Ordinal  int    `db:"ordinal"`
-	Time     int64  `db:"time"`
-}
-
-type Schedule struct {
-	Time       int64       `json:"time"`
-	MilliIsu   Exponential `json:"milli_isu"`
-	TotalPower Exponential `json:"total_power"`
-}
-
-type Item struct {
-	ItemID      int         `json:"item_id"`
-	CountBought int         `json:"count_bought"`
-	CountBuilt  int         `json:"count_built"`
-	NextPrice   Exponential `json:"next_price"`
-	Power       Exponential `json:"power"`
-	Building    []Building  `json:"building"`
-}
-
-type OnSale struct {
-	ItemID int   `json:"item_id"`
-	Time   int64 `json:"time"`
-}
-
-type Building struct {
-	Time       int64       `json:"time"`
-	CountBuilt int         `json:"count_built"`
-	Power      Exponential `json:"power"`
-}
-
-type GameStatus struct {
-	Time     int64      `json:"time"`
-	Adding   []Adding   `json:"adding"`
-	Schedule []Schedule `json:"schedule"`
-	Items    []Item     `json:"items"`
-	OnSale   []OnSale   `json:"on_sale"`
-}
+// webapp と同じ実装を使う。
+type Exponential = exponential.Exponential
+
+// ゲームのルールは webapp と共有する app/game にある
+type (
+	Adding     = game.Adding
+	Buying     = game.Buying
+	Schedule   = game.Schedule
+	Item       = game.Item
+	OnSale     = game.OnSale
+	Building   = game.Building
+	GameStatus = game.GameStatus
+	mItem      = game.MItem
+)
 
 // for validation
 type GameStatusLog struct {
@@ -125,6 +46,7 @@ type GameStatusLog struct {
 
 	ClientID   int
 	ClientTime time.Time
+	Conn       int // 同じ client が接続し直した回数
 }
 
 type GameRequestLog struct {
@@ -132,6 +54,8 @@ type GameRequestLog struct {
 
 	ClientID   int
 	ClientTime time.Time
+	Conn       int
+	Chaos      string // 注入した故障 (chaos.go)
 }
 
 type GameResponseLog struct {
@@ -139,42 +63,5 @@ type GameResponseLog struct {
 
 	ClientID   int
 	ClientTime time.Time
-}
-
-type mItem struct {
-	ItemID int   `db:"item_id"`
-	Power1 int64 `db:"power1"`
-	Power2 int64 `db:"power2"`
-	Power3 int64 `db:"power3"`
-	Power4 int64 `db:"power4"`
-	Price1 int64 `db:"price1"`
-	Price2 int64 `db:"price2"`
-	Price3 int64 `db:"price3"`
-	Price4 int64 `db:"price4"`
-}
-
-func (item *mItem) GetPower(count int) *big.Int {
-	// power(x):=(cx+1)*d^(ax+b)
-	a := item.Power1
-	b := item.Power2
-	c := item.Power3
-	d := item.Power4
-	x := int64(count)
-
-	s := big.NewInt(c*x + 1)
-	t := new(big.Int).Exp(big.NewInt(d), big.NewInt(a*x+b), nil)
-	return new(big.Int).Mul(s, t)
-}
-
-func (item *mItem) GetPrice(count int) *big.Int {
-	// price(x):=(cx+1)*d^(ax+b)
-	a := item.Price1
-	b := item.Price2
-	c := item.Price3
-	d := item.Price4
-	x := int64(count)
-
-	s := big.NewInt(c*x + 1)
-	t := new(big.Int).Exp(big.NewInt(d), big.NewInt(a*x+b), nil)
-	return new(big.Int).Mul(s, t)
+	Conn       int
 }
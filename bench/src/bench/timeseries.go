@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bench/metrics"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 負荷走行中の1秒毎の記録
+
+// CSV に出力する処理時間
+var timeSeriesLatencyKeys = []string{
+	"latency-response|addIsu",
+	"latency-response|buyItem",
+	"latency-status|addIsu",
+	"latency-status|buyItem",
+	"status-interval",
+}
+
+var timeSeries struct {
+	mtx     sync.Mutex
+	samples []MetricsSample
+}
+
+type timeSeriesCounters struct {
+	open, close                              int64
+	addIsuOK, addIsuNG, buyItemOK, buyItemNG int64
+	errors                                   int64
+	latency                                  map[string]metrics.HistogramSnapshot
+}
+
+func readTimeSeriesCounters() timeSeriesCounters {
+	c := timeSeriesCounters{
+		open:      clientOpenTotal.Sum(),
+		close:     clientCloseTotal.Sum(),
+		addIsuOK:  clientResponseTotal.Sum("", "addIsu", "ok"),
+		addIsuNG:  clientResponseTotal.Sum("", "addIsu", "ng"),
+		buyItemOK: clientResponseTotal.Sum("", "buyItem", "ok"),
+		buyItemNG: clientResponseTotal.Sum("", "buyItem", "ng"),
+		errors:    clientErrorTotal.Value(),
+		latency:   latencySnapshots(),
+	}
+	return c
+}
+
+// ctx が終わるまで1秒毎に記録する
+func recordTimeSeries(ctx context.Context) {
+	start := time.Now()
+	prev := readTimeSeriesCounters()
+
+	beat := time.NewTicker(time.Second)
+	defer beat.Stop()
+
+	for {
+		select {
+		case <-beat.C:
+		case <-ctx.Done():
+			return
+		}
+
+		cur := readTimeSeriesCounters()
+		s := MetricsSample{
+			Elapsed:   time.Since(start).Seconds(),
+			LoadLevel: int(atomic.LoadInt32(&loadLevel)),
+			Active:    cur.open - cur.close,
+			Open:      cur.open - prev.open,
+			Close:     cur.close - prev.close,
+			AddIsuOK:  cur.addIsuOK - prev.addIsuOK,
+			AddIsuNG:  cur.addIsuNG - prev.addIsuNG,
+			BuyItemOK: cur.buyItemOK - prev.buyItemOK,
+			BuyItemNG: cur.buyItemNG - prev.buyItemNG,
+			Errors:    cur.errors - prev.errors,
+			Latency:   map[string]LatencySummary{},
+		}
+		for key, h := range cur.latency {
+			d := h.Sub(prev.latency[key])
+			if 0 < d.Count {
+				s.Latency[key] = summarizeLatency(d)
+			}
+		}
+		prev = cur
+
+		timeSeries.mtx.Lock()
+		timeSeries.samples = append(timeSeries.samples, s)
+		timeSeries.mtx.Unlock()
+	}
+}
+
+func getTimeSeries() []MetricsSample {
+	timeSeries.mtx.Lock()
+	defer timeSeries.mtx.Unlock()
+	return append([]MetricsSample(nil), timeSeries.samples...)
+}
+
+// result.json に対して result-timeseries.csv, result-timeseries.json を書き出す
+func writeTimeSeries(resultPath string, samples []MetricsSample) error {
+	base := strings.TrimSuffix(resultPath, filepath.Ext(resultPath)) + "-timeseries"
+
+	b, err := json.Marshal(samples)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(base+".json", b, 0644)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(base + ".csv")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	header := []string{"elapsed", "load_level", "active", "open", "close",
+		"addisu_ok", "addisu_ng", "buyitem_ok", "buyitem_ng", "errors"}
+	for _, key := range timeSeriesLatencyKeys {
+		for _, q := range []string{"p50", "p90", "p99", "max"} {
+			header = append(header, key+"|"+q)
+		}
+	}
+	w.Write(header)
+
+	for _, s := range samples {
+		row := []string{
+			fmt.Sprintf("%.3f", s.Elapsed),
+			fmt.Sprint(s.LoadLevel),
+			fmt.Sprint(s.Active),
+			fmt.Sprint(s.Open),
+			fmt.Sprint(s.Close),
+			fmt.Sprint(s.AddIsuOK),
+			fmt.Sprint(s.AddIsuNG),
+			fmt.Sprint(s.BuyItemOK),
+			fmt.Sprint(s.BuyItemNG),
+			fmt.Sprint(s.Errors),
+		}
+		for _, key := range timeSeriesLatencyKeys {
+			l, ok := s.Latency[key]
+			if !ok {
+				row = append(row, "", "", "", "")
+				continue
+			}
+			for _, v := range []float64{l.P50, l.P90, l.P99, l.Max} {
+				row = append(row, fmt.Sprintf("%.3f", v))
+			}
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	return w.Error()
+}
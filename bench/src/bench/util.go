@@ -1,19 +1,34 @@
 package main
 
 import (
+	"app/exponential"
+	"hash/fnv"
 	"log"
-	"math"
 	"math/big"
 	"math/rand"
-	"sync"
 	"time"
 )
 
-var (
-	utilMtx  sync.Mutex
-	big10    = big.NewInt(10)
-	e10cache = make(map[int64]*big.Int)
-)
+// -seed で指定された乱数の種
+var randSeed int64
+
+// 乱数の種を設定する.
+// 並行に動くユーザ間で乱数を使う順序は決まらないので, ユーザ毎に newRand で乱数を作る
+func seedRand(seed int64) {
+	randSeed = seed
+	rand.Seed(seed)
+
+	genRoom.mtx.Lock()
+	genRoom.rnd = newRand("room", 0)
+	genRoom.mtx.Unlock()
+}
+
+// key と n と randSeed から決まる乱数
+func newRand(key string, n int) *rand.Rand {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return rand.New(rand.NewSource(randSeed ^ int64(h.Sum64()^uint64(n)*0x9E3779B97F4A7C15)))
+}
 
 func must(err error) {
 	if err != nil {
@@ -29,74 +44,27 @@ func str2big(s string) *big.Int {
 
 func big2exp(n *big.Int) Exponential {
 	if n == nil {
-		log.Fatalln("big2expB : nil")
-	} else {
-		sign := n.Sign()
-		if sign < 0 {
-			log.Fatalln("big2expB : negative")
-		} else {
-			if n.IsInt64() {
-				v := n.Int64()
-				if v < 1000000000000000 { // 10^15
-					return Exponential{v, 0}
-				}
-			}
-			x := int64(math.Floor(float64(n.BitLen()-1)*0.301029995663981 + 1))
-			i := x - 17
-			if i <= 0 {
-				i = 1
-			}
-
-			utilMtx.Lock()
-			e, ok := e10cache[i]
-			utilMtx.Unlock()
-
-			if !ok {
-				e = new(big.Int).Exp(big10, big.NewInt(i), nil)
-
-				utilMtx.Lock()
-				e10cache[i] = e
-				utilMtx.Unlock()
-			}
-
-			t := new(big.Int).Quo(n, e)
-			for {
-				if t.IsInt64() {
-					y := t.Int64()
-					if y < 100000000000000 { // 10^14
-						log.Fatalln("big2expB : something wrong", y, n.String(), x, i)
-					}
-					if y < 1000000000000000 { // 10^15
-						return Exponential{y, i}
-					}
-				}
-				t.Quo(t, big10)
-				i += 1
-			}
-		}
+		log.Fatalln("big2exp : nil")
 	}
-	panic("something wrong")
-}
-
-func exp2big(e Exponential) *big.Int {
-	n := big.NewInt(e.Mantissa)
-	n.Exp(n, big.NewInt(e.Exponent), nil)
-	return n
+	if n.Sign() < 0 {
+		log.Fatalln("big2exp : negative")
+	}
+	return exponential.FromBig(n)
 }
 
 func unixMilliSecond(t time.Time) int64 {
 	return t.UnixNano() / 1000000
 }
 
-func genRandomNumberString(length int) string {
+func genRandomNumberString(rnd *rand.Rand, length int) string {
 	runes := []rune("0123456789")
 
 	b := make([]rune, length)
 	for i := range b {
-		b[i] = runes[rand.Intn(len(runes))]
+		b[i] = runes[rnd.Intn(len(runes))]
 	}
 	if b[0] == '0' {
-		b[0] = runes[rand.Intn(len(runes)-1)+1]
+		b[0] = runes[rnd.Intn(len(runes)-1)+1]
 	}
 	return string(b)
 }
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ゲームログの検証に失敗したときのレポート
+//
+// 失敗した status, validateStatusBench が計算した期待値, その時刻の前後の request / response,
+// 組み合わせを考えた応答の無い request と購入履歴を <result>-validation.json, <result>-validation.html に出力する.
+
+// 食い違った値が分からないときに, status の前後何ミリ秒の request をレポートに含めるか
+const validationReportWindow = 1000
+
+// 購入履歴を count_bought の前後何個まで含めるか
+const validationReportBuyHistory = 3
+
+type validationReport struct {
+	Room       string          `json:"room"`
+	Message    string          `json:"message"`
+	ClientID   int             `json:"client_id"`
+	ClientTime time.Time       `json:"client_time"`
+	Status     *GameStatus     `json:"status"`
+	Expected   *statusExpected `json:"expected"`
+	Trace      []traceEntry    `json:"trace"`
+	BuyHistory []buyRecord     `json:"buy_history"`
+	// 反映されたかどうかの組み合わせを考えた, 応答の無い request の request_id
+	NoResponse []int `json:"no_response"`
+}
+
+// validateStatusBench が計算した期待値. milli_isu と on_sale は範囲になる
+type statusExpected struct {
+	Schedule []expectedSchedule `json:"schedule"`
+	Items    []expectedItem     `json:"items"`
+	// ここに無い item は on_sale に含まれてはいけない
+	OnSale []expectedOnSale `json:"on_sale"`
+}
+
+type expectedSchedule struct {
+	Time        int64       `json:"time"`
+	MilliIsuMin Exponential `json:"milli_isu_min"`
+	MilliIsuMax Exponential `json:"milli_isu_max"`
+	TotalPower  Exponential `json:"total_power"`
+}
+
+type expectedItem struct {
+	ItemID      int         `json:"item_id"`
+	CountBought int         `json:"count_bought"`
+	CountBuilt  int         `json:"count_built"`
+	NextPrice   Exponential `json:"next_price"`
+	Power       Exponential `json:"power"`
+	Building    []Building  `json:"building"`
+}
+
+type expectedOnSale struct {
+	ItemID int    `json:"item_id"`
+	Min    int64  `json:"min"`
+	Max    *int64 `json:"max"` // null なら上限なし
+	// true なら on_sale に無くても良い
+	Optional bool `json:"optional"`
+}
+
+func (e *statusExpected) set(schedule1 []Schedule, schedule2 []Exponential, itemBought, itemBuilt map[int]int,
+	itemPrice map[int]*itemMasterElement, itemPower map[int]Exponential, itemBuilding map[int][]Building,
+	itemOnSale1, itemOnSale2 map[int]int64) {
+
+	e.Schedule = nil
+	for i, s := range schedule1 {
+		e.Schedule = append(e.Schedule, expectedSchedule{
+			Time:        s.Time,
+			MilliIsuMin: s.MilliIsu,
+			MilliIsuMax: schedule2[i],
+			TotalPower:  s.TotalPower,
+		})
+	}
+
+	var ids []int
+	for itemID := range mItems {
+		ids = append(ids, itemID)
+	}
+	sort.Ints(ids)
+
+	e.Items, e.OnSale = nil, nil
+	for _, itemID := range ids {
+		e.Items = append(e.Items, expectedItem{
+			ItemID:      itemID,
+			CountBought: itemBought[itemID],
+			CountBuilt:  itemBuilt[itemID],
+			NextPrice:   itemPrice[itemID].exp,
+			Power:       itemPower[itemID],
+			Building:    itemBuilding[itemID],
+		})
+
+		// itemOnSale2 が最も早い時刻, itemOnSale1 が最も遅い時刻
+		t1, ok1 := itemOnSale2[itemID]
+		t2, ok2 := itemOnSale1[itemID]
+		if !ok1 {
+			continue
+		}
+		x := expectedOnSale{ItemID: itemID, Min: t1, Optional: !ok2}
+		if ok2 {
+			x.Max = &t2
+		}
+		e.OnSale = append(e.OnSale, x)
+	}
+}
+
+type traceEntry struct {
+	RequestID   int       `json:"request_id"`
+	ClientID    int       `json:"client_id"`
+	Action      string    `json:"action"`
+	Time        int64     `json:"time"`
+	Isu         string    `json:"isu,omitempty"`
+	ItemID      int       `json:"item_id,omitempty"`
+	CountBought int       `json:"count_bought,omitempty"`
+	RequestAt   time.Time `json:"request_at"`
+	// success, failure, no_response, pending
+	Result     string     `json:"result"`
+	ResponseAt *time.Time `json:"response_at,omitempty"`
+	Chaos      string     `json:"chaos,omitempty"` // 注入した故障
+}
+
+func newTraceEntry(req *GameRequestLog, res *GameResponseLog, result string) traceEntry {
+	e := traceEntry{
+		RequestID:   req.RequestID,
+		ClientID:    req.ClientID,
+		Action:      req.Action,
+		Time:        req.Time,
+		Isu:         req.Isu,
+		ItemID:      req.ItemID,
+		CountBought: req.CountBought,
+		RequestAt:   req.ClientTime,
+		Result:      result,
+		Chaos:       req.Chaos,
+	}
+	if res != nil {
+		t := res.ClientTime
+		e.ResponseAt = &t
+	}
+	return e
+}
+
+// buyItem の結果. 応答が無かったものは時刻が範囲になる
+type buyRecord struct {
+	ItemID      int    `json:"item_id"`
+	CountBought int    `json:"count_bought"`
+	Result      string `json:"result"`
+	TimeMin     int64  `json:"time_min"`
+	TimeMax     int64  `json:"time_max"`
+}
+
+var validationReportStore struct {
+	mtx    sync.Mutex
+	report *validationReport
+}
+
+// 最初に失敗したものだけ残す
+func saveValidationReport(r *validationReport) {
+	validationReportStore.mtx.Lock()
+	if validationReportStore.report == nil {
+		validationReportStore.report = r
+	}
+	validationReportStore.mtx.Unlock()
+}
+
+func getValidationReport() *validationReport {
+	validationReportStore.mtx.Lock()
+	defer validationReportStore.mtx.Unlock()
+	return validationReportStore.report
+}
+
+func clearValidationReport() {
+	validationReportStore.mtx.Lock()
+	validationReportStore.report = nil
+	validationReportStore.mtx.Unlock()
+}
+
+func writeValidationReport(resultPath string, r *validationReport) error {
+	base := strings.TrimSuffix(resultPath, filepath.Ext(resultPath)) + "-validation"
+
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(base+".json", b, 0644)
+	if err != nil {
+		return err
+	}
+
+	status, err := json.MarshalIndent(r.Status, "", "  ")
+	if err != nil {
+		return err
+	}
+	expected, err := json.MarshalIndent(r.Expected, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(base + ".html")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return validationReportTemplate.Execute(f, struct {
+		*validationReport
+		StatusJSON   string
+		ExpectedJSON string
+	}{r, string(status), string(expected)})
+}
+
+var validationReportTemplate = template.Must(template.New("validation").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>validation report {{.Room}}</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 2px 8px; text-align: left; }
+tr.client { background: #ffd; }
+.columns { display: flex; }
+.columns > div { flex: 1; }
+pre { font-size: 12px; }
+</style>
+</head>
+<body>
+<h1>ゲームログの検証に失敗しました</h1>
+<table>
+<tr><th>room</th><td>{{.Room}}</td></tr>
+<tr><th>エラー</th><td>{{.Message}}</td></tr>
+<tr><th>client_id</th><td>{{.ClientID}}</td></tr>
+<tr><th>受信時刻</th><td>{{.ClientTime.Format "15:04:05.000"}}</td></tr>
+<tr><th>応答の無い request</th><td>{{range .NoResponse}}{{.}} {{end}}</td></tr>
+</table>
+
+<div class="columns">
+<div>
+<h2>status</h2>
+<pre>{{.StatusJSON}}</pre>
+</div>
+<div>
+<h2>期待値</h2>
+<pre>{{.ExpectedJSON}}</pre>
+</div>
+</div>
+
+<h2>前後の request</h2>
+<table>
+<tr><th>request_id</th><th>client_id</th><th>action</th><th>time</th><th>isu</th><th>item_id</th><th>count_bought</th><th>送信</th><th>受信</th><th>結果</th></tr>
+{{$client := .ClientID}}{{range .Trace}}<tr{{if eq .ClientID $client}} class="client"{{end}}><td>{{.RequestID}}</td><td>{{.ClientID}}</td><td>{{.Action}}</td><td>{{.Time}}</td><td>{{.Isu}}</td><td>{{if eq .Action "buyItem"}}{{.ItemID}}{{end}}</td><td>{{if eq .Action "buyItem"}}{{.CountBought}}{{end}}</td><td>{{.RequestAt.Format "15:04:05.000"}}</td><td>{{if .ResponseAt}}{{.ResponseAt.Format "15:04:05.000"}}{{end}}</td><td>{{.Result}}{{if .Chaos}} ({{.Chaos}}){{end}}</td></tr>
+{{end}}</table>
+
+<h2>購入履歴</h2>
+<table>
+<tr><th>item_id</th><th>count_bought</th><th>結果</th><th>time</th></tr>
+{{range .BuyHistory}}<tr><td>{{.ItemID}}</td><td>{{.CountBought}}</td><td>{{.Result}}</td><td>{{if eq .TimeMin .TimeMax}}{{.TimeMin}}{{else}}{{.TimeMin}} - {{.TimeMax}}{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -32,3 +32,10 @@ CREATE TABLE `adding` (
   PRIMARY KEY (`room_name`,`time`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
 
+
+CREATE TABLE `milestone` (
+  `room_name` varchar(191) COLLATE utf8mb4_bin NOT NULL,
+  `milestone_id` varchar(191) COLLATE utf8mb4_bin NOT NULL,
+  `time` bigint(20) NOT NULL,
+  PRIMARY KEY (`room_name`,`milestone_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
@@ -0,0 +1,432 @@
+// Package exponential は GameStatus で使う10進数の指数表記 [仮数部, 指数部] を扱う。
+//
+// webapp とベンチマーカーの双方から使われるので、標準ライブラリ以外に依存しないこと。
+//
+// 正規化された値は以下のいずれかになる。
+//
+//   - |値| < 10^15 の場合 {値, 0}
+//   - それ以外の場合 10^14 <= |Mantissa| < 10^15, 0 < Exponent
+//
+// 15桁に収まらない値は 0 方向に切り捨てる。FromBig, Parse, Add, Mul はすべてこの規則で丸める。
+package exponential
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"sync"
+)
+
+// 仮数部の桁数
+const Digits = 15
+
+const (
+	mantissaMax = 1000000000000000 // 10^15
+	mantissaMin = 100000000000000  // 10^14
+)
+
+// Mantissa * 10 ^ Exponent
+type Exponential struct {
+	Mantissa int64
+	Exponent int64
+}
+
+func (n Exponential) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("[%d,%d]", n.Mantissa, n.Exponent)), nil
+}
+
+func (n *Exponential) UnmarshalJSON(b []byte) error {
+	var data []int64
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if len(data) != 2 {
+		return errors.New("invalid length Exponential")
+	}
+	n.Mantissa = data[0]
+	n.Exponent = data[1]
+	return nil
+}
+
+var (
+	big10    = big.NewInt(10)
+	pow10Mtx sync.Mutex
+	pow10Map = map[int64]*big.Int{}
+)
+
+func pow10(i int64) *big.Int {
+	pow10Mtx.Lock()
+	e, ok := pow10Map[i]
+	pow10Mtx.Unlock()
+	if ok {
+		return e
+	}
+
+	e = new(big.Int).Exp(big10, big.NewInt(i), nil)
+
+	pow10Mtx.Lock()
+	pow10Map[i] = e
+	pow10Mtx.Unlock()
+	return e
+}
+
+// n * 10^exp を正規化する。n は変更しない。
+func normalize(n *big.Int, exp int64) Exponential {
+	if n.IsInt64() {
+		v := n.Int64()
+		if -mantissaMax < v && v < mantissaMax {
+			if exp == 0 || v == 0 {
+				return Exponential{v, 0}
+			}
+			// 仮数部を 15 桁まで伸ばせるだけ伸ばす
+			for exp > 0 && -mantissaMin < v && v < mantissaMin {
+				v *= 10
+				exp--
+			}
+			return Exponential{v, exp}
+		}
+	}
+
+	sign := n.Sign()
+	abs := new(big.Int).Abs(n)
+
+	// 桁数の見積もりは小さめに取り、ループで合わせる
+	x := int64(math.Floor(float64(abs.BitLen()-1)*0.301029995663981 + 1))
+	i := x - 17
+	if i <= 0 {
+		i = 1
+	}
+
+	t := new(big.Int).Quo(abs, pow10(i))
+	for {
+		if t.IsInt64() {
+			y := t.Int64()
+			if y < mantissaMax {
+				if y < mantissaMin {
+					panic(fmt.Sprintf("exponential: normalize failed %v", n))
+				}
+				if sign < 0 {
+					y = -y
+				}
+				return Exponential{y, exp + i}
+			}
+		}
+		t.Quo(t, big10)
+		i++
+	}
+}
+
+// 多倍長整数を正規化された Exponential に変換する
+func FromBig(n *big.Int) Exponential {
+	if n == nil {
+		panic("exponential: FromBig nil")
+	}
+	return normalize(n, 0)
+}
+
+func FromInt64(v int64) Exponential {
+	return normalize(big.NewInt(v), 0)
+}
+
+// 値を多倍長整数で返す
+func (n Exponential) ToBig() *big.Int {
+	x := big.NewInt(n.Mantissa)
+	if n.Exponent > 0 {
+		x.Mul(x, pow10(n.Exponent))
+	}
+	return x
+}
+
+// 正規化された値を返す
+func (n Exponential) Normalize() Exponential {
+	if n.Exponent < 0 {
+		return normalize(new(big.Int).Quo(big.NewInt(n.Mantissa), pow10(-n.Exponent)), 0)
+	}
+	return normalize(big.NewInt(n.Mantissa), n.Exponent)
+}
+
+func (n Exponential) IsZero() bool {
+	return n.Mantissa == 0
+}
+
+func (n Exponential) Sign() int {
+	switch {
+	case n.Mantissa < 0:
+		return -1
+	case n.Mantissa > 0:
+		return 1
+	}
+	return 0
+}
+
+func (n Exponential) Neg() Exponential {
+	return Exponential{-n.Mantissa, n.Exponent}
+}
+
+// n と o を比較し -1, 0, 1 を返す。正規化されていない値も比較できる。
+func (n Exponential) Cmp(o Exponential) int {
+	n, o = n.Normalize(), o.Normalize()
+	if n.Sign() != o.Sign() {
+		if n.Sign() < o.Sign() {
+			return -1
+		}
+		return 1
+	}
+	r := 0
+	if n.Exponent != o.Exponent {
+		if n.Exponent < o.Exponent {
+			r = -1
+		} else {
+			r = 1
+		}
+	} else if n.Mantissa != o.Mantissa {
+		if n.Mantissa < o.Mantissa {
+			return -1
+		}
+		return 1
+	}
+	if n.Sign() < 0 {
+		return -r
+	}
+	return r
+}
+
+// ==
+func (n Exponential) Eq(o Exponential) bool {
+	return n.Cmp(o) == 0
+}
+
+// <
+func (n Exponential) Less(o Exponential) bool {
+	return n.Cmp(o) < 0
+}
+
+// <=
+func (n Exponential) LessEq(o Exponential) bool {
+	return n.Cmp(o) <= 0
+}
+
+// 正確な和を 0 方向に切り捨てて返す
+func (n Exponential) Add(o Exponential) Exponential {
+	n, o = n.Normalize(), o.Normalize()
+	if n.Exponent < o.Exponent {
+		n, o = o, n
+	}
+	if o.IsZero() {
+		return n
+	}
+
+	// 指数部の差が大きい場合、小さい方は丸めの方向にしか影響しないので
+	// 符号だけを残した値 (sticky) に置き換える
+	const guard = 40
+	d := n.Exponent - o.Exponent
+	base := o.Exponent
+	small := big.NewInt(o.Mantissa)
+	if d > guard {
+		base = n.Exponent - guard
+		small.SetInt64(int64(o.Sign()))
+		d = guard
+	}
+
+	x := new(big.Int).Mul(big.NewInt(n.Mantissa), pow10(d))
+	x.Add(x, small)
+	return normalize(x, base)
+}
+
+func (n Exponential) Sub(o Exponential) Exponential {
+	return n.Add(o.Neg())
+}
+
+// 正確な積を 0 方向に切り捨てて返す
+func (n Exponential) Mul(o Exponential) Exponential {
+	n, o = n.Normalize(), o.Normalize()
+	x := new(big.Int).Mul(big.NewInt(n.Mantissa), big.NewInt(o.Mantissa))
+	return normalize(x, n.Exponent+o.Exponent)
+}
+
+// 10進数の整数 ("12345")、または指数表記 ("1.2345e4", "12e+3") を読み込む。
+// 小数部は 0 方向に切り捨てる。
+func Parse(s string) (Exponential, error) {
+	orig := s
+	invalid := func() (Exponential, error) {
+		return Exponential{}, fmt.Errorf("exponential: invalid number %q", orig)
+	}
+
+	neg := false
+	if strings.HasPrefix(s, "-") {
+		neg = true
+		s = s[1:]
+	} else if strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+
+	var exp int64
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		es := s[i+1:]
+		s = s[:i]
+		eneg := false
+		if strings.HasPrefix(es, "-") {
+			eneg = true
+			es = es[1:]
+		} else if strings.HasPrefix(es, "+") {
+			es = es[1:]
+		}
+		if es == "" || !isDigits(es) || len(es) > 18 {
+			return invalid()
+		}
+		for _, c := range es {
+			exp = exp*10 + int64(c-'0')
+		}
+		if eneg {
+			exp = -exp
+		}
+	}
+
+	intPart, fracPart := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, fracPart = s[:i], s[i+1:]
+	}
+	if intPart == "" && fracPart == "" {
+		return invalid()
+	}
+	if !isDigits(intPart) || !isDigits(fracPart) {
+		return invalid()
+	}
+
+	digits := strings.TrimLeft(intPart+fracPart, "0")
+	exp -= int64(len(fracPart))
+	if digits == "" {
+		return Exponential{}, nil
+	}
+
+	// 仮数部として必要な分以外の桁は切り捨てても結果は変わらない
+	if len(digits) > Digits+2 {
+		exp += int64(len(digits) - (Digits + 2))
+		digits = digits[:Digits+2]
+	}
+
+	x, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return invalid()
+	}
+	if neg {
+		x.Neg(x)
+	}
+
+	if exp < 0 {
+		if -exp > int64(len(digits)) {
+			return Exponential{}, nil
+		}
+		x.Quo(x, pow10(-exp))
+		exp = 0
+	}
+	return normalize(x, exp), nil
+}
+
+// Parse と同じだが失敗すると panic する
+func MustParse(s string) Exponential {
+	e, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || '9' < c {
+			return false
+		}
+	}
+	return true
+}
+
+// 仮数部の10進表記 (符号なし, 末尾の0を除く) と、値の10進数での桁数を返す
+func (n Exponential) digits() (string, int64) {
+	m := n.Mantissa
+	if m < 0 {
+		m = -m
+	}
+	s := fmt.Sprint(m)
+	l := int64(len(s)) + n.Exponent
+	return strings.TrimRight(s, "0"), l
+}
+
+// 値を欠損なく文字列にする。15桁に収まる値は整数のまま、それ以外は "1.23456789012345e20" の形式になる。
+// Parse で元の値に戻る。
+func (n Exponential) String() string {
+	n = n.Normalize()
+	if n.Exponent == 0 {
+		return fmt.Sprint(n.Mantissa)
+	}
+	return n.Format(Digits)
+}
+
+// 有効数字 prec 桁 (切り捨て) の指数表記 "1.23e456" にする。prec 桁以下の整数はそのまま返す。
+func (n Exponential) Format(prec int) string {
+	if prec < 1 {
+		prec = 1
+	}
+	n = n.Normalize()
+	if n.IsZero() {
+		return "0"
+	}
+
+	sign := ""
+	if n.Mantissa < 0 {
+		sign = "-"
+	}
+	ds, l := n.digits()
+	if l <= int64(prec) && n.Exponent == 0 {
+		return fmt.Sprint(n.Mantissa)
+	}
+	if len(ds) > prec {
+		ds = ds[:prec]
+	}
+	ds = strings.TrimRight(ds, "0")
+	if len(ds) <= 1 {
+		return fmt.Sprintf("%s%se%d", sign, ds, l-1)
+	}
+	return fmt.Sprintf("%s%s.%se%d", sign, ds[:1], ds[1:], l-1)
+}
+
+var siSuffixes = []string{"", "k", "M", "G", "T", "P", "E", "Z", "Y"}
+
+// SI接頭辞を使った有効数字 prec 桁 (切り捨て) の表記 "123k", "1.23M" にする。
+// 10^27 以上の値は Format と同じ表記になる。
+func (n Exponential) FormatSI(prec int) string {
+	if prec < 1 {
+		prec = 1
+	}
+	n = n.Normalize()
+	if n.IsZero() {
+		return "0"
+	}
+
+	ds, l := n.digits()
+	group := (l - 1) / 3
+	if group >= int64(len(siSuffixes)) {
+		return n.Format(prec)
+	}
+
+	sign := ""
+	if n.Mantissa < 0 {
+		sign = "-"
+	}
+	intLen := int(l - group*3)
+	if len(ds) > prec {
+		ds = ds[:prec]
+	}
+	for len(ds) < intLen {
+		ds += "0"
+	}
+	intPart, fracPart := ds[:intLen], strings.TrimRight(ds[intLen:], "0")
+	if fracPart == "" {
+		return sign + intPart + siSuffixes[group]
+	}
+	return sign + intPart + "." + fracPart + siSuffixes[group]
+}
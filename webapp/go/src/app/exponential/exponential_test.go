@@ -0,0 +1,220 @@
+package exponential
+
+import (
+	"encoding/json"
+	"math/big"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func str2big(s string) *big.Int {
+	x, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		panic(s)
+	}
+	return x
+}
+
+func randomBig(r *rand.Rand) *big.Int {
+	n := r.Intn(60) + 1
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte('0' + r.Intn(10))
+	}
+	x := str2big(string(b))
+	if r.Intn(4) == 0 {
+		x.Neg(x)
+	}
+	return x
+}
+
+func TestFromBig(t *testing.T) {
+	for _, c := range []struct {
+		in  string
+		out Exponential
+	}{
+		{"0", Exponential{0, 0}},
+		{"1234", Exponential{1234, 0}},
+		{"999999999999999", Exponential{999999999999999, 0}},
+		{"1000000000000000", Exponential{100000000000000, 1}},
+		{"11111111111111000000", Exponential{111111111111110, 5}},
+		{"1234567890123456789", Exponential{123456789012345, 4}},
+		{"-1234567890123456789", Exponential{-123456789012345, 4}},
+	} {
+		if got := FromBig(str2big(c.in)); got != c.out {
+			t.Errorf("FromBig(%v) = %v, want %v", c.in, got, c.out)
+		}
+	}
+}
+
+// 文字列比較で桁を切り捨てた値と一致すること
+func TestFromBigRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		x := randomBig(r)
+		s := new(big.Int).Abs(x).String()
+		want := Exponential{}
+		if len(s) <= Digits {
+			want.Mantissa = x.Int64()
+		} else {
+			want.Mantissa = str2big(s[:Digits]).Int64()
+			want.Exponent = int64(len(s) - Digits)
+			if x.Sign() < 0 {
+				want.Mantissa = -want.Mantissa
+			}
+		}
+		if got := FromBig(x); got != want {
+			t.Fatalf("FromBig(%v) = %v, want %v", x, got, want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, c := range []struct {
+		in  string
+		out Exponential
+	}{
+		{"0", Exponential{0, 0}},
+		{"000", Exponential{0, 0}},
+		{"42", Exponential{42, 0}},
+		{"-42", Exponential{-42, 0}},
+		{"+42", Exponential{42, 0}},
+		{"1e3", Exponential{1000, 0}},
+		{"1.5e3", Exponential{1500, 0}},
+		{"1.9", Exponential{1, 0}},
+		{"-1.9", Exponential{-1, 0}},
+		{"0.5", Exponential{0, 0}},
+		{"1e-3", Exponential{0, 0}},
+		{"12345e-2", Exponential{123, 0}},
+		{"1e15", Exponential{100000000000000, 1}},
+		{"1.23e456", Exponential{123000000000000, 442}},
+		{"1.23E+456", Exponential{123000000000000, 442}},
+		{"11111111111111000000", Exponential{111111111111110, 5}},
+		{"1" + strings.Repeat("9", 100), Exponential{199999999999999, 86}},
+	} {
+		got, err := Parse(c.in)
+		if err != nil {
+			t.Errorf("Parse(%q) error %v", c.in, err)
+			continue
+		}
+		if got != c.out {
+			t.Errorf("Parse(%q) = %v, want %v", c.in, got, c.out)
+		}
+	}
+
+	for _, s := range []string{"", "-", "e3", "1e", "1.2.3", "abc", "1e3.5", "0x10", "1 2"} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Parse(%q) should fail", s)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 10000; i++ {
+		e := FromBig(randomBig(r))
+
+		got, err := Parse(e.String())
+		if err != nil || got != e {
+			t.Fatalf("Parse(%q) = %v, %v want %v", e.String(), got, err, e)
+		}
+
+		b, err := json.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var d Exponential
+		if err := json.Unmarshal(b, &d); err != nil || d != e {
+			t.Fatalf("json round trip %s = %v, %v want %v", b, d, err, e)
+		}
+
+		if got := FromBig(e.ToBig()); got != e {
+			t.Fatalf("FromBig(ToBig(%v)) = %v", e, got)
+		}
+	}
+}
+
+func TestCmp(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	for i := 0; i < 10000; i++ {
+		x, y := randomBig(r), randomBig(r)
+		a, b := FromBig(x), FromBig(y)
+		want := a.ToBig().Cmp(b.ToBig())
+		if got := a.Cmp(b); got != want {
+			t.Fatalf("%v.Cmp(%v) = %v, want %v", a, b, got, want)
+		}
+		if a.Less(b) != (want < 0) || a.LessEq(b) != (want <= 0) || a.Eq(b) != (want == 0) {
+			t.Fatalf("%v, %v: Less/LessEq/Eq mismatch", a, b)
+		}
+	}
+
+	// 正規化されていない値
+	if (Exponential{1500, 0}).Cmp(Exponential{15, 2}) != 0 {
+		t.Error("1500 != 15e2")
+	}
+	if !(Exponential{1, 20}).Less(Exponential{2, 20}) {
+		t.Error("1e20 >= 2e20")
+	}
+}
+
+func TestAddMul(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	for i := 0; i < 10000; i++ {
+		a, b := FromBig(randomBig(r)), FromBig(randomBig(r))
+		x, y := a.ToBig(), b.ToBig()
+
+		if got, want := a.Add(b), FromBig(new(big.Int).Add(x, y)); got != want {
+			t.Fatalf("%v.Add(%v) = %v, want %v", a, b, got, want)
+		}
+		if got, want := a.Sub(b), FromBig(new(big.Int).Sub(x, y)); got != want {
+			t.Fatalf("%v.Sub(%v) = %v, want %v", a, b, got, want)
+		}
+		if got, want := a.Mul(b), FromBig(new(big.Int).Mul(x, y)); got != want {
+			t.Fatalf("%v.Mul(%v) = %v, want %v", a, b, got, want)
+		}
+	}
+
+	// 指数部の差が大きい場合
+	big1 := Exponential{100000000000000, 1000}
+	if got := big1.Add(Exponential{1, 0}); got != big1 {
+		t.Errorf("1e1014 + 1 = %v", got)
+	}
+	if got := big1.Sub(Exponential{1, 0}); got != (Exponential{999999999999999, 999}) {
+		t.Errorf("1e1014 - 1 = %v", got)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	for _, c := range []struct {
+		in   Exponential
+		prec int
+		out  string
+		si   string
+	}{
+		{Exponential{0, 0}, 3, "0", "0"},
+		{Exponential{7, 0}, 3, "7", "7"},
+		{Exponential{999, 0}, 3, "999", "999"},
+		{Exponential{1234, 0}, 3, "1.23e3", "1.23k"},
+		{Exponential{1000, 0}, 3, "1e3", "1k"},
+		{Exponential{-1234567, 0}, 3, "-1.23e6", "-1.23M"},
+		{Exponential{123456789012345, 7}, 3, "1.23e21", "1.23Z"},
+		{Exponential{123456789012345, 7}, 1, "1e21", "1Z"},
+		{Exponential{456789012345678, 10}, 2, "4.5e24", "4.5Y"},
+		{Exponential{123456789012345, 442}, 3, "1.23e456", "1.23e456"},
+	} {
+		if got := c.in.Format(c.prec); got != c.out {
+			t.Errorf("%v.Format(%v) = %q, want %q", c.in, c.prec, got, c.out)
+		}
+		if got := c.in.FormatSI(c.prec); got != c.si {
+			t.Errorf("%v.FormatSI(%v) = %q, want %q", c.in, c.prec, got, c.si)
+		}
+	}
+
+	if s := (Exponential{123456789012345, 7}).String(); s != "1.23456789012345e21" {
+		t.Errorf("String() = %q", s)
+	}
+	if s := (Exponential{1234, 0}).String(); s != "1234" {
+		t.Errorf("String() = %q", s)
+	}
+}
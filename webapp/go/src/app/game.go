@@ -1,11 +1,12 @@
 package main
 
 import (
+	"app/exponential"
+	"app/game"
 	"context"
 	"fmt"
 	"log"
 	"math/big"
-	"strconv"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -32,100 +33,19 @@ type GameResponse struct {
 }
 
 // 10進数の指数表記に使うデータ。JSONでは [仮数部, 指数部] という2要素配列になる。
-type Exponential struct {
-	// Mantissa * 10 ^ Exponent
-	Mantissa int64
-	Exponent int64
-}
-
-func (n Exponential) MarshalJSON() ([]byte, error) {
-	return []byte(fmt.Sprintf("[%d,%d]", n.Mantissa, n.Exponent)), nil
-}
-
-type Adding struct {
-	RoomName string `json:"-" db:"room_name"`
-	Time     int64  `json:"time" db:"time"`
-	Isu      string `json:"isu" db:"isu"`
-}
-
-type Buying struct {
-	RoomName string `db:"room_name"`
-	ItemID   int    `db:"item_id"`
-	Ordinal  int    `db:"ordinal"`
-	Time     int64  `db:"time"`
-}
-
-type Schedule struct {
-	Time       int64       `json:"time"`
-	MilliIsu   Exponential `json:"milli_isu"`
-	TotalPower Exponential `json:"total_power"`
-}
-
-type Item struct {
-	ItemID      int         `json:"item_id"`
-	CountBought int         `json:"count_bought"`
-	CountBuilt  int         `json:"count_built"`
-	NextPrice   Exponential `json:"next_price"`
-	Power       Exponential `json:"power"`
-	Building    []Building  `json:"building"`
-}
-
-type OnSale struct {
-	ItemID int   `json:"item_id"`
-	Time   int64 `json:"time"`
-}
-
-type Building struct {
-	Time       int64       `json:"time"`
-	CountBuilt int         `json:"count_built"`
-	Power      Exponential `json:"power"`
-}
-
-type GameStatus struct {
-	Time     int64      `json:"time"`
-	Adding   []Adding   `json:"adding"`
-	Schedule []Schedule `json:"schedule"`
-	Items    []Item     `json:"items"`
-	OnSale   []OnSale   `json:"on_sale"`
-}
-
-type mItem struct {
-	ItemID int   `db:"item_id"`
-	Power1 int64 `db:"power1"`
-	Power2 int64 `db:"power2"`
-	Power3 int64 `db:"power3"`
-	Power4 int64 `db:"power4"`
-	Price1 int64 `db:"price1"`
-	Price2 int64 `db:"price2"`
-	Price3 int64 `db:"price3"`
-	Price4 int64 `db:"price4"`
-}
-
-func (item *mItem) GetPower(count int) *big.Int {
-	// power(x):=(cx+1)*d^(ax+b)
-	a := item.Power1
-	b := item.Power2
-	c := item.Power3
-	d := item.Power4
-	x := int64(count)
-
-	s := big.NewInt(c*x + 1)
-	t := new(big.Int).Exp(big.NewInt(d), big.NewInt(a*x+b), nil)
-	return new(big.Int).Mul(s, t)
-}
-
-func (item *mItem) GetPrice(count int) *big.Int {
-	// price(x):=(cx+1)*d^(ax+b)
-	a := item.Price1
-	b := item.Price2
-	c := item.Price3
-	d := item.Price4
-	x := int64(count)
-
-	s := big.NewInt(c*x + 1)
-	t := new(big.Int).Exp(big.NewInt(d), big.NewInt(a*x+b), nil)
-	return new(big.Int).Mul(s, t)
-}
+type Exponential = exponential.Exponential
+
+// ゲームのルールは app/game にある
+type (
+	Adding     = game.Adding
+	Buying     = game.Buying
+	Schedule   = game.Schedule
+	Item       = game.Item
+	OnSale     = game.OnSale
+	Building   = game.Building
+	GameStatus = game.GameStatus
+	mItem      = game.MItem
+)
 
 func str2big(s string) *big.Int {
 	x := new(big.Int)
@@ -134,17 +54,7 @@ func str2big(s string) *big.Int {
 }
 
 func big2exp(n *big.Int) Exponential {
-	s := n.String()
-
-	if len(s) <= 15 {
-		return Exponential{n.Int64(), 0}
-	}
-
-	t, err := strconv.ParseInt(s[:15], 10, 64)
-	if err != nil {
-		log.Panic(err)
-	}
-	return Exponential{t, int64(len(s) - 15)}
+	return exponential.FromBig(n)
 }
 
 func getCurrentTime() (int64, error) {
@@ -385,168 +295,52 @@ func getStatus(roomName string) (*GameStatus, error) {
 }
 
 func calcStatus(currentTime int64, mItems map[int]mItem, addings []Adding, buyings []Buying) (*GameStatus, error) {
-	var (
-		// 1ミリ秒に生産できる椅子の単位をミリ椅子とする
-		totalMilliIsu = big.NewInt(0)
-		totalPower    = big.NewInt(0)
-
-		itemPower    = map[int]*big.Int{}    // ItemID => Power
-		itemPrice    = map[int]*big.Int{}    // ItemID => Price
-		itemOnSale   = map[int]int64{}       // ItemID => OnSale
-		itemBuilt    = map[int]int{}         // ItemID => BuiltCount
-		itemBought   = map[int]int{}         // ItemID => CountBought
-		itemBuilding = map[int][]Building{}  // ItemID => Buildings
-		itemPower0   = map[int]Exponential{} // ItemID => currentTime における Power
-		itemBuilt0   = map[int]int{}         // ItemID => currentTime における BuiltCount
-
-		addingAt = map[int64]Adding{}   // Time => currentTime より先の Adding
-		buyingAt = map[int64][]Buying{} // Time => currentTime より先の Buying
-	)
-
-	for itemID := range mItems {
-		itemPower[itemID] = big.NewInt(0)
-		itemBuilding[itemID] = []Building{}
-	}
+	return game.CalcStatus(currentTime, mItems, addings, buyings), nil
+}
 
-	for _, a := range addings {
-		// adding は adding.time に isu を増加させる
-		if a.Time <= currentTime {
-			totalMilliIsu.Add(totalMilliIsu, new(big.Int).Mul(str2big(a.Isu), big.NewInt(1000)))
-		} else {
-			addingAt[a.Time] = a
-		}
-	}
+func serveGameConn(ws *websocket.Conn, roomName string, notifyMilestone bool) {
+	log.Println(ws.RemoteAddr(), "serveGameConn", roomName)
+	defer ws.Close()
 
-	for _, b := range buyings {
-		// buying は 即座に isu を消費し buying.time からアイテムの効果を発揮する
-		itemBought[b.ItemID]++
-		m := mItems[b.ItemID]
-		totalMilliIsu.Sub(totalMilliIsu, new(big.Int).Mul(m.GetPrice(b.Ordinal), big.NewInt(1000)))
-
-		if b.Time <= currentTime {
-			itemBuilt[b.ItemID]++
-			power := m.GetPower(itemBought[b.ItemID])
-			totalMilliIsu.Add(totalMilliIsu, new(big.Int).Mul(power, big.NewInt(currentTime-b.Time)))
-			totalPower.Add(totalPower, power)
-			itemPower[b.ItemID].Add(itemPower[b.ItemID], power)
-		} else {
-			buyingAt[b.Time] = append(buyingAt[b.Time], b)
-		}
+	mt, unlocked, err := newMilestoneTracker(roomName)
+	if err != nil {
+		log.Println(err)
+		return
 	}
-
-	for _, m := range mItems {
-		itemPower0[m.ItemID] = big2exp(itemPower[m.ItemID])
-		itemBuilt0[m.ItemID] = itemBuilt[m.ItemID]
-		price := m.GetPrice(itemBought[m.ItemID] + 1)
-		itemPrice[m.ItemID] = price
-		if 0 <= totalMilliIsu.Cmp(new(big.Int).Mul(price, big.NewInt(1000))) {
-			itemOnSale[m.ItemID] = 0 // 0 は 時刻 currentTime で購入可能であることを表す
+	if notifyMilestone && len(unlocked) > 0 {
+		err = ws.WriteJSON(MilestoneEvent{Type: "milestone", Milestones: unlocked})
+		if err != nil {
+			log.Println(err)
+			return
 		}
 	}
 
-	schedule := []Schedule{
-		Schedule{
-			Time:       currentTime,
-			MilliIsu:   big2exp(totalMilliIsu),
-			TotalPower: big2exp(totalPower),
-		},
-	}
-
-	// currentTime から 1000 ミリ秒先までシミュレーションする
-	for t := currentTime + 1; t <= currentTime+1000; t++ {
-		totalMilliIsu.Add(totalMilliIsu, totalPower)
-		updated := false
-
-		// 時刻 t で発生する adding を計算する
-		if a, ok := addingAt[t]; ok {
-			updated = true
-			totalMilliIsu.Add(totalMilliIsu, new(big.Int).Mul(str2big(a.Isu), big.NewInt(1000)))
+	// GameStatus を送信した後に その時点で新たに達成した実績を送る
+	writeStatus := func(status *GameStatus) error {
+		err := ws.WriteJSON(status)
+		if err != nil {
+			return err
 		}
 
-		// 時刻 t で発生する buying を計算する
-		if _, ok := buyingAt[t]; ok {
-			updated = true
-			updatedID := map[int]bool{}
-			for _, b := range buyingAt[t] {
-				m := mItems[b.ItemID]
-				updatedID[b.ItemID] = true
-				itemBuilt[b.ItemID]++
-				power := m.GetPower(b.Ordinal)
-				itemPower[b.ItemID].Add(itemPower[b.ItemID], power)
-				totalPower.Add(totalPower, power)
-			}
-			for id := range updatedID {
-				itemBuilding[id] = append(itemBuilding[id], Building{
-					Time:       t,
-					CountBuilt: itemBuilt[id],
-					Power:      big2exp(itemPower[id]),
-				})
-			}
-		}
+		ranking.Update(roomName, status)
 
-		if updated {
-			schedule = append(schedule, Schedule{
-				Time:       t,
-				MilliIsu:   big2exp(totalMilliIsu),
-				TotalPower: big2exp(totalPower),
-			})
+		unlocked, err := mt.update(status)
+		if err != nil {
+			return err
 		}
-
-		// 時刻 t で購入可能になったアイテムを記録する
-		for itemID := range mItems {
-			if _, ok := itemOnSale[itemID]; ok {
-				continue
-			}
-			if 0 <= totalMilliIsu.Cmp(new(big.Int).Mul(itemPrice[itemID], big.NewInt(1000))) {
-				itemOnSale[itemID] = t
-			}
+		if notifyMilestone && len(unlocked) > 0 {
+			return ws.WriteJSON(MilestoneEvent{Type: "milestone", Milestones: unlocked})
 		}
+		return nil
 	}
 
-	gsAdding := []Adding{}
-	for _, a := range addingAt {
-		gsAdding = append(gsAdding, a)
-	}
-
-	gsItems := []Item{}
-	for itemID, _ := range mItems {
-		gsItems = append(gsItems, Item{
-			ItemID:      itemID,
-			CountBought: itemBought[itemID],
-			CountBuilt:  itemBuilt0[itemID],
-			NextPrice:   big2exp(itemPrice[itemID]),
-			Power:       itemPower0[itemID],
-			Building:    itemBuilding[itemID],
-		})
-	}
-
-	gsOnSale := []OnSale{}
-	for itemID, t := range itemOnSale {
-		gsOnSale = append(gsOnSale, OnSale{
-			ItemID: itemID,
-			Time:   t,
-		})
-	}
-
-	return &GameStatus{
-		Adding:   gsAdding,
-		Schedule: schedule,
-		Items:    gsItems,
-		OnSale:   gsOnSale,
-	}, nil
-}
-
-func serveGameConn(ws *websocket.Conn, roomName string) {
-	log.Println(ws.RemoteAddr(), "serveGameConn", roomName)
-	defer ws.Close()
-
 	status, err := getStatus(roomName)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
-	err = ws.WriteJSON(status)
+	err = writeStatus(status)
 	if err != nil {
 		log.Println(err)
 		return
@@ -602,7 +396,7 @@ func serveGameConn(ws *websocket.Conn, roomName string) {
 					return
 				}
 
-				err = ws.WriteJSON(status)
+				err = writeStatus(status)
 				if err != nil {
 					log.Println(err)
 					return
@@ -624,7 +418,7 @@ func serveGameConn(ws *websocket.Conn, roomName string) {
 				return
 			}
 
-			err = ws.WriteJSON(status)
+			err = writeStatus(status)
 			if err != nil {
 				log.Println(err)
 				return
@@ -0,0 +1,111 @@
+// Package game はゲームのルール (adding, buying, 生産力, on_sale, schedule) の参照実装。
+//
+// webapp の GameStatus の計算と、ベンチマーカーのゲームログの検証はどちらもこのパッケージを使う。
+// DB やネットワークには触らない。
+package game
+
+import (
+	"app/exponential"
+	"math/big"
+)
+
+// 10進数の指数表記に使うデータ。JSONでは [仮数部, 指数部] という2要素配列になる。
+type Exponential = exponential.Exponential
+
+type Adding struct {
+	RoomName string `json:"-" db:"room_name"`
+	Time     int64  `json:"time" db:"time"`
+	Isu      string `json:"isu" db:"isu"`
+}
+
+type Buying struct {
+	RoomName string `db:"room_name"`
+	ItemID   int    `db:"item_id"`
+	Ordinal  int    `db:"ordinal"`
+	Time     int64  `db:"time"`
+}
+
+type Schedule struct {
+	Time       int64       `json:"time"`
+	MilliIsu   Exponential `json:"milli_isu"`
+	TotalPower Exponential `json:"total_power"`
+}
+
+type Item struct {
+	ItemID      int         `json:"item_id"`
+	CountBought int         `json:"count_bought"`
+	CountBuilt  int         `json:"count_built"`
+	NextPrice   Exponential `json:"next_price"`
+	Power       Exponential `json:"power"`
+	Building    []Building  `json:"building"`
+}
+
+type OnSale struct {
+	ItemID int   `json:"item_id"`
+	Time   int64 `json:"time"`
+}
+
+type Building struct {
+	Time       int64       `json:"time"`
+	CountBuilt int         `json:"count_built"`
+	Power      Exponential `json:"power"`
+}
+
+type GameStatus struct {
+	Time     int64      `json:"time"`
+	Adding   []Adding   `json:"adding"`
+	Schedule []Schedule `json:"schedule"`
+	Items    []Item     `json:"items"`
+	OnSale   []OnSale   `json:"on_sale"`
+}
+
+// m_item の1行
+type MItem struct {
+	ItemID int   `db:"item_id"`
+	Power1 int64 `db:"power1"`
+	Power2 int64 `db:"power2"`
+	Power3 int64 `db:"power3"`
+	Power4 int64 `db:"power4"`
+	Price1 int64 `db:"price1"`
+	Price2 int64 `db:"price2"`
+	Price3 int64 `db:"price3"`
+	Price4 int64 `db:"price4"`
+}
+
+// count 個目の生産力
+func (item *MItem) GetPower(count int) *big.Int {
+	// power(x):=(cx+1)*d^(ax+b)
+	a := item.Power1
+	b := item.Power2
+	c := item.Power3
+	d := item.Power4
+	x := int64(count)
+
+	s := big.NewInt(c*x + 1)
+	t := new(big.Int).Exp(big.NewInt(d), big.NewInt(a*x+b), nil)
+	return new(big.Int).Mul(s, t)
+}
+
+// count 個目の価格
+func (item *MItem) GetPrice(count int) *big.Int {
+	// price(x):=(cx+1)*d^(ax+b)
+	a := item.Price1
+	b := item.Price2
+	c := item.Price3
+	d := item.Price4
+	x := int64(count)
+
+	s := big.NewInt(c*x + 1)
+	t := new(big.Int).Exp(big.NewInt(d), big.NewInt(a*x+b), nil)
+	return new(big.Int).Mul(s, t)
+}
+
+func str2big(s string) *big.Int {
+	x := new(big.Int)
+	x.SetString(s, 10)
+	return x
+}
+
+func big2exp(n *big.Int) Exponential {
+	return exponential.FromBig(n)
+}
@@ -0,0 +1,68 @@
+// Package gametest は app/game の GameStatus を計算するテストの入力を作る。
+//
+// webapp の calcStatus のテストと、ベンチマーカーの検証を参照実装に合わせるテストから使う。
+package gametest
+
+import (
+	"app/game"
+	"fmt"
+	"math/rand"
+)
+
+// Case は CalcStatus の入力
+type Case struct {
+	CurrentTime int64
+	MItems      map[int]game.MItem
+	Addings     []game.Adding
+	Buyings     []game.Buying
+}
+
+func (c *Case) String() string {
+	return fmt.Sprintf("currentTime=%v mItems=%+v addings=%+v buyings=%+v", c.CurrentTime, c.MItems, c.Addings, c.Buyings)
+}
+
+// GenCase はランダムな m_item, adding, buying を作る
+func GenCase(r *rand.Rand) *Case {
+	c := &Case{
+		CurrentTime: int64(r.Intn(5000)),
+		MItems:      map[int]game.MItem{},
+	}
+
+	nItems := r.Intn(5)
+	for id := 1; id <= nItems; id++ {
+		c.MItems[id] = game.MItem{
+			ItemID: id,
+			Power1: int64(r.Intn(3)), Power2: int64(r.Intn(4)), Power3: int64(r.Intn(6)), Power4: int64(r.Intn(10) + 1),
+			Price1: int64(r.Intn(3)), Price2: int64(r.Intn(4)), Price3: int64(r.Intn(6)), Price4: int64(r.Intn(10) + 1),
+		}
+	}
+
+	// adding の time はルーム内で一意 (PRIMARY KEY (room_name, time))
+	used := map[int64]bool{}
+	for i := r.Intn(20); 0 < i; i-- {
+		t := int64(r.Intn(7000))
+		if used[t] {
+			continue
+		}
+		used[t] = true
+		c.Addings = append(c.Addings, game.Adding{Time: t, Isu: genNumberString(r, r.Intn(30)+1)})
+	}
+
+	// buying は DB から (item_id, ordinal) 順で返る
+	for id := 1; id <= nItems; id++ {
+		n := r.Intn(6)
+		for ord := 1; ord <= n; ord++ {
+			c.Buyings = append(c.Buyings, game.Buying{ItemID: id, Ordinal: ord, Time: int64(r.Intn(7000))})
+		}
+	}
+	return c
+}
+
+func genNumberString(r *rand.Rand, n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte('0' + r.Intn(10))
+	}
+	b[0] = byte('1' + r.Intn(9))
+	return string(b)
+}
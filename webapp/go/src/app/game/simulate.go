@@ -0,0 +1,261 @@
+package game
+
+import (
+	"math/big"
+	"sort"
+)
+
+// GameStatus は現在時刻から何ミリ秒先までを含むか
+const Duration = 1000
+
+var big1000 = big.NewInt(1000)
+
+// State は時刻 Time における状態。ItemPower, ItemBuilt には全ての item を入れる。
+// 1ミリ秒に生産できる椅子の単位をミリ椅子とする
+type State struct {
+	Time      int64
+	MilliIsu  *big.Int
+	Power     *big.Int         // 合計の生産力
+	ItemPower map[int]*big.Int // ItemID => 生産力
+	ItemBuilt map[int]int      // ItemID => 建設済みの数
+}
+
+// Timeline はミリ椅子の推移。
+// Time[i] から次の Time[i+1] の前までは、MilliIsu[i] から1ミリ秒毎に Power[i] ずつ増える。
+// 最後の区間は Time[0]+Duration まで続く。
+type Timeline struct {
+	Time     []int64
+	MilliIsu []*big.Int
+	Power    []*big.Int
+}
+
+func (tl *Timeline) add(t int64, milliIsu, power *big.Int) {
+	tl.Time = append(tl.Time, t)
+	tl.MilliIsu = append(tl.MilliIsu, new(big.Int).Set(milliIsu))
+	tl.Power = append(tl.Power, new(big.Int).Set(power))
+}
+
+func (tl *Timeline) Schedule() []Schedule {
+	var schedule []Schedule
+	for i, t := range tl.Time {
+		schedule = append(schedule, Schedule{
+			Time:       t,
+			MilliIsu:   big2exp(tl.MilliIsu[i]),
+			TotalPower: big2exp(tl.Power[i]),
+		})
+	}
+	return schedule
+}
+
+// 全ての時刻でミリ椅子が d だけ多い Timeline
+func (tl *Timeline) Shift(d *big.Int) *Timeline {
+	res := &Timeline{Time: tl.Time, Power: tl.Power}
+	for _, x := range tl.MilliIsu {
+		res.MilliIsu = append(res.MilliIsu, new(big.Int).Add(x, d))
+	}
+	return res
+}
+
+// ミリ椅子が milliPrice 以上になる最初の時刻。Time[0] で既に足りていれば 0 を返す。
+// Time[0]+Duration までに足りなければ false を返す
+func (tl *Timeline) OnSale(milliPrice *big.Int) (int64, bool) {
+	end := tl.Time[0] + Duration + 1
+	for i, t := range tl.Time {
+		x, v := tl.MilliIsu[i], tl.Power[i]
+		if milliPrice.Cmp(x) <= 0 {
+			if i == 0 {
+				return 0, true
+			}
+			return t, true
+		}
+		if v.Sign() <= 0 {
+			continue
+		}
+
+		next := end
+		if i+1 < len(tl.Time) {
+			next = tl.Time[i+1]
+		}
+
+		// ceil((milliPrice - x) / v) ミリ秒後
+		y := new(big.Int).Sub(milliPrice, x)
+		y.Add(y, v)
+		y.Sub(y, big.NewInt(1))
+		y.Quo(y, v)
+		if y.Cmp(big.NewInt(next-t)) < 0 {
+			return t + y.Int64(), true
+		}
+	}
+	return 0, false
+}
+
+// Simulate は s から Duration ミリ秒先までを計算する。
+// adding (時刻 => 椅子の数) と buying (時刻 => その時刻に建設される buying) は s.Time より後のものだけを使う。
+// power は item の ordinal 個目の生産力を返す。s は変更しない。
+// 返り値の building には s.ItemPower の全ての item が入る。
+func Simulate(s State, adding map[int64]*big.Int, buying map[int64][]Buying, power func(itemID, ordinal int) *big.Int) (*Timeline, map[int][]Building) {
+	var (
+		milliIsu   = new(big.Int).Set(s.MilliIsu)
+		totalPower = new(big.Int).Set(s.Power)
+		itemPower  = map[int]*big.Int{}
+		itemBuilt  = map[int]int{}
+		building   = map[int][]Building{}
+	)
+	for id, p := range s.ItemPower {
+		itemPower[id] = new(big.Int).Set(p)
+		building[id] = []Building{}
+	}
+	for id, n := range s.ItemBuilt {
+		itemBuilt[id] = n
+	}
+
+	tl := new(Timeline)
+	tl.add(s.Time, milliIsu, totalPower)
+
+	inRange := map[int64]bool{}
+	for t := range adding {
+		if s.Time < t && t <= s.Time+Duration {
+			inRange[t] = true
+		}
+	}
+	for t := range buying {
+		if s.Time < t && t <= s.Time+Duration {
+			inRange[t] = true
+		}
+	}
+	var ts []int64
+	for t := range inRange {
+		ts = append(ts, t)
+	}
+	sort.Slice(ts, func(i, j int) bool { return ts[i] < ts[j] })
+
+	last := s.Time
+	for _, t := range ts {
+		milliIsu.Add(milliIsu, new(big.Int).Mul(totalPower, big.NewInt(t-last)))
+		last = t
+
+		// 時刻 t で発生する adding を計算する
+		if v, ok := adding[t]; ok {
+			milliIsu.Add(milliIsu, new(big.Int).Mul(v, big1000))
+		}
+
+		// 時刻 t で発生する buying を計算する
+		if bs, ok := buying[t]; ok {
+			updatedID := map[int]bool{}
+			for _, b := range bs {
+				p := power(b.ItemID, b.Ordinal)
+				if _, ok := itemPower[b.ItemID]; !ok {
+					itemPower[b.ItemID] = new(big.Int)
+				}
+				itemPower[b.ItemID].Add(itemPower[b.ItemID], p)
+				itemBuilt[b.ItemID]++
+				totalPower.Add(totalPower, p)
+				updatedID[b.ItemID] = true
+			}
+			for id := range updatedID {
+				building[id] = append(building[id], Building{
+					Time:       t,
+					CountBuilt: itemBuilt[id],
+					Power:      big2exp(itemPower[id]),
+				})
+			}
+		}
+
+		tl.add(t, milliIsu, totalPower)
+	}
+	return tl, building
+}
+
+// CalcStatus は時刻 currentTime の GameStatus を計算する。
+// buyings は item 毎に ordinal の順に並んでいること
+func CalcStatus(currentTime int64, mItems map[int]MItem, addings []Adding, buyings []Buying) *GameStatus {
+	var (
+		s = State{
+			Time:      currentTime,
+			MilliIsu:  new(big.Int),
+			Power:     new(big.Int),
+			ItemPower: map[int]*big.Int{},
+			ItemBuilt: map[int]int{},
+		}
+		itemBought = map[int]int{} // ItemID => CountBought
+
+		addingAt = map[int64]Adding{}   // Time => currentTime より先の Adding
+		adding   = map[int64]*big.Int{} // Time => currentTime より先の Adding の椅子の数
+		buyingAt = map[int64][]Buying{} // Time => currentTime より先の Buying
+	)
+
+	for itemID := range mItems {
+		s.ItemPower[itemID] = new(big.Int)
+	}
+
+	for _, a := range addings {
+		// adding は adding.time に isu を増加させる
+		if a.Time <= currentTime {
+			s.MilliIsu.Add(s.MilliIsu, new(big.Int).Mul(str2big(a.Isu), big1000))
+		} else {
+			addingAt[a.Time] = a
+			adding[a.Time] = str2big(a.Isu)
+		}
+	}
+
+	for _, b := range buyings {
+		// buying は 即座に isu を消費し buying.time からアイテムの効果を発揮する
+		itemBought[b.ItemID]++
+		m := mItems[b.ItemID]
+		s.MilliIsu.Sub(s.MilliIsu, new(big.Int).Mul(m.GetPrice(b.Ordinal), big1000))
+
+		if b.Time <= currentTime {
+			s.ItemBuilt[b.ItemID]++
+			power := m.GetPower(b.Ordinal)
+			s.MilliIsu.Add(s.MilliIsu, new(big.Int).Mul(power, big.NewInt(currentTime-b.Time)))
+			s.Power.Add(s.Power, power)
+			s.ItemPower[b.ItemID].Add(s.ItemPower[b.ItemID], power)
+		} else {
+			buyingAt[b.Time] = append(buyingAt[b.Time], b)
+		}
+	}
+
+	tl, building := Simulate(s, adding, buyingAt, func(itemID, ordinal int) *big.Int {
+		m := mItems[itemID]
+		return m.GetPower(ordinal)
+	})
+
+	status := &GameStatus{
+		Time:     currentTime,
+		Adding:   []Adding{},
+		Schedule: tl.Schedule(),
+		Items:    []Item{},
+		OnSale:   []OnSale{},
+	}
+
+	for _, a := range addingAt {
+		status.Adding = append(status.Adding, a)
+	}
+	sort.Slice(status.Adding, func(i, j int) bool {
+		return status.Adding[i].Time < status.Adding[j].Time
+	})
+
+	var ids []int
+	for itemID := range mItems {
+		ids = append(ids, itemID)
+	}
+	sort.Ints(ids)
+
+	for _, itemID := range ids {
+		m := mItems[itemID]
+		price := m.GetPrice(itemBought[itemID] + 1)
+		status.Items = append(status.Items, Item{
+			ItemID:      itemID,
+			CountBought: itemBought[itemID],
+			CountBuilt:  s.ItemBuilt[itemID],
+			NextPrice:   big2exp(price),
+			Power:       big2exp(s.ItemPower[itemID]),
+			Building:    building[itemID],
+		})
+		if t, ok := tl.OnSale(new(big.Int).Mul(price, big1000)); ok {
+			status.OnSale = append(status.OnSale, OnSale{ItemID: itemID, Time: t})
+		}
+	}
+
+	return status
+}
@@ -0,0 +1,151 @@
+package game
+
+import (
+	"app/proptest"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 1ミリ秒ずつ計算した時刻 t のミリ椅子
+func (tl *Timeline) milliIsuAt(t int64) *big.Int {
+	i := 0
+	for i+1 < len(tl.Time) && tl.Time[i+1] <= t {
+		i++
+	}
+	x := new(big.Int).Mul(tl.Power[i], big.NewInt(t-tl.Time[i]))
+	return x.Add(x, tl.MilliIsu[i])
+}
+
+func (tl *Timeline) onSaleNaive(milliPrice *big.Int) (int64, bool) {
+	for t := tl.Time[0]; t <= tl.Time[0]+Duration; t++ {
+		if 0 <= tl.milliIsuAt(t).Cmp(milliPrice) {
+			if t == tl.Time[0] {
+				return 0, true
+			}
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+func genTimeline(r *rand.Rand) *Timeline {
+	tl := new(Timeline)
+	t := int64(r.Intn(10000))
+	x := big.NewInt(int64(r.Intn(20000) - 10000))
+	v := big.NewInt(int64(r.Intn(30)))
+	end := t + Duration
+	for {
+		tl.add(t, x, v)
+		dt := int64(r.Intn(400) + 1)
+		if end < t+dt {
+			return tl
+		}
+		x = new(big.Int).Add(x, new(big.Int).Mul(v, big.NewInt(dt)))
+		x.Add(x, big.NewInt(int64(r.Intn(3))*1000))
+		v = new(big.Int).Add(v, big.NewInt(int64(r.Intn(10))))
+		t += dt
+	}
+}
+
+func TestTimelineOnSale(t *testing.T) {
+	assert := assert.New(t)
+
+	proptest.Seeds(t, 300, func(seed int64, r *rand.Rand) {
+		tl := genTimeline(r)
+		for i := 0; i < 10; i++ {
+			price := big.NewInt(int64(r.Intn(60000) - 10000))
+			got, gotOK := tl.OnSale(price)
+			want, wantOK := tl.onSaleNaive(price)
+			assert.Equal(wantOK, gotOK, "seed %v price %v", seed, price)
+			assert.Equal(want, got, "seed %v price %v", seed, price)
+		}
+	})
+}
+
+func TestTimelineShift(t *testing.T) {
+	assert := assert.New(t)
+
+	tl := genTimeline(rand.New(rand.NewSource(1)))
+	d := big.NewInt(12345)
+	s := tl.Shift(d)
+	for i := range tl.Time {
+		assert.Equal(new(big.Int).Add(tl.MilliIsu[i], d).String(), s.MilliIsu[i].String())
+	}
+
+	for _, price := range []int64{0, 20000, 50000} {
+		p := big.NewInt(price)
+		want, wantOK := tl.OnSale(new(big.Int).Sub(p, d))
+		got, gotOK := s.OnSale(p)
+		assert.Equal(wantOK, gotOK)
+		assert.Equal(want, got)
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	assert := assert.New(t)
+
+	m := MItem{ItemID: 1, Power1: 0, Power2: 1, Power3: 0, Power4: 10}
+	s := State{
+		Time:      1000,
+		MilliIsu:  big.NewInt(500),
+		Power:     big.NewInt(2),
+		ItemPower: map[int]*big.Int{1: big.NewInt(2), 2: big.NewInt(0)},
+		ItemBuilt: map[int]int{1: 1, 2: 0},
+	}
+	adding := map[int64]*big.Int{
+		1100: big.NewInt(3),
+		2001: big.NewInt(100), // 範囲外
+	}
+	buying := map[int64][]Buying{
+		1000: {{ItemID: 1, Ordinal: 2, Time: 1000}}, // s.Time は含まない
+		1200: {{ItemID: 1, Ordinal: 3, Time: 1200}},
+	}
+
+	tl, building := Simulate(s, adding, buying, func(itemID, ordinal int) *big.Int {
+		return m.GetPower(ordinal)
+	})
+
+	assert.Equal([]int64{1000, 1100, 1200}, tl.Time)
+	assert.Equal("500", tl.MilliIsu[0].String())
+	assert.Equal("3700", tl.MilliIsu[1].String()) // 500 + 2*100 + 3*1000
+	assert.Equal("3900", tl.MilliIsu[2].String())
+	assert.Equal("2", tl.Power[1].String())
+	assert.Equal("12", tl.Power[2].String())
+
+	assert.Equal([]Building{{Time: 1200, CountBuilt: 2, Power: Exponential{Mantissa: 12}}}, building[1])
+	assert.Equal([]Building{}, building[2])
+
+	// s は変更しない
+	assert.Equal("500", s.MilliIsu.String())
+	assert.Equal("2", s.ItemPower[1].String())
+	assert.Equal(1, s.ItemBuilt[1])
+}
+
+func TestCalcStatusOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	mItems := map[int]MItem{}
+	for id := 1; id <= 5; id++ {
+		mItems[id] = MItem{ItemID: id, Power4: 1, Price4: 1}
+	}
+	addings := []Adding{
+		{Time: 300, Isu: "1"},
+		{Time: 100, Isu: "1"},
+		{Time: 200, Isu: "1"},
+	}
+
+	s := CalcStatus(0, mItems, addings, nil)
+	assert.Equal(int64(0), s.Time)
+	for i, item := range s.Items {
+		assert.Equal(i+1, item.ItemID)
+	}
+	for i, a := range s.Adding {
+		assert.Equal(int64(i+1)*100, a.Time)
+	}
+	for i, o := range s.OnSale {
+		assert.Equal(OnSale{ItemID: i + 1, Time: 100}, o)
+	}
+}
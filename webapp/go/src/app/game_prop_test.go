@@ -0,0 +1,237 @@
+package main
+
+import (
+	"app/game/gametest"
+	"app/proptest"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// calcStatus のプロパティテスト
+//
+// ランダムな m_item, adding, buying から calcStatus を計算し、
+// ベンチマーカーの validateGameStatusFormat が検査する性質と、
+// 愚直に計算する参照モデル (refModel) との一致を確認する。
+
+type propCase = gametest.Case
+
+// 時刻 t における状態を定義通りに計算する
+type refModel struct {
+	*propCase
+}
+
+func (m refModel) milliIsu(t int64) *big.Int {
+	x := new(big.Int)
+	for _, a := range m.Addings {
+		if a.Time <= t {
+			x.Add(x, new(big.Int).Mul(str2big(a.Isu), big.NewInt(1000)))
+		}
+	}
+	for _, b := range m.Buyings {
+		item := m.MItems[b.ItemID]
+		x.Sub(x, new(big.Int).Mul(item.GetPrice(b.Ordinal), big.NewInt(1000)))
+		if b.Time <= t {
+			x.Add(x, new(big.Int).Mul(item.GetPower(b.Ordinal), big.NewInt(t-b.Time)))
+		}
+	}
+	return x
+}
+
+func (m refModel) power(t int64, itemID int) *big.Int {
+	x := new(big.Int)
+	for _, b := range m.Buyings {
+		if b.Time <= t && (itemID == 0 || itemID == b.ItemID) {
+			item := m.MItems[b.ItemID]
+			x.Add(x, item.GetPower(b.Ordinal))
+		}
+	}
+	return x
+}
+
+func (m refModel) countBuilt(t int64, itemID int) (built, bought int) {
+	for _, b := range m.Buyings {
+		if b.ItemID == itemID {
+			bought++
+			if b.Time <= t {
+				built++
+			}
+		}
+	}
+	return
+}
+
+// schedule に含まれるべき時刻
+func (m refModel) events() []int64 {
+	ts := map[int64]bool{}
+	for _, a := range m.Addings {
+		ts[a.Time] = true
+	}
+	for _, b := range m.Buyings {
+		ts[b.Time] = true
+	}
+	res := []int64{m.CurrentTime}
+	for t := range ts {
+		if m.CurrentTime < t && t <= m.CurrentTime+1000 {
+			res = append(res, t)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
+// validateGameStatusFormat (bench/src/bench/validation.go) 相当の検査
+func checkStatusFormat(c *propCase, s *GameStatus) error {
+	if len(s.Schedule) == 0 {
+		return fmt.Errorf("schedule is empty")
+	}
+	cTime := s.Schedule[0].Time
+	if cTime != c.CurrentTime {
+		return fmt.Errorf("schedule[0].time = %v, want %v", cTime, c.CurrentTime)
+	}
+	for i := 0; i+1 < len(s.Schedule); i++ {
+		a, b := s.Schedule[i], s.Schedule[i+1]
+		if a.Time >= b.Time {
+			return fmt.Errorf("schedule is not monotonic at %v", i)
+		}
+		if b.MilliIsu.Less(a.MilliIsu) {
+			return fmt.Errorf("milli_isu decreased at %v", i)
+		}
+		if b.TotalPower.Less(a.TotalPower) {
+			return fmt.Errorf("total_power decreased at %v", i)
+		}
+	}
+	if last := s.Schedule[len(s.Schedule)-1].Time; cTime+1000 < last {
+		return fmt.Errorf("schedule exceeds 1000ms: %v", last)
+	}
+
+	if len(s.Items) != len(c.MItems) {
+		return fmt.Errorf("len(items) = %v, want %v", len(s.Items), len(c.MItems))
+	}
+	seen := map[int]bool{}
+	for _, item := range s.Items {
+		if _, ok := c.MItems[item.ItemID]; !ok || seen[item.ItemID] {
+			return fmt.Errorf("invalid item_id %v", item.ItemID)
+		}
+		seen[item.ItemID] = true
+		if item.CountBought < item.CountBuilt {
+			return fmt.Errorf("item %v: count_built > count_bought", item.ItemID)
+		}
+		if item.CountBuilt == 0 && !item.Power.IsZero() {
+			return fmt.Errorf("item %v: power without building", item.ItemID)
+		}
+		for i, b := range item.Building {
+			if b.Time <= cTime || cTime+1000 < b.Time {
+				return fmt.Errorf("item %v: building[%v].time out of range", item.ItemID, i)
+			}
+			if 0 < i && (b.Time <= item.Building[i-1].Time || b.CountBuilt <= item.Building[i-1].CountBuilt) {
+				return fmt.Errorf("item %v: building is not monotonic at %v", item.ItemID, i)
+			}
+		}
+	}
+
+	for _, a := range s.Adding {
+		if a.Isu == "" || a.Time <= cTime {
+			return fmt.Errorf("invalid adding %+v", a)
+		}
+	}
+
+	for _, o := range s.OnSale {
+		if _, ok := c.MItems[o.ItemID]; !ok {
+			return fmt.Errorf("invalid on_sale item_id %v", o.ItemID)
+		}
+		if o.Time != 0 && (o.Time <= cTime || cTime+1000 < o.Time) {
+			return fmt.Errorf("on_sale[item_id = %v].time = %v is not in the future", o.ItemID, o.Time)
+		}
+	}
+	return nil
+}
+
+// 参照モデルとの一致
+func checkStatusModel(c *propCase, s *GameStatus) error {
+	m := refModel{c}
+
+	ts := m.events()
+	if len(s.Schedule) != len(ts) {
+		return fmt.Errorf("len(schedule) = %v, want %v", len(s.Schedule), len(ts))
+	}
+	for i, t := range ts {
+		x := s.Schedule[i]
+		if x.Time != t {
+			return fmt.Errorf("schedule[%v].time = %v, want %v", i, x.Time, t)
+		}
+		if want := big2exp(m.milliIsu(t)); x.MilliIsu != want {
+			return fmt.Errorf("schedule[%v].milli_isu = %v, want %v", i, x.MilliIsu, want)
+		}
+		if want := big2exp(m.power(t, 0)); x.TotalPower != want {
+			return fmt.Errorf("schedule[%v].total_power = %v, want %v", i, x.TotalPower, want)
+		}
+	}
+
+	futureAdding := 0
+	for _, a := range c.Addings {
+		if c.CurrentTime < a.Time {
+			futureAdding++
+		}
+	}
+	if len(s.Adding) != futureAdding {
+		return fmt.Errorf("len(adding) = %v, want %v", len(s.Adding), futureAdding)
+	}
+
+	onSale := map[int]int64{}
+	for _, o := range s.OnSale {
+		onSale[o.ItemID] = o.Time
+	}
+
+	for _, item := range s.Items {
+		built, bought := m.countBuilt(c.CurrentTime, item.ItemID)
+		if item.CountBought != bought || item.CountBuilt != built {
+			return fmt.Errorf("item %v: count_bought/built = %v/%v, want %v/%v", item.ItemID, item.CountBought, item.CountBuilt, bought, built)
+		}
+		if want := big2exp(m.power(c.CurrentTime, item.ItemID)); item.Power != want {
+			return fmt.Errorf("item %v: power = %v, want %v", item.ItemID, item.Power, want)
+		}
+		mi := c.MItems[item.ItemID]
+		price := mi.GetPrice(bought + 1)
+		if want := big2exp(price); item.NextPrice != want {
+			return fmt.Errorf("item %v: next_price = %v, want %v", item.ItemID, item.NextPrice, want)
+		}
+
+		// 購入可能になる最初の時刻
+		need := new(big.Int).Mul(price, big.NewInt(1000))
+		want, wantOK := int64(0), false
+		for t := c.CurrentTime; t <= c.CurrentTime+1000; t++ {
+			if 0 <= m.milliIsu(t).Cmp(need) {
+				want, wantOK = t, true
+				if t == c.CurrentTime {
+					want = 0
+				}
+				break
+			}
+		}
+		got, gotOK := onSale[item.ItemID]
+		if got != want || gotOK != wantOK {
+			return fmt.Errorf("item %v: on_sale = %v (%v), want %v (%v)", item.ItemID, got, gotOK, want, wantOK)
+		}
+	}
+	return nil
+}
+
+func TestCalcStatusProperty(t *testing.T) {
+	proptest.Seeds(t, 300, func(seed int64, r *rand.Rand) {
+		c := gametest.GenCase(r)
+
+		s, err := calcStatus(c.CurrentTime, c.MItems, c.Addings, c.Buyings)
+		if err != nil {
+			t.Fatalf("seed %v: %v", seed, err)
+		}
+		if err := checkStatusFormat(c, s); err != nil {
+			t.Fatalf("seed %v: %v\n%v", seed, err, c)
+		}
+		if err := checkStatusModel(c, s); err != nil {
+			t.Fatalf("seed %v: %v\n%v", seed, err, c)
+		}
+	})
+}
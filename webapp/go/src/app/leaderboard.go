@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ランキングの基準
+const (
+	RankByIsu   = "isu"
+	RankByPower = "power"
+	RankByItems = "items"
+)
+
+type LeaderboardEntry struct {
+	Rank        int         `json:"rank"`
+	RoomName    string      `json:"room_name"`
+	Time        int64       `json:"time"`
+	MilliIsu    Exponential `json:"milli_isu"`
+	TotalPower  Exponential `json:"total_power"`
+	ItemsBought int         `json:"items_bought"`
+}
+
+type Leaderboard struct {
+	By    string             `json:"by"`
+	Rooms []LeaderboardEntry `json:"rooms"`
+}
+
+// 全ルームの最新の状態
+//
+// adding を集計するのではなく、各ルームで GameStatus を計算するたびに更新する。
+// プロセス内のメモリにのみ保持するので、/initialize で空になる。
+type roomRanking struct {
+	mtx     sync.Mutex
+	rooms   map[string]*LeaderboardEntry
+	version int64
+}
+
+var ranking = newRoomRanking()
+
+func newRoomRanking() *roomRanking {
+	return &roomRanking{
+		rooms: map[string]*LeaderboardEntry{},
+	}
+}
+
+func (r *roomRanking) Reset() {
+	r.mtx.Lock()
+	r.rooms = map[string]*LeaderboardEntry{}
+	r.version++
+	r.mtx.Unlock()
+}
+
+// status.Schedule[0] の時点の値でルームの順位を更新する
+func (r *roomRanking) Update(roomName string, status *GameStatus) {
+	if len(status.Schedule) == 0 {
+		return
+	}
+	current := status.Schedule[0]
+
+	bought := 0
+	for _, item := range status.Items {
+		bought += item.CountBought
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	e, ok := r.rooms[roomName]
+	if !ok {
+		e = &LeaderboardEntry{RoomName: roomName}
+		r.rooms[roomName] = e
+	} else if current.Time < e.Time {
+		// 古い status で上書きしない
+		return
+	}
+	changed := !ok || e.MilliIsu != current.MilliIsu || e.TotalPower != current.TotalPower || e.ItemsBought != bought
+	e.Time = current.Time
+	e.MilliIsu = current.MilliIsu
+	e.TotalPower = current.TotalPower
+	e.ItemsBought = bought
+	// 時刻だけが進んだ場合は順位も値も変わらないので送り直さない
+	if changed {
+		r.version++
+	}
+}
+
+func (r *roomRanking) Version() int64 {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.version
+}
+
+// by の順に上位 limit 件を返す。limit <= 0 の場合は全件を返す。
+func (r *roomRanking) Top(by string, limit int) Leaderboard {
+	r.mtx.Lock()
+	entries := make([]LeaderboardEntry, 0, len(r.rooms))
+	for _, e := range r.rooms {
+		entries = append(entries, *e)
+	}
+	r.mtx.Unlock()
+
+	less := func(a, b LeaderboardEntry) bool {
+		switch by {
+		case RankByPower:
+			if a.TotalPower != b.TotalPower {
+				return b.TotalPower.Less(a.TotalPower)
+			}
+		case RankByItems:
+			if a.ItemsBought != b.ItemsBought {
+				return a.ItemsBought > b.ItemsBought
+			}
+		default:
+			if a.MilliIsu != b.MilliIsu {
+				return b.MilliIsu.Less(a.MilliIsu)
+			}
+		}
+		return a.RoomName < b.RoomName
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return less(entries[i], entries[j])
+	})
+
+	if 0 < limit && limit < len(entries) {
+		entries = entries[:limit]
+	}
+	for i := range entries {
+		entries[i].Rank = i + 1
+	}
+	return Leaderboard{By: by, Rooms: entries}
+}
+
+func parseLeaderboardQuery(r *http.Request) (string, int, bool) {
+	by := r.URL.Query().Get("by")
+	switch by {
+	case "":
+		by = RankByIsu
+	case RankByIsu, RankByPower, RankByItems:
+	default:
+		return "", 0, false
+	}
+
+	limit := 100
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return "", 0, false
+		}
+		limit = n
+	}
+	return by, limit, true
+}
+
+func getLeaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	by, limit, ok := parseLeaderboardQuery(r)
+	if !ok {
+		w.WriteHeader(400)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ranking.Top(by, limit))
+}
+
+// 順位か値に変化があれば1秒ごとに Leaderboard を送る
+func wsLeaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	by, limit, ok := parseLeaderboardQuery(r)
+	if !ok {
+		w.WriteHeader(400)
+		return
+	}
+
+	ws, err := websocket.Upgrade(w, r, nil, 1024, 1024)
+	if _, ok := err.(websocket.HandshakeError); ok {
+		log.Println("Failed to upgrade", err)
+		return
+	}
+	go serveLeaderboardConn(ws, by, limit)
+}
+
+func serveLeaderboardConn(ws *websocket.Conn, by string, limit int) {
+	defer ws.Close()
+
+	// クライアントからのメッセージは読み捨て、切断を検知する
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := ws.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	sent := int64(-1)
+	for {
+		if v := ranking.Version(); v != sent {
+			err := ws.WriteJSON(ranking.Top(by, limit))
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			sent = v
+		}
+
+		select {
+		case <-ticker.C:
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaderboard(t *testing.T) {
+	assert := assert.New(t)
+
+	status := func(time int64, isu, power Exponential, bought ...int) *GameStatus {
+		s := &GameStatus{
+			Schedule: []Schedule{Schedule{Time: time, MilliIsu: isu, TotalPower: power}},
+		}
+		for i, n := range bought {
+			s.Items = append(s.Items, Item{ItemID: i + 1, CountBought: n})
+		}
+		return s
+	}
+
+	r := newRoomRanking()
+	r.Update("a", status(100, Exponential{Mantissa: 5, Exponent: 0}, Exponential{Mantissa: 123456789012345, Exponent: 3}, 1, 1))
+	r.Update("b", status(100, Exponential{Mantissa: 123456789012345, Exponent: 1}, Exponential{Mantissa: 3, Exponent: 0}, 5))
+	r.Update("c", status(100, Exponential{Mantissa: 7, Exponent: 0}, Exponential{Mantissa: 3, Exponent: 0}))
+
+	lb := r.Top(RankByIsu, 0)
+	assert.Equal(RankByIsu, lb.By)
+	assert.Len(lb.Rooms, 3)
+	assert.Equal("b", lb.Rooms[0].RoomName)
+	assert.Equal("c", lb.Rooms[1].RoomName)
+	assert.Equal("a", lb.Rooms[2].RoomName)
+	assert.Equal(3, lb.Rooms[2].Rank)
+
+	lb = r.Top(RankByPower, 2)
+	assert.Len(lb.Rooms, 2)
+	assert.Equal("a", lb.Rooms[0].RoomName)
+	assert.Equal("b", lb.Rooms[1].RoomName) // 同点は部屋名順
+
+	lb = r.Top(RankByItems, 0)
+	assert.Equal("b", lb.Rooms[0].RoomName)
+	assert.Equal(5, lb.Rooms[0].ItemsBought)
+	assert.Equal("a", lb.Rooms[1].RoomName)
+
+	// 古い status では更新されない
+	v := r.Version()
+	r.Update("c", status(50, Exponential{Mantissa: 999, Exponent: 20}, Exponential{Mantissa: 0, Exponent: 0}))
+	assert.Equal(v, r.Version())
+	assert.Equal("c", r.Top(RankByIsu, 0).Rooms[1].RoomName)
+
+	r.Update("c", status(200, Exponential{Mantissa: 999, Exponent: 20}, Exponential{Mantissa: 0, Exponent: 0}))
+	assert.Equal("c", r.Top(RankByIsu, 0).Rooms[0].RoomName)
+	assert.NotEqual(v, r.Version())
+
+	// 時刻だけが進んだ status では送り直さない
+	v = r.Version()
+	r.Update("c", status(300, Exponential{Mantissa: 999, Exponent: 20}, Exponential{Mantissa: 0, Exponent: 0}))
+	assert.Equal(v, r.Version())
+	assert.Equal(int64(300), r.Top(RankByIsu, 0).Rooms[0].Time)
+
+	r.Reset()
+	assert.Empty(r.Top(RankByIsu, 0).Rooms)
+}
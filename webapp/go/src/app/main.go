@@ -60,6 +60,8 @@ func getInitializeHandler(w http.ResponseWriter, r *http.Request) {
 	db.MustExec("TRUNCATE TABLE adding")
 	db.MustExec("TRUNCATE TABLE buying")
 	db.MustExec("TRUNCATE TABLE room_time")
+	db.MustExec("TRUNCATE TABLE milestone")
+	ranking.Reset()
 	w.WriteHeader(204)
 }
 
@@ -84,17 +86,21 @@ func wsGameHandler(w http.ResponseWriter, r *http.Request) {
 
 	roomName := vars["room_name"]
 
+	// ?milestone=1 を付けて接続したクライアントにのみ実績を通知する
+	notifyMilestone := r.URL.Query().Get("milestone") != ""
+
 	ws, err := websocket.Upgrade(w, r, nil, 1024, 1024)
 	if _, ok := err.(websocket.HandshakeError); ok {
 		log.Println("Failed to upgrade", err)
 		return
 	}
-	go serveGameConn(ws, roomName)
+	go serveGameConn(ws, roomName, notifyMilestone)
 }
 
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 	initDB()
+	initMilestones()
 
 	r := mux.NewRouter()
 	r.HandleFunc("/initialize", getInitializeHandler)
@@ -102,6 +108,8 @@ func main() {
 	r.HandleFunc("/room/{room_name}", getRoomHandler)
 	r.HandleFunc("/ws/", wsGameHandler)
 	r.HandleFunc("/ws/{room_name}", wsGameHandler)
+	r.HandleFunc("/leaderboard", getLeaderboardHandler)
+	r.HandleFunc("/leaderboard/ws", wsLeaderboardHandler)
 	r.PathPrefix("/").Handler(http.FileServer(http.Dir("../public/")))
 
 	log.Fatal(http.ListenAndServe(":5000", handlers.LoggingHandler(os.Stderr, r)))
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 実績の種類
+const (
+	MilestoneMilliIsu   = "milli_isu"   // schedule[0].milli_isu が閾値以上
+	MilestoneTotalPower = "total_power" // schedule[0].total_power が閾値以上
+	MilestoneItemCount  = "item_count"  // item の count_built が閾値以上 (ItemID = 0 はいずれかのアイテム)
+)
+
+// 実績の定義。Threshold は10進数の文字列で、milli_isu の場合は椅子の数(ミリ椅子ではない)で指定する。
+type Milestone struct {
+	ID        string `json:"id"`
+	Kind      string `json:"kind"`
+	ItemID    int    `json:"item_id"`
+	Threshold string `json:"threshold"`
+
+	threshold Exponential
+	count     int
+}
+
+// ルームで達成済みの実績
+type UnlockedMilestone struct {
+	ID   string `json:"id" db:"milestone_id"`
+	Time int64  `json:"time" db:"time"`
+}
+
+// 実績達成時にクライアントへ送るメッセージ。GameStatus, GameResponse と区別するため type を持つ。
+type MilestoneEvent struct {
+	Type       string              `json:"type"`
+	Milestones []UnlockedMilestone `json:"milestones"`
+}
+
+var defaultMilestones = []Milestone{
+	{ID: "first-item", Kind: MilestoneItemCount, ItemID: 0, Threshold: "1"},
+	{ID: "isu-1e3", Kind: MilestoneMilliIsu, Threshold: "1000"},
+	{ID: "isu-1e10", Kind: MilestoneMilliIsu, Threshold: "10000000000"},
+	{ID: "isu-1e100", Kind: MilestoneMilliIsu, Threshold: "1" + strings.Repeat("0", 100)},
+	{ID: "power-1e6", Kind: MilestoneTotalPower, Threshold: "1000000"},
+	{ID: "item-100", Kind: MilestoneItemCount, ItemID: 0, Threshold: "100"},
+}
+
+var milestones []Milestone
+
+// ISU_MILESTONE_FILE が指定されていればそのJSONファイルから実績の定義を読み込む
+func initMilestones() {
+	ms := defaultMilestones
+
+	path := os.Getenv("ISU_MILESTONE_FILE")
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ms = nil
+		err = json.Unmarshal(b, &ms)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var err error
+	milestones, err = prepareMilestones(ms)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Loaded %d milestones.", len(milestones))
+}
+
+func prepareMilestones(ms []Milestone) ([]Milestone, error) {
+	res := make([]Milestone, 0, len(ms))
+	seen := map[string]bool{}
+	for _, m := range ms {
+		if m.ID == "" || seen[m.ID] {
+			return nil, fmt.Errorf("invalid milestone id %q", m.ID)
+		}
+		seen[m.ID] = true
+
+		x, ok := new(big.Int).SetString(m.Threshold, 10)
+		if !ok || x.Sign() < 0 {
+			return nil, fmt.Errorf("invalid milestone threshold %q (%s)", m.Threshold, m.ID)
+		}
+
+		switch m.Kind {
+		case MilestoneMilliIsu:
+			x.Mul(x, big.NewInt(1000))
+		case MilestoneTotalPower, MilestoneItemCount:
+		default:
+			return nil, fmt.Errorf("invalid milestone kind %q (%s)", m.Kind, m.ID)
+		}
+		if m.Kind == MilestoneItemCount {
+			if x.BitLen() > 31 {
+				return nil, fmt.Errorf("too large item_count threshold %q (%s)", m.Threshold, m.ID)
+			}
+			m.count = int(x.Int64())
+		}
+
+		m.threshold = big2exp(x)
+		res = append(res, m)
+	}
+	return res, nil
+}
+
+// 時刻 schedule[0].time の時点で達成している実績のIDを返す
+func achievedMilestones(status *GameStatus, ms []Milestone) []string {
+	if len(status.Schedule) == 0 {
+		return nil
+	}
+	current := status.Schedule[0]
+
+	achieved := []string{}
+	for _, m := range ms {
+		ok := false
+		switch m.Kind {
+		case MilestoneMilliIsu:
+			ok = !current.MilliIsu.Less(m.threshold)
+		case MilestoneTotalPower:
+			ok = !current.TotalPower.Less(m.threshold)
+		case MilestoneItemCount:
+			for _, item := range status.Items {
+				if m.ItemID != 0 && m.ItemID != item.ItemID {
+					continue
+				}
+				if m.count <= item.CountBuilt {
+					ok = true
+					break
+				}
+			}
+		}
+		if ok {
+			achieved = append(achieved, m.ID)
+		}
+	}
+	return achieved
+}
+
+func getUnlockedMilestones(roomName string) ([]UnlockedMilestone, error) {
+	unlocked := []UnlockedMilestone{}
+	err := db.Select(&unlocked, "SELECT milestone_id, time FROM milestone WHERE room_name = ? ORDER BY time, milestone_id", roomName)
+	if err != nil {
+		return nil, err
+	}
+	return unlocked, nil
+}
+
+// 新たに達成した実績を記録し、記録済みの達成時刻と共に返す
+//
+// 同じルームの別の接続が先に記録している場合があるので、時刻は常にDBから読み直す。
+func unlockMilestones(roomName string, ids []string, t int64) ([]UnlockedMilestone, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		_, err := tx.Exec("INSERT IGNORE INTO milestone(room_name, milestone_id, time) VALUES (?, ?, ?)", roomName, id, t)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	query, args, err := sqlx.In("SELECT milestone_id, time FROM milestone WHERE room_name = ? AND milestone_id IN (?) ORDER BY time, milestone_id", roomName, ids)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	unlocked := []UnlockedMilestone{}
+	err = tx.Select(&unlocked, query, args...)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return unlocked, nil
+}
+
+// 接続ごとの実績の状態
+type milestoneTracker struct {
+	roomName string
+	notified map[string]bool
+}
+
+func newMilestoneTracker(roomName string) (*milestoneTracker, []UnlockedMilestone, error) {
+	unlocked, err := getUnlockedMilestones(roomName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mt := &milestoneTracker{
+		roomName: roomName,
+		notified: map[string]bool{},
+	}
+	for _, u := range unlocked {
+		mt.notified[u.ID] = true
+	}
+	return mt, unlocked, nil
+}
+
+// status で新たに達成された実績を記録して返す。無ければ nil を返す。
+func (mt *milestoneTracker) update(status *GameStatus) ([]UnlockedMilestone, error) {
+	var ids []string
+	for _, id := range achievedMilestones(status, milestones) {
+		if !mt.notified[id] {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	unlocked, err := unlockMilestones(mt.roomName, ids, status.Schedule[0].Time)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range unlocked {
+		mt.notified[u.ID] = true
+	}
+	return unlocked, nil
+}
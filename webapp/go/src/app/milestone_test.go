@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrepareMilestones(t *testing.T) {
+	assert := assert.New(t)
+
+	ms, err := prepareMilestones(defaultMilestones)
+	assert.Nil(err)
+	assert.Len(ms, len(defaultMilestones))
+
+	_, err = prepareMilestones([]Milestone{{ID: "a", Kind: "unknown", Threshold: "1"}})
+	assert.NotNil(err)
+
+	_, err = prepareMilestones([]Milestone{{ID: "a", Kind: MilestoneMilliIsu, Threshold: "1e3"}})
+	assert.NotNil(err)
+
+	_, err = prepareMilestones([]Milestone{
+		{ID: "a", Kind: MilestoneMilliIsu, Threshold: "1"},
+		{ID: "a", Kind: MilestoneTotalPower, Threshold: "1"},
+	})
+	assert.NotNil(err)
+}
+
+func TestAchievedMilestones(t *testing.T) {
+	assert := assert.New(t)
+
+	ms, err := prepareMilestones([]Milestone{
+		{ID: "isu-10", Kind: MilestoneMilliIsu, Threshold: "10"},
+		{ID: "isu-1e20", Kind: MilestoneMilliIsu, Threshold: "100000000000000000000"},
+		{ID: "power-5", Kind: MilestoneTotalPower, Threshold: "5"},
+		{ID: "first-item", Kind: MilestoneItemCount, Threshold: "1"},
+		{ID: "item2-3", Kind: MilestoneItemCount, ItemID: 2, Threshold: "3"},
+	})
+	assert.Nil(err)
+
+	x := mItem{
+		ItemID: 1,
+		Power1: 0, Power2: 1, Power3: 0, Power4: 10,
+		Price1: 0, Price2: 1, Price3: 0, Price4: 10,
+	}
+	y := mItem{
+		ItemID: 2,
+		Power1: 0, Power2: 0, Power3: 0, Power4: 1,
+		Price1: 0, Price2: 0, Price3: 0, Price4: 1,
+	}
+	mItems := map[int]mItem{1: x, 2: y}
+
+	s, err := calcStatus(0, mItems, []Adding{}, []Buying{})
+	assert.Nil(err)
+	assert.Empty(achievedMilestones(s, ms))
+
+	addings := []Adding{Adding{Time: 0, Isu: "30"}}
+	buyings := []Buying{
+		Buying{ItemID: 1, Ordinal: 1, Time: 0},
+		Buying{ItemID: 2, Ordinal: 1, Time: 0},
+		Buying{ItemID: 2, Ordinal: 2, Time: 0},
+	}
+	s, err = calcStatus(0, mItems, addings, buyings)
+	assert.Nil(err)
+	assert.Equal([]string{"isu-10", "power-5", "first-item"}, achievedMilestones(s, ms))
+
+	// 時刻 100 で建造される3個目は schedule[0] の時点では数えない
+	buyings = append(buyings, Buying{ItemID: 2, Ordinal: 3, Time: 100})
+	s, err = calcStatus(0, mItems, addings, buyings)
+	assert.Nil(err)
+	assert.NotContains(achievedMilestones(s, ms), "item2-3")
+
+	s, err = calcStatus(100, mItems, addings, buyings)
+	assert.Nil(err)
+	assert.Contains(achievedMilestones(s, ms), "item2-3")
+
+	addings = append(addings, Adding{Time: 0, Isu: "100000000000000000000"})
+	s, err = calcStatus(100, mItems, addings, buyings)
+	assert.Nil(err)
+	assert.Contains(achievedMilestones(s, ms), "isu-1e20")
+}
@@ -0,0 +1,23 @@
+// Package proptest は乱数で入力を作るテストの共通処理。
+//
+// webapp, app/game とベンチマーカーのテストから使う。
+package proptest
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// Seeds は seed = 1, ..., n の乱数で順に f を呼ぶ。-short のときは n/10 回にする。
+// 失敗した seed があればそこで止める。
+func Seeds(t *testing.T, n int, f func(seed int64, r *rand.Rand)) {
+	if testing.Short() && 10 <= n {
+		n /= 10
+	}
+	for seed := int64(1); seed <= int64(n); seed++ {
+		f(seed, rand.New(rand.NewSource(seed)))
+		if t.Failed() {
+			return
+		}
+	}
+}